@@ -0,0 +1,303 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// book is the input of a run: the parts it is synthesized into and the
+// metadata the output is tagged with.
+type book struct {
+	Title   string
+	Author  string
+	Album   string
+	Track   string
+	Comment string
+	Cover   *coverImage
+	Parts   []part
+}
+
+type coverImage struct {
+	Data     []byte
+	MIMEType string
+}
+
+type frontMatter struct {
+	Title   string
+	Author  string
+	Album   string
+	Track   string
+	Comment string
+	Cover   string
+}
+
+var atxHeading = regexp.MustCompile(`^(#{1,6})[ \t]+(.*?)[ \t#]*$`)
+
+// readBook reads the input file into the parts of the run. Plain input is a
+// single part unless it is split with --split-by or bound into an M4B, where
+// each top level heading starts a chapter. An EPUB has one part per chapter.
+func readBook(flags Flags) (book, error) {
+	b, chapters, err := readChapters(flags)
+	if err != nil {
+		return b, err
+	}
+	b.Title = cmp.Or(flags.Title, b.Title, strings.TrimSuffix(filepath.Base(flags.InputFile), filepath.Ext(flags.InputFile)))
+	b.Author = cmp.Or(flags.Artist, b.Author)
+	b.Album = cmp.Or(flags.Album, b.Album)
+	b.Track = cmp.Or(flags.Track, b.Track)
+	b.Comment = cmp.Or(flags.Comment, b.Comment)
+	if b.Track != "" && !trackNumber.MatchString(b.Track) {
+		return b, fmt.Errorf("invalid track %q in front matter, expected a number such as 3 or 3/12", b.Track)
+	}
+	if flags.Cover != "" && isM4B(flags.OutputFile) {
+		if b.Cover, err = loadCover(flags.Cover); err != nil {
+			return b, err
+		}
+	}
+
+	output := flags.OutputFile
+	if isM4B(output) {
+		output = m4bPartsOutput(flags)
+	}
+
+	if chapters == nil {
+		segments, err := readSegments(flags)
+		if err != nil {
+			return b, err
+		}
+		if isM4B(flags.OutputFile) {
+			output = partOutput(output, 1, 1, b.Title)
+		}
+		b.Parts = []part{{Title: b.Title, Output: output, Segments: segments}}
+		return b, nil
+	}
+
+	chunk, err := loadChunkFunc(flags)
+	if err != nil {
+		return b, err
+	}
+	b.Parts = make([]part, 0, len(chapters))
+	for i, chapter := range chapters {
+		title := chapter.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		segments, err := splitSegments(chapter.Text, segment{}, flags, chunk)
+		if err != nil {
+			return b, fmt.Errorf("unable to parse markup in chapter %q: %w", title, err)
+		}
+		name := partOutput(output, i+1, len(chapters), title)
+		if flags.SplitBy > 0 && !isM4B(flags.OutputFile) {
+			name = sectionOutput(output, i+1, len(chapters), title)
+		}
+		b.Parts = append(b.Parts, part{
+			Title:    title,
+			Output:   name,
+			Segments: prepareSegments(segments, flags),
+		})
+	}
+	return b, nil
+}
+
+// readChapters reads the metadata and cover of the input. It also returns
+// the chapters of an EPUB, or of Markdown that is split with --split-by or
+// bound into an M4B, and nil when the input is read as a single part.
+func readChapters(flags Flags) (book, []chapter, error) {
+	var b book
+	if isEPUB(flags.InputFile) {
+		if flags.Script {
+			return b, nil, fmt.Errorf("--script cannot be used with EPUB input")
+		}
+		if flags.SplitBy > 0 {
+			return b, nil, fmt.Errorf("--split-by only applies to Markdown input, an EPUB is already split into chapters")
+		}
+		epub, err := readEPUB(flags.InputFile)
+		if err != nil {
+			return b, nil, err
+		}
+		b.Title, b.Author, b.Cover = epub.Title, epub.Author, epub.Cover
+		return b, epub.Chapters, nil
+	}
+	if flags.Script {
+		if flags.SplitBy > 0 {
+			return b, nil, fmt.Errorf("--split-by cannot be used with --script")
+		}
+		return b, nil, nil
+	}
+
+	data, err := os.ReadFile(flags.InputFile)
+	if err != nil {
+		return b, nil, fmt.Errorf("unable to open input file: %w", err)
+	}
+	matter, text := splitFrontMatter(string(data))
+	b.Title, b.Author, b.Album, b.Track, b.Comment = matter.Title, matter.Author, matter.Album, matter.Track, matter.Comment
+	if !isM4B(flags.OutputFile) {
+		if flags.SplitBy > 0 {
+			return b, splitHeadings(text, flags.SplitBy), nil
+		}
+		return b, nil, nil
+	}
+	if matter.Cover != "" && flags.Cover == "" {
+		cover := matter.Cover
+		if !filepath.IsAbs(cover) {
+			cover = filepath.Join(filepath.Dir(flags.InputFile), cover)
+		}
+		if b.Cover, err = loadCover(cover); err != nil {
+			return b, nil, err
+		}
+	}
+	return b, splitHeadings(text, flags.SplitBy), nil
+}
+
+// splitFrontMatter separates a leading YAML block between --- lines from
+// Markdown. Text that does not start with such a block is returned unchanged.
+func splitFrontMatter(text string) (frontMatter, string) {
+	var matter frontMatter
+	lines := strings.SplitAfter(text, "\n")
+	if len(lines) < 2 || strings.TrimRight(lines[0], "\r\n") != "---" {
+		return matter, text
+	}
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if line := strings.TrimRight(lines[i], "\r\n"); line == "---" || line == "..." {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return matter, text
+	}
+
+	document, err := parseYAML([]byte(strings.Join(lines[1:end], "")))
+	if err != nil {
+		slog.Warn("Unable to parse front matter, reading it as text", "error", err)
+		return matter, text
+	}
+	fields, ok := document.(map[string]any)
+	if !ok {
+		return matter, text
+	}
+	matter.Title = yamlText(fields["title"])
+	matter.Author = cmp.Or(yamlText(fields["author"]), yamlText(fields["authors"]), yamlText(fields["artist"]))
+	matter.Album = yamlText(fields["album"])
+	matter.Track = yamlText(fields["track"])
+	matter.Comment = yamlText(fields["comment"])
+	matter.Cover = yamlText(fields["cover"])
+	return matter, strings.Join(lines[end+1:], "")
+}
+
+// yamlText returns a scalar, or the scalars of a sequence joined with commas.
+func yamlText(value any) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []any:
+		var items []string
+		for _, item := range value {
+			if text, ok := item.(string); ok && text != "" {
+				items = append(items, text)
+			}
+		}
+		return strings.Join(items, ", ")
+	}
+	return ""
+}
+
+// splitHeadings splits Markdown at ATX headings of the given level, or of the
+// highest level used when level is 0. Text before the first heading stays with
+// the first chapter, and headings in fenced code blocks are ignored.
+func splitHeadings(text string, level int) []chapter {
+	type heading struct {
+		line, level int
+		title       string
+	}
+	lines := strings.SplitAfter(text, "\n")
+	var headings []heading
+	fenced := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		if m := atxHeading.FindStringSubmatch(strings.TrimRight(line, "\r\n")); m != nil {
+			headings = append(headings, heading{line: i, level: len(m[1]), title: headingTitle(m[2])})
+		}
+	}
+
+	if level == 0 {
+		level = 7
+		for _, h := range headings {
+			level = min(level, h.level)
+		}
+	}
+	var bounds []heading
+	for _, h := range headings {
+		if h.level == level {
+			bounds = append(bounds, h)
+		}
+	}
+	if len(bounds) == 0 {
+		return []chapter{{Text: text}}
+	}
+
+	chapters := make([]chapter, 0, len(bounds))
+	for i, h := range bounds {
+		start, end := h.line, len(lines)
+		if i == 0 {
+			start = 0
+		}
+		if i+1 < len(bounds) {
+			end = bounds[i+1].line
+		}
+		chapters = append(chapters, chapter{Title: h.title, Text: strings.Join(lines[start:end], "")})
+	}
+	return chapters
+}
+
+// headingTitle removes emphasis and code markers from a heading.
+func headingTitle(heading string) string {
+	heading = strings.NewReplacer("**", "", "__", "", "*", "", "`", "").Replace(heading)
+	return strings.Join(strings.Fields(heading), " ")
+}
+
+func loadCover(path string) (*coverImage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read cover: %w", err)
+	}
+	return newCoverImage(data, path)
+}
+
+func newCoverImage(data []byte, name string) (*coverImage, error) {
+	mimeType := http.DetectContentType(data)
+	if mimeType != "image/jpeg" && mimeType != "image/png" {
+		return nil, fmt.Errorf("cover %s must be a JPEG or PNG image, not %s", name, mimeType)
+	}
+	return &coverImage{Data: data, MIMEType: mimeType}, nil
+}
+
+func (c *coverImage) extension() string {
+	if c.MIMEType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// warnCover logs a cover image that cannot be embedded and drops it.
+func warnCover(cover *coverImage, err error) *coverImage {
+	if err != nil {
+		slog.Warn("Ignoring cover image", "error", err)
+		return nil
+	}
+	return cover
+}
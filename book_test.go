@@ -0,0 +1,173 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitFrontMatter(t *testing.T) {
+	matter, text := splitFrontMatter("---\ntitle: The Book\nauthors:\n  - Ann Author\n  - Bo Writer\nalbum: Collected Tales\ntrack: 2/5\ncomment: Unabridged\ncover: art/cover.jpg\n---\n# One\n")
+	expected := frontMatter{
+		Title:   "The Book",
+		Author:  "Ann Author, Bo Writer",
+		Album:   "Collected Tales",
+		Track:   "2/5",
+		Comment: "Unabridged",
+		Cover:   "art/cover.jpg",
+	}
+	if matter != expected {
+		t.Errorf("Expected %+v, got %+v", expected, matter)
+	}
+	if text != "# One\n" {
+		t.Errorf("Expected the text after the front matter, got %q", text)
+	}
+
+	matter, text = splitFrontMatter("---\ntitle: A Title Long Enough\n  to Wrap # comment\n---\nText\n")
+	if matter.Title != "A Title Long Enough to Wrap" || text != "Text\n" {
+		t.Errorf("Expected a wrapped title to be folded, got %+v and %q", matter, text)
+	}
+
+	for _, input := range []string{"# One\n---\n", "---\ntitle: Unclosed\n", "---\n- a list\n---\ntext"} {
+		if matter, text := splitFrontMatter(input); matter != (frontMatter{}) || text != input {
+			t.Errorf("Expected %q to be returned unchanged, got %+v and %q", input, matter, text)
+		}
+	}
+}
+
+func TestSplitHeadings(t *testing.T) {
+	text := "Preface.\n\n## One **Start**\nFirst.\n```\n## Not a heading\n```\n### Detail\n## Two ##\nSecond.\n"
+	expected := []chapter{
+		{Title: "One Start", Text: "Preface.\n\n## One **Start**\nFirst.\n```\n## Not a heading\n```\n### Detail\n"},
+		{Title: "Two", Text: "## Two ##\nSecond.\n"},
+	}
+	if chapters := splitHeadings(text, 0); !reflect.DeepEqual(chapters, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, chapters)
+	}
+
+	if chapters := splitHeadings(text, 3); len(chapters) != 1 || chapters[0].Title != "Detail" {
+		t.Errorf("Expected one chapter at level 3, got %+v", chapters)
+	}
+	if chapters := splitHeadings("No headings.", 0); !reflect.DeepEqual(chapters, []chapter{{Text: "No headings."}}) {
+		t.Errorf("Expected the whole text as one chapter, got %+v", chapters)
+	}
+}
+
+func TestReadBookM4B(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "book.md")
+	markdown := "---\ntitle: The Book\nauthor: Ann Author\ncover: cover.png\n---\n# Opening\nHello.\n# The Return\nGoodbye.\n"
+	if err := os.WriteFile(input, []byte(markdown), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cover.png"), []byte(testPNG), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags := Flags{InputFile: input, OutputFile: "out/book.m4b", FormatOption: "mp3", NoLexicon: true}
+	b, err := readBook(flags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if b.Title != "The Book" || b.Author != "Ann Author" {
+		t.Errorf("Unexpected title %q and author %q", b.Title, b.Author)
+	}
+	if b.Cover == nil || b.Cover.MIMEType != "image/png" {
+		t.Errorf("Expected the PNG cover from the front matter, got %+v", b.Cover)
+	}
+	var outputs []string
+	for _, part := range b.Parts {
+		outputs = append(outputs, part.Output)
+	}
+	if expected := []string{"out/book_01_opening.mp3", "out/book_02_the-return.mp3"}; !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Expected outputs %v, got %v", expected, outputs)
+	}
+
+	flags.OutputFile = "out/book.mp3"
+	b, err = readBook(flags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(b.Parts) != 1 || b.Cover != nil || b.Title != "The Book" {
+		t.Errorf("Expected a single part without a cover, got %+v", b)
+	}
+	for _, segment := range b.Parts[0].Segments {
+		if strings.Contains(segment.Text, "title:") {
+			t.Errorf("Expected the front matter to be left out, got %q", segment.Text)
+		}
+	}
+
+	flags.Title, flags.Artist = "Another Title", "Bo Writer"
+	if b, err = readBook(flags); err != nil || b.Title != "Another Title" || b.Author != "Bo Writer" {
+		t.Errorf("Expected the flags to override the front matter, got %q and %q, %v", b.Title, b.Author, err)
+	}
+
+	flags.OutputFile = "out/book.m4b"
+	flags.Cover = filepath.Join(dir, "book.md")
+	if _, err := readBook(flags); err == nil {
+		t.Error("Expected an error for a cover that is not an image")
+	}
+}
+
+func TestReadBookSplitBy(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "guide.md")
+	markdown := "# Guide\nIntro.\n## Installing the Agent\nRun it.\n## Configuring\nEdit it.\n"
+	if err := os.WriteFile(input, []byte(markdown), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	flags := Flags{InputFile: input, OutputFile: filepath.Join(dir, "out", "guide.mp3"), SplitBy: 2}
+	b, err := readBook(flags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var titles, outputs []string
+	for _, part := range b.Parts {
+		titles = append(titles, part.Title)
+		outputs = append(outputs, part.Output)
+	}
+	if expected := []string{"Installing the Agent", "Configuring"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected sections %q, got %q", expected, titles)
+	}
+	expected := []string{filepath.Join(dir, "out", "01-installing-the-agent.mp3"), filepath.Join(dir, "out", "02-configuring.mp3")}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Expected outputs %q, got %q", expected, outputs)
+	}
+
+	flags.SplitBy = 1
+	if b, err := readBook(flags); err != nil || len(b.Parts) != 1 || b.Parts[0].Title != "Guide" {
+		t.Errorf("Expected one section at h1, got %+v and %v", b.Parts, err)
+	}
+
+	flags.Script = true
+	if _, err := readBook(flags); err == nil {
+		t.Errorf("Expected an error for --split-by with --script, got nil")
+	}
+}
+
+func TestWriteSectionIndex(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"01-intro.mp3", "02-setup_part01.mp3", "02-setup_part02.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := book{Title: "Guide", Parts: []part{
+		{Title: "Intro [draft]", Output: filepath.Join(dir, "01-intro.mp3")},
+		{Title: "Setup", Output: filepath.Join(dir, "02-setup.mp3")},
+	}}
+	index := sectionIndex(filepath.Join(dir, "guide.mp3"))
+	if err := writeSectionIndex(index, b); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "guide_index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "# Guide\n\n1. [Intro \\[draft\\]](01-intro.mp3)\n2. Setup\n   - [Part 1](02-setup_part01.mp3)\n   - [Part 2](02-setup_part02.mp3)\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, data)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxCaptionLength is the most characters shown at once, two lines of
+	// the usual 42 character caption width.
+	maxCaptionLength = 84
+	captionLine      = 42
+)
+
+var (
+	sentenceEnd = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s+`)
+	// captionMarkup is Markdown syntax that is read past rather than spoken.
+	captionMarkup = strings.NewReplacer("**", "", "__", "", "*", "", "`", "")
+)
+
+// caption is a cue shown from Start to End.
+type caption struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// captionTrack collects the cues of the audio synthesized in a run. Each
+// chunk added is placed after the audio added before it.
+type captionTrack struct {
+	Cues   []caption
+	Offset time.Duration
+}
+
+func isCaptionFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".vtt" || ext == ".srt"
+}
+
+// add spreads the duration of each chunk across its sentences in proportion
+// to their length. durations holds the measured length of each segment's
+// audio, and consecutive chunks overlap by the crossfade.
+func (c *captionTrack) add(segments []segment, durations []time.Duration, flags Flags) {
+	if c == nil || len(durations) != len(segments) {
+		return
+	}
+	for i, segment := range segments {
+		if i > 0 {
+			c.Offset -= flags.Crossfade
+		}
+		if segment.Pause == 0 {
+			texts := captionTexts(spokenText(segment.Text, flags))
+			total := 0
+			for _, text := range texts {
+				total += utf8.RuneCountInString(text)
+			}
+			start, spoken := c.Offset, 0
+			for _, text := range texts {
+				spoken += utf8.RuneCountInString(text)
+				end := c.Offset + time.Duration(float64(durations[i])*float64(spoken)/float64(total))
+				c.Cues = append(c.Cues, caption{Start: start, End: end, Text: wrapCaption(text)})
+				start = end
+			}
+		}
+		c.Offset += durations[i]
+	}
+}
+
+// captionTexts splits text into sentences, and sentences too long to show at
+// once into pieces of about equal length.
+func captionTexts(text string) []string {
+	var texts []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(captionMarkup.Replace(line))
+		if m := atxHeading.FindStringSubmatch(line); m != nil {
+			line = m[2]
+		}
+		start := 0
+		for _, end := range sentenceEnd.FindAllStringIndex(line+" ", -1) {
+			// A quoted question or exclamation can go on, as in "Why?" he asked.
+			if next, _ := utf8.DecodeRuneInString(line[min(end[1], len(line)):]); unicode.IsLower(next) {
+				continue
+			}
+			sentence := strings.Join(strings.Fields(line[start:min(end[1], len(line))]), " ")
+			start = end[1]
+			if sentence == "" {
+				continue
+			}
+			texts = append(texts, splitCaption(sentence)...)
+		}
+		if rest := strings.Join(strings.Fields(line[min(start, len(line)):]), " "); rest != "" {
+			texts = append(texts, splitCaption(rest)...)
+		}
+	}
+	return texts
+}
+
+func splitCaption(sentence string) []string {
+	length := utf8.RuneCountInString(sentence)
+	if length <= maxCaptionLength {
+		return []string{sentence}
+	}
+	pieces := (length + maxCaptionLength - 1) / maxCaptionLength
+	target := (length + pieces - 1) / pieces
+
+	var texts []string
+	var piece []string
+	size := 0
+	for _, word := range strings.Fields(sentence) {
+		words := utf8.RuneCountInString(word)
+		if size > 0 && size+1+words > target && len(texts) < pieces-1 {
+			texts = append(texts, strings.Join(piece, " "))
+			piece, size = nil, 0
+		}
+		if size > 0 {
+			size++
+		}
+		piece = append(piece, word)
+		size += words
+	}
+	return append(texts, strings.Join(piece, " "))
+}
+
+// wrapCaption breaks a cue longer than one caption line into two lines of
+// about equal length.
+func wrapCaption(text string) string {
+	if utf8.RuneCountInString(text) <= captionLine {
+		return text
+	}
+	middle := len(text) / 2
+	before := strings.LastIndex(text[:middle], " ")
+	after := strings.Index(text[middle:], " ")
+	switch {
+	case before < 0 && after < 0:
+		return text
+	case after < 0 || (before >= 0 && middle-before <= after):
+		return text[:before] + "\n" + text[before+1:]
+	default:
+		return text[:middle+after] + "\n" + text[middle+after+1:]
+	}
+}
+
+// write saves the cues as WebVTT or SubRip, chosen by the file extension.
+func (c *captionTrack) write(name string) error {
+	if c == nil {
+		return nil
+	}
+	vtt := strings.EqualFold(filepath.Ext(name), ".vtt")
+	var captions strings.Builder
+	if vtt {
+		captions.WriteString("WEBVTT\n\n")
+	}
+	for i, cue := range c.Cues {
+		if !vtt {
+			fmt.Fprintf(&captions, "%d\n", i+1)
+		}
+		fmt.Fprintf(&captions, "%s --> %s\n%s\n\n", captionTime(cue.Start, vtt), captionTime(cue.End, vtt), cue.Text)
+	}
+	if err := os.WriteFile(name, []byte(captions.String()), 0o644); err != nil {
+		return fmt.Errorf("unable to write captions: %w", err)
+	}
+	return nil
+}
+
+// captionTime formats d as 00:01:02.345 for WebVTT or 00:01:02,345 for SubRip.
+func captionTime(d time.Duration, vtt bool) string {
+	d = max(d, 0)
+	ms := d.Milliseconds()
+	separator := ","
+	if vtt {
+		separator = "."
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, separator, ms%1000)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCaptionTexts(t *testing.T) {
+	text := "# The *Return*\nShe came back. \"Why?\" he asked!   Nobody knew…\n\nThe end"
+	expected := []string{"The Return", "She came back.", "\"Why?\" he asked!", "Nobody knew…", "The end"}
+	if texts := captionTexts(text); !reflect.DeepEqual(texts, expected) {
+		t.Errorf("Expected %q, got %q", expected, texts)
+	}
+
+	long := strings.TrimSpace(strings.Repeat("word ", 40))
+	texts := captionTexts(long)
+	if len(texts) != 3 {
+		t.Fatalf("Expected a long sentence in 3 pieces, got %q", texts)
+	}
+	for _, text := range texts {
+		if len(text) > maxCaptionLength {
+			t.Errorf("Expected pieces of at most %d characters, got %q", maxCaptionLength, text)
+		}
+	}
+	if strings.Join(texts, " ") != long {
+		t.Errorf("Expected the pieces to keep every word")
+	}
+}
+
+func TestWrapCaption(t *testing.T) {
+	if text := wrapCaption("Short line."); text != "Short line." {
+		t.Errorf("Expected a short caption unchanged, got %q", text)
+	}
+	text := wrapCaption("It was a dark and stormy night, or so they said.")
+	if text != "It was a dark and stormy\nnight, or so they said." {
+		t.Errorf("Expected two balanced lines, got %q", text)
+	}
+}
+
+func TestCaptionTrack(t *testing.T) {
+	track := &captionTrack{}
+	segments := []segment{
+		{Text: "One two. Three four five."},
+		{Pause: 500 * time.Millisecond},
+		{Text: "Last."},
+	}
+	flags := Flags{Crossfade: 100 * time.Millisecond}
+	track.add(segments, []time.Duration{3 * time.Second, 500 * time.Millisecond, time.Second}, flags)
+
+	expected := []caption{
+		{Start: 0, End: time.Second, Text: "One two."},
+		{Start: time.Second, End: 3 * time.Second, Text: "Three four five."},
+		{Start: 3300 * time.Millisecond, End: 4300 * time.Millisecond, Text: "Last."},
+	}
+	if !reflect.DeepEqual(track.Cues, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, track.Cues)
+	}
+
+	track.add(segments[2:], nil, flags)
+	if len(track.Cues) != 3 {
+		t.Errorf("Expected unmeasured chunks to be left out, got %d cues", len(track.Cues))
+	}
+
+	dir := t.TempDir()
+	vtt := filepath.Join(dir, "captions.vtt")
+	if err := track.write(vtt); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _ := os.ReadFile(vtt)
+	if !strings.HasPrefix(string(data), "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nOne two.\n\n") {
+		t.Errorf("Unexpected WebVTT:\n%s", data)
+	}
+
+	srt := filepath.Join(dir, "captions.srt")
+	if err := track.write(srt); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, _ = os.ReadFile(srt)
+	if !strings.Contains(string(data), "3\n00:00:03,300 --> 00:00:04,300\nLast.\n\n") {
+		t.Errorf("Unexpected SubRip:\n%s", data)
+	}
+}
+
+func TestCaptionTrackBuffer(t *testing.T) {
+	track := &captionTrack{}
+	flags := Flags{BufferTextFlag: true, BufferStart: "Begin Text", BufferEnd: "End Text"}
+	segments := []segment{{Text: bufferFor(flags).Wrap([]string{"One two. Six six."})[0]}}
+	track.add(segments, []time.Duration{2 * time.Second}, flags)
+
+	expected := []caption{
+		{Start: 0, End: time.Second, Text: "One two."},
+		{Start: time.Second, End: 2 * time.Second, Text: "Six six."},
+	}
+	if !reflect.DeepEqual(track.Cues, expected) {
+		t.Errorf("Expected the buffer phrases left out of the cues, got %+v", track.Cues)
+	}
+
+	kept := &captionTrack{}
+	flags.KeepBuffer = true
+	kept.add(segments, []time.Duration{2 * time.Second}, flags)
+	if len(kept.Cues) == 0 || !strings.HasPrefix(kept.Cues[0].Text, "Begin Text") {
+		t.Errorf("Expected kept buffer phrases in the cues, got %+v", kept.Cues)
+	}
+}
+
+func TestCaptionTime(t *testing.T) {
+	d := time.Hour + 2*time.Minute + 3*time.Second + 45*time.Millisecond
+	if text := captionTime(d, true); text != "01:02:03.045" {
+		t.Errorf("Expected 01:02:03.045, got %s", text)
+	}
+	if text := captionTime(d, false); text != "01:02:03,045" {
+		t.Errorf("Expected 01:02:03,045, got %s", text)
+	}
+}
+
+func TestSynthesizeFileCaptions(t *testing.T) {
+	wav := makeWAV(24000, 1, 16, 48000, 96000)
+	config := Config{
+		OpenAIAPIKey: "test-api-key",
+		captions:     &captionTrack{},
+		httpClient: &MockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(wav))}, nil
+		}},
+	}
+	flags := Flags{
+		OutputFile:   filepath.Join(t.TempDir(), "speech.wav"),
+		FormatOption: "wav",
+		ModelOption:  "tts-1",
+		VoiceOption:  "nova",
+		SpeedOption:  "1.0",
+	}
+
+	if err := synthesizeFile(context.Background(), textSegments([]string{"Hello there. Bye."}), flags, config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []caption{
+		{Start: 0, End: 1500 * time.Millisecond, Text: "Hello there."},
+		{Start: 1500 * time.Millisecond, End: 2 * time.Second, Text: "Bye."},
+	}
+	if !reflect.DeepEqual(config.captions.Cues, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, config.captions.Cues)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTOCEntries is the most children an ID3 CTOC frame can list.
+const maxTOCEntries = 255
+
+// measureChunks returns the duration of each chunk file when chapter markers,
+// captions or split parts need them, or nil when a chunk cannot be measured.
+func measureChunks(files []string, flags Flags, config Config) []time.Duration {
+	if flags.FormatOption != "mp3" && config.captions == nil && flags.MaxPartDuration == 0 {
+		return nil
+	}
+	durations := make([]time.Duration, len(files))
+	for i, file := range files {
+		duration, err := mediaDuration(file, flags.FormatOption)
+		if err != nil {
+			slog.Warn("Unable to measure chunk, leaving out chapter markers and captions", "file", file, "error", err)
+			return nil
+		}
+		durations[i] = duration
+	}
+	return durations
+}
+
+// chunkChapters marks the chapters of a combined MP3 from the measured
+// duration of each segment's chunk. Chapters start at the Markdown headings of
+// the highest level used, or at every chunk when the text has no headings. A
+// heading inside a chunk is placed by its share of the chunk's characters.
+func chunkChapters(segments []segment, durations []time.Duration, flags Flags) []chapterMark {
+	if flags.FormatOption != "mp3" || len(durations) != len(segments) {
+		return nil
+	}
+
+	type boundary struct {
+		title string
+		level int
+		start time.Duration
+	}
+	var headings, chunks []boundary
+	var start time.Duration
+	for i, segment := range segments {
+		duration := durations[i]
+		if i > 0 {
+			// Each crossfade overlaps the end of the previous chunk.
+			start -= flags.Crossfade
+		}
+		if segment.Pause == 0 {
+			chunks = append(chunks, boundary{title: fmt.Sprintf("Part %d", len(chunks)+1), start: start})
+			text := spokenText(segment.Text, flags)
+			length := utf8.RuneCountInString(text)
+			offset := 0
+			for _, line := range strings.SplitAfter(text, "\n") {
+				if m := atxHeading.FindStringSubmatch(strings.TrimRight(line, "\r\n")); m != nil {
+					at := start + time.Duration(float64(duration)*float64(offset)/float64(length))
+					headings = append(headings, boundary{title: headingTitle(m[2]), level: len(m[1]), start: at})
+				}
+				offset += utf8.RuneCountInString(line)
+			}
+		}
+		start += duration
+	}
+
+	bounds := chunks
+	if len(headings) > 0 {
+		level := 7
+		for _, heading := range headings {
+			level = min(level, heading.level)
+		}
+		bounds = nil
+		for _, heading := range headings {
+			if heading.level == level {
+				bounds = append(bounds, heading)
+			}
+		}
+		// Text before the first heading belongs to the first chapter.
+		bounds[0].start = 0
+	}
+	if len(bounds) < 2 {
+		return nil
+	}
+
+	marks := make([]chapterMark, len(bounds))
+	for i, bound := range bounds {
+		end := start
+		if i+1 < len(bounds) {
+			end = bounds[i+1].start
+		}
+		marks[i] = chapterMark{Title: bound.title, Start: bound.start, End: end}
+	}
+	return marks
+}
+
+// id3Chapters returns a CTOC frame listing a CHAP frame for each mark, so
+// players can skip between chapters. A CTOC lists at most 255 chapters.
+func id3Chapters(marks []chapterMark) [][]byte {
+	toc := []byte{'t', 'o', 'c', 0, 0x03, byte(min(len(marks), maxTOCEntries))}
+	frames := [][]byte{nil}
+	for i, mark := range marks {
+		id := fmt.Sprintf("chp%d", i)
+		if i < maxTOCEntries {
+			toc = append(toc, id...)
+			toc = append(toc, 0)
+		}
+		chapter := append([]byte(id), 0)
+		chapter = binary.BigEndian.AppendUint32(chapter, uint32(mark.Start.Milliseconds()))
+		chapter = binary.BigEndian.AppendUint32(chapter, uint32(mark.End.Milliseconds()))
+		// The byte offsets are unused, players seek by time.
+		chapter = binary.BigEndian.AppendUint32(chapter, 0xffffffff)
+		chapter = binary.BigEndian.AppendUint32(chapter, 0xffffffff)
+		chapter = append(chapter, id3Frame("TIT2", id3Text(mark.Title))...)
+		frames = append(frames, id3Frame("CHAP", chapter))
+	}
+	frames[0] = id3Frame("CTOC", toc)
+	return frames
+}
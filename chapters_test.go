@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeTestMP3 writes frames MPEG 2 layer III frames of 24ms each.
+func writeTestMP3(t *testing.T, name string, frames int) string {
+	t.Helper()
+	frame := make([]byte, 192)
+	copy(frame, []byte{0xff, 0xf3, 0x84, 0xc4})
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, bytes.Repeat(frame, frames), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestChunkChapters(t *testing.T) {
+	files := []string{
+		writeTestMP3(t, "1.mp3", 50),
+		writeTestMP3(t, "2.mp3", 25),
+		writeTestMP3(t, "3.mp3", 100),
+		writeTestMP3(t, "4.mp3", 50),
+	}
+	segments := []segment{
+		{Text: "Preface.\n# Opening\nText."},
+		{Pause: 600 * time.Millisecond},
+		{Text: "More text.\n## Detail\nAnd then\n# The *Return*\nEnd"},
+		{Text: "The end."},
+	}
+	flags := Flags{FormatOption: "mp3"}
+	durations := measureChunks(files, flags, Config{})
+	if expected := []time.Duration{1200 * time.Millisecond, 600 * time.Millisecond, 2400 * time.Millisecond, 1200 * time.Millisecond}; !reflect.DeepEqual(durations, expected) {
+		t.Fatalf("Expected durations %v, got %v", expected, durations)
+	}
+
+	expected := []chapterMark{
+		{Title: "Opening", Start: 0, End: 3300 * time.Millisecond},
+		{Title: "The Return", Start: 3300 * time.Millisecond, End: 5400 * time.Millisecond},
+	}
+	if marks := chunkChapters(segments, durations, flags); !reflect.DeepEqual(marks, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, marks)
+	}
+
+	plain := []segment{{Text: "One."}, {Pause: time.Second}, {Text: "Two."}, {Text: "Three."}}
+	flags.Crossfade = 100 * time.Millisecond
+	expected = []chapterMark{
+		{Title: "Part 1", Start: 0, End: 1600 * time.Millisecond},
+		{Title: "Part 2", Start: 1600 * time.Millisecond, End: 3900 * time.Millisecond},
+		{Title: "Part 3", Start: 3900 * time.Millisecond, End: 5100 * time.Millisecond},
+	}
+	if marks := chunkChapters(plain, durations, flags); !reflect.DeepEqual(marks, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, marks)
+	}
+
+	buffered := Flags{FormatOption: "mp3", BufferTextFlag: true, BufferStart: "Begin Text", BufferEnd: "End Text"}
+	wrapped := bufferFor(buffered).Wrap([]string{"Intro.\n# One\nText.", "Middle text\n# Two\nEnd go"})
+	expected = []chapterMark{
+		{Title: "One", Start: 0, End: 1800 * time.Millisecond},
+		{Title: "Two", Start: 1800 * time.Millisecond, End: 3 * time.Second},
+	}
+	if marks := chunkChapters([]segment{{Text: wrapped[0]}, {Text: wrapped[1]}}, durations[1:3], buffered); !reflect.DeepEqual(marks, expected) {
+		t.Errorf("Expected headings placed without the buffer phrases, got %+v", marks)
+	}
+
+	flags.FormatOption = "wav"
+	if marks := chunkChapters(plain, durations, flags); marks != nil {
+		t.Errorf("Expected no chapters for WAV output, got %+v", marks)
+	}
+}
+
+func TestID3Chapters(t *testing.T) {
+	marks := []chapterMark{
+		{Title: "Opening", Start: 0, End: 1500 * time.Millisecond},
+		{Title: "The Return", Start: 1500 * time.Millisecond, End: 4 * time.Second},
+	}
+	frames := id3Chapters(marks)
+	if len(frames) != 3 {
+		t.Fatalf("Expected a CTOC and 2 CHAP frames, got %d", len(frames))
+	}
+	if expected := "CTOC\x00\x00\x00\x10\x00\x00toc\x00\x03\x02chp0\x00chp1\x00"; string(frames[0]) != expected {
+		t.Errorf("Expected CTOC %q, got %q", expected, frames[0])
+	}
+
+	chapter := frames[2][10:]
+	if !bytes.HasPrefix(chapter, []byte("chp1\x00")) {
+		t.Fatalf("Expected element ID chp1, got %q", chapter)
+	}
+	times := chapter[5:21]
+	if start, end := binary.BigEndian.Uint32(times), binary.BigEndian.Uint32(times[4:]); start != 1500 || end != 4000 {
+		t.Errorf("Expected 1500ms to 4000ms, got %d to %d", start, end)
+	}
+	if !bytes.Equal(chapter[21:], id3Frame("TIT2", id3Text("The Return"))) {
+		t.Errorf("Expected a TIT2 subframe, got %q", chapter[21:])
+	}
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	pcmSampleRate = 24000
+	pcmBytes      = 2
+	// opusSampleRate is the rate Opus granule positions count at, whatever
+	// the rate of the input was.
+	opusSampleRate = 48000
+)
+
+// audioInfo describes an audio file as measured by the native parsers.
+type audioInfo struct {
+	Format     string
+	Duration   time.Duration
+	SampleRate int
+	Channels   int
+}
+
+var audioParsers = map[string]func(io.Reader) (audioInfo, error){
+	"mp3":  parseMP3,
+	"opus": parseOpus,
+	"aac":  parseADTS,
+	"flac": parseFLAC,
+	"wav":  parseWAV,
+}
+
+var formatExtensions = map[string]string{
+	".mp3":  "mp3",
+	".opus": "opus",
+	".ogg":  "opus",
+	".aac":  "aac",
+	".flac": "flac",
+	".wav":  "wav",
+	".pcm":  "pcm",
+	".raw":  "pcm",
+}
+
+func audioDuration(path, format string) (time.Duration, error) {
+	info, err := readAudioInfo(path, format)
+	return info.Duration, err
+}
+
+// readAudioInfo measures the audio file at path without decoding it. An empty
+// format is detected from the file name or, failing that, its contents.
+func readAudioInfo(path, format string) (audioInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return audioInfo{}, fmt.Errorf("unable to open audio file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	reader := bufio.NewReader(file)
+	if format == "" {
+		format = detectFormat(path, reader)
+	}
+	if format == "pcm" {
+		stat, err := file.Stat()
+		if err != nil {
+			return audioInfo{}, fmt.Errorf("unable to stat audio file: %w", err)
+		}
+		return audioInfo{
+			Format:     format,
+			Duration:   bytesToDuration(stat.Size(), pcmSampleRate*pcmBytes),
+			SampleRate: pcmSampleRate,
+			Channels:   1,
+		}, nil
+	}
+
+	parse, ok := audioParsers[format]
+	if !ok {
+		return audioInfo{}, fmt.Errorf("duration measurement is not supported for format: %s", format)
+	}
+	info, err := parse(reader)
+	info.Format = format
+	return info, err
+}
+
+func detectFormat(path string, reader *bufio.Reader) string {
+	if format, ok := formatExtensions[strings.ToLower(filepath.Ext(path))]; ok {
+		return format
+	}
+	header, _ := reader.Peek(4)
+	switch {
+	case bytes.HasPrefix(header, []byte("RIFF")):
+		return "wav"
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return "flac"
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return "opus"
+	case len(header) >= 2 && header[0] == 0xff && header[1]&0xf6 == 0xf0:
+		return "aac"
+	case bytes.HasPrefix(header, []byte("ID3")), len(header) >= 2 && header[0] == 0xff && header[1]&0xe0 == 0xe0:
+		return "mp3"
+	}
+	return ""
+}
+
+func parseWAV(r io.Reader) (audioInfo, error) {
+	var info audioInfo
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return info, fmt.Errorf("unable to read WAV header: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return info, fmt.Errorf("not a WAV file")
+	}
+
+	var byteRate uint32
+	for {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return info, fmt.Errorf("unable to find WAV data chunk: %w", err)
+		}
+		id := string(chunkHeader[0:4])
+		size := binary.LittleEndian.Uint32(chunkHeader[4:8])
+
+		switch id {
+		case "fmt ":
+			data := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return info, fmt.Errorf("unable to read WAV format chunk: %w", err)
+			}
+			if len(data) < 16 {
+				return info, fmt.Errorf("WAV format chunk too short")
+			}
+			info.Channels = int(binary.LittleEndian.Uint16(data[2:4]))
+			info.SampleRate = int(binary.LittleEndian.Uint32(data[4:8]))
+			byteRate = binary.LittleEndian.Uint32(data[8:12])
+		case "data":
+			if byteRate == 0 {
+				return info, fmt.Errorf("WAV data chunk found before format chunk")
+			}
+			// Streamed WAV responses carry a placeholder size, so count what is actually there.
+			if size == 0 || size == 0xFFFFFFFF {
+				n, err := io.Copy(io.Discard, r)
+				if err != nil {
+					return info, fmt.Errorf("unable to read WAV data: %w", err)
+				}
+				info.Duration = bytesToDuration(n, int64(byteRate))
+				return info, nil
+			}
+			info.Duration = bytesToDuration(int64(size), int64(byteRate))
+			return info, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, int64(size+size%2)); err != nil {
+				return info, fmt.Errorf("unable to skip WAV chunk %q: %w", id, err)
+			}
+		}
+	}
+}
+
+var (
+	mp3SampleRates = [4][3]int{
+		{11025, 12000, 8000},  // MPEG 2.5
+		{},                    // reserved
+		{22050, 24000, 16000}, // MPEG 2
+		{44100, 48000, 32000}, // MPEG 1
+	}
+	// mp3Bitrates holds kbps by [MPEG 1][layer - 1][index].
+	mp3Bitrates = [2][3][16]int{
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		},
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+		},
+	}
+	adtsSampleRates = []int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+)
+
+// mp3Header is a decoded MPEG audio frame header.
+type mp3Header struct {
+	Size       int
+	Samples    int
+	SampleRate int
+	Channels   int
+	// VBROffset is where a Xing or Info header starts in a layer III frame.
+	VBROffset int
+}
+
+// parseMP3 reads the frame count from a Xing, Info or VBRI header when the
+// encoder wrote one, and otherwise adds up the samples of every frame. ID3v2
+// tags are skipped.
+func parseMP3(r io.Reader) (audioInfo, error) {
+	var info audioInfo
+	reader := bufio.NewReader(r)
+	if err := skipID3(reader); err != nil {
+		return info, err
+	}
+
+	var samples int64
+	first := true
+	for {
+		header, _ := reader.Peek(4)
+		if len(header) < 4 {
+			break
+		}
+		frame, ok := mp3Frame(header)
+		if !ok {
+			_, _ = reader.Discard(1)
+			continue
+		}
+		if first {
+			first = false
+			info.SampleRate, info.Channels = frame.SampleRate, frame.Channels
+			data, _ := reader.Peek(frame.Size)
+			if frames, ok := mp3VBRFrames(data, frame); ok {
+				if frames > 0 {
+					info.Duration = samplesAtRate(int64(frames)*int64(frame.Samples), frame.SampleRate)
+					return info, nil
+				}
+				_, _ = reader.Discard(frame.Size)
+				continue
+			}
+		}
+		samples += int64(frame.Samples)
+		if _, err := reader.Discard(frame.Size); err != nil {
+			break
+		}
+	}
+	if info.SampleRate == 0 {
+		return info, fmt.Errorf("no MPEG audio frames found")
+	}
+	info.Duration = samplesAtRate(samples, info.SampleRate)
+	return info, nil
+}
+
+// mp3VBRFrames returns the number of audio frames recorded in a Xing, Info
+// or VBRI header in frame, which is not itself audio. The count is 0 when the
+// header has none, and ok is false when frame is an ordinary audio frame.
+func mp3VBRFrames(frame []byte, header mp3Header) (int, bool) {
+	if offset := header.VBROffset; offset > 0 && len(frame) >= offset+8 {
+		if id := string(frame[offset : offset+4]); id == "Xing" || id == "Info" {
+			if binary.BigEndian.Uint32(frame[offset+4:])&0x01 == 0 || len(frame) < offset+12 {
+				return 0, true
+			}
+			return int(binary.BigEndian.Uint32(frame[offset+8:])), true
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(frame[36+14:])), true
+	}
+	return 0, false
+}
+
+// mp3Frame decodes an MPEG audio frame header.
+func mp3Frame(header []byte) (mp3Header, bool) {
+	if header[0] != 0xff || header[1]&0xe0 != 0xe0 {
+		return mp3Header{}, false
+	}
+	version := int(header[1]>>3) & 0x03
+	layer := 4 - int(header[1]>>1)&0x03
+	bitrateIndex := int(header[2] >> 4)
+	rateIndex := int(header[2]>>2) & 0x03
+	padding := int(header[2]>>1) & 0x01
+	if version == 1 || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mp3Header{}, false
+	}
+
+	mpeg1 := 0
+	if version == 3 {
+		mpeg1 = 1
+	}
+	bitrate := mp3Bitrates[mpeg1][layer-1][bitrateIndex] * 1000
+	frame := mp3Header{SampleRate: mp3SampleRates[version][rateIndex], Channels: 2}
+	mono := header[3]>>6 == 3
+	if mono {
+		frame.Channels = 1
+	}
+
+	switch {
+	case layer == 1:
+		frame.Size, frame.Samples = (12*bitrate/frame.SampleRate+padding)*4, 384
+	case layer == 3 && mpeg1 == 0:
+		frame.Size, frame.Samples = 72*bitrate/frame.SampleRate+padding, 576
+	default:
+		frame.Size, frame.Samples = 144*bitrate/frame.SampleRate+padding, 1152
+	}
+	if layer == 3 {
+		// The Xing header follows the side information, whose size depends
+		// on the version and channel mode, and the CRC when there is one.
+		sideInfo := 17
+		switch {
+		case mpeg1 == 1 && !mono:
+			sideInfo = 32
+		case mpeg1 == 0 && mono:
+			sideInfo = 9
+		}
+		frame.VBROffset = 4 + sideInfo
+		if header[1]&0x01 == 0 {
+			frame.VBROffset += 2
+		}
+	}
+	return frame, true
+}
+
+// skipID3 discards an ID3v2 tag at the start of reader.
+func skipID3(reader *bufio.Reader) error {
+	header, err := reader.Peek(10)
+	if err != nil || string(header[0:3]) != "ID3" {
+		return nil
+	}
+	size := int(header[6]&0x7f)<<21 | int(header[7]&0x7f)<<14 | int(header[8]&0x7f)<<7 | int(header[9]&0x7f)
+	if header[5]&0x10 != 0 {
+		size += 10
+	}
+	if _, err := reader.Discard(10 + size); err != nil {
+		return fmt.Errorf("unable to skip ID3 tag: %w", err)
+	}
+	return nil
+}
+
+// parseADTS adds up the samples of every ADTS frame of AAC audio.
+func parseADTS(r io.Reader) (audioInfo, error) {
+	var info audioInfo
+	reader := bufio.NewReader(r)
+	if err := skipID3(reader); err != nil {
+		return info, err
+	}
+
+	var samples int64
+	for {
+		header, _ := reader.Peek(7)
+		if len(header) < 7 {
+			break
+		}
+		rateIndex := int(header[2]>>2) & 0x0f
+		size := int(header[3]&0x03)<<11 | int(header[4])<<3 | int(header[5]>>5)
+		if header[0] != 0xff || header[1]&0xf6 != 0xf0 || rateIndex >= len(adtsSampleRates) || size < 7 {
+			_, _ = reader.Discard(1)
+			continue
+		}
+		info.SampleRate = adtsSampleRates[rateIndex]
+		info.Channels = int(header[2]&0x01)<<2 | int(header[3]>>6)
+		samples += 1024 * int64(header[6]&0x03+1)
+		if _, err := reader.Discard(size); err != nil {
+			break
+		}
+	}
+	if info.SampleRate == 0 {
+		return info, fmt.Errorf("no ADTS frames found")
+	}
+	info.Duration = samplesAtRate(samples, info.SampleRate)
+	return info, nil
+}
+
+// parseOpus reads the length of an Ogg Opus stream from the granule position
+// of its last page, less the pre-skip the OpusHead header asks decoders to
+// drop.
+func parseOpus(r io.Reader) (audioInfo, error) {
+	info := audioInfo{SampleRate: opusSampleRate}
+	reader := bufio.NewReader(r)
+	var serial uint32
+	preSkip, granule := -1, int64(-1)
+	for {
+		var header [27]byte
+		if _, err := io.ReadFull(reader, header[:]); err != nil {
+			break
+		}
+		if string(header[0:4]) != "OggS" {
+			return info, fmt.Errorf("invalid Ogg page")
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(reader, segments); err != nil {
+			return info, fmt.Errorf("truncated Ogg page: %w", err)
+		}
+		size := 0
+		for _, segment := range segments {
+			size += int(segment)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return info, fmt.Errorf("truncated Ogg page: %w", err)
+		}
+
+		pageSerial := binary.LittleEndian.Uint32(header[14:18])
+		switch {
+		case preSkip < 0 && bytes.HasPrefix(data, []byte("OpusHead")) && len(data) >= 19:
+			serial = pageSerial
+			info.Channels = int(data[9])
+			preSkip = int(binary.LittleEndian.Uint16(data[10:12]))
+		case preSkip >= 0 && pageSerial == serial:
+			// Pages on which no packet ends have a granule position of -1.
+			if position := int64(binary.LittleEndian.Uint64(header[6:14])); position >= 0 {
+				granule = position
+			}
+		}
+	}
+	if preSkip < 0 {
+		return info, fmt.Errorf("not an Ogg Opus stream")
+	}
+	info.Duration = samplesAtRate(max(granule-int64(preSkip), 0), opusSampleRate)
+	return info, nil
+}
+
+// parseFLAC reads the sample count from STREAMINFO. Streamed encoders cannot
+// go back to fill it in, so when it is missing the last frame header is
+// found instead, whose position and block size give the count.
+func parseFLAC(r io.Reader) (audioInfo, error) {
+	var info audioInfo
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return info, fmt.Errorf("unable to read FLAC stream: %w", err)
+	}
+	if len(data) < 8+34 || string(data[0:4]) != "fLaC" || data[4]&0x7f != 0 {
+		return info, fmt.Errorf("not a FLAC stream")
+	}
+
+	streamInfo := data[8 : 8+34]
+	blockSize := int64(binary.BigEndian.Uint16(streamInfo[0:2]))
+	fields := binary.BigEndian.Uint64(streamInfo[10:18])
+	info.SampleRate = int(fields >> 44)
+	info.Channels = int(fields>>41&0x07) + 1
+	samples := int64(fields & 0xfffffffff)
+	if info.SampleRate == 0 {
+		return info, fmt.Errorf("FLAC stream has no sample rate")
+	}
+
+	if samples == 0 {
+		audio := 4
+		for last := false; !last && audio+4 <= len(data); {
+			last = data[audio]&0x80 != 0
+			audio += 4 + (int(data[audio+1])<<16 | int(data[audio+2])<<8 | int(data[audio+3]))
+		}
+		for i := len(data) - 2; i >= audio; i-- {
+			if position, size, ok := flacFrame(data[i:], blockSize); ok {
+				samples = position + size
+				break
+			}
+		}
+	}
+	info.Duration = samplesAtRate(samples, info.SampleRate)
+	return info, nil
+}
+
+// flacFrame decodes the FLAC frame header at the start of data and returns
+// the number of the frame's first sample and its block size. Fixed block size
+// streams number frames rather than samples. The header's CRC-8 rules out
+// sync codes that happen to appear in audio data.
+func flacFrame(data []byte, fixedBlockSize int64) (int64, int64, bool) {
+	if len(data) < 6 || data[0] != 0xff || data[1]&0xfe != 0xf8 || data[2]>>4 == 0 || data[2]&0x0f == 0x0f || data[3]&0x01 != 0 {
+		return 0, 0, false
+	}
+	variable := data[1]&0x01 != 0
+
+	// The frame or sample number is coded like UTF-8, in up to 7 bytes.
+	length := 1
+	number := int64(data[4])
+	if data[4]&0x80 != 0 {
+		for length = 2; length <= 7 && data[4]&(0x80>>length) != 0; length++ {
+		}
+		if length > 7 || data[4]&0x40 == 0 || len(data) < 4+length+1 {
+			return 0, 0, false
+		}
+		number = int64(data[4] & (0xff >> (length + 1)))
+		for _, b := range data[5 : 4+length] {
+			if b&0xc0 != 0x80 {
+				return 0, 0, false
+			}
+			number = number<<6 | int64(b&0x3f)
+		}
+	}
+	offset := 4 + length
+
+	var blockSize int64
+	switch code := data[2] >> 4; {
+	case code == 1:
+		blockSize = 192
+	case code <= 5:
+		blockSize = 576 << (code - 2)
+	case code == 6 && len(data) > offset:
+		blockSize = int64(data[offset]) + 1
+		offset++
+	case code == 7 && len(data) > offset+1:
+		blockSize = int64(binary.BigEndian.Uint16(data[offset:])) + 1
+		offset += 2
+	case code >= 8:
+		blockSize = 256 << (code - 8)
+	default:
+		return 0, 0, false
+	}
+	switch code := data[2] & 0x0f; code {
+	case 12:
+		offset++
+	case 13, 14:
+		offset += 2
+	}
+	if len(data) <= offset || flacCRC8(data[:offset]) != data[offset] {
+		return 0, 0, false
+	}
+
+	if !variable {
+		number *= fixedBlockSize
+	}
+	return number, blockSize, true
+}
+
+func flacCRC8(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for range 8 {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// samplesAtRate returns how long samples last at sampleRate. Whole seconds
+// are divided out first, as samples times a second in nanoseconds overflows
+// past about 53 hours at 48 kHz.
+func samplesAtRate(samples int64, sampleRate int) time.Duration {
+	if sampleRate == 0 {
+		return 0
+	}
+	rate := int64(sampleRate)
+	return time.Duration(samples/rate)*time.Second + time.Duration(samples%rate)*time.Second/time.Duration(rate)
+}
+
+func bytesToDuration(n, bytesPerSecond int64) time.Duration {
+	if bytesPerSecond == 0 {
+		return 0
+	}
+	return time.Duration(float64(n) / float64(bytesPerSecond) * float64(time.Second))
+}
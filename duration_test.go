@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func makeWAV(sampleRate, channels, bitsPerSample int, samples int, dataSize uint32) []byte {
+	blockAlign := channels * bitsPerSample / 8
+	data := make([]byte, samples*blockAlign)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(36+len(data)))
+	buf.WriteString("WAVE")
+	buf.WriteString("fmt ")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(1))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(channels))
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(sampleRate*blockAlign))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(blockAlign))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(bitsPerSample))
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, dataSize)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func TestSamplesAtRate(t *testing.T) {
+	cases := []struct {
+		samples  int64
+		rate     int
+		expected time.Duration
+	}{
+		{48000, 48000, time.Second},
+		{36000, 48000, 750 * time.Millisecond},
+		{48000*100*3600 + 24000, 48000, 100*time.Hour + 500*time.Millisecond},
+		{1, 3, 333333333},
+		{100, 0, 0},
+	}
+	for _, test := range cases {
+		if d := samplesAtRate(test.samples, test.rate); d != test.expected {
+			t.Errorf("samplesAtRate(%d, %d) = %v; expected %v", test.samples, test.rate, d, test.expected)
+		}
+	}
+}
+
+func TestWavDuration(t *testing.T) {
+	wav := makeWAV(24000, 1, 16, 12000, 24000)
+	info, err := parseWAV(bytes.NewReader(wav))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != 500*time.Millisecond {
+		t.Errorf("Expected duration 500ms, got %v", info.Duration)
+	}
+
+	streamed := makeWAV(24000, 1, 16, 24000, 0xFFFFFFFF)
+	info, err = parseWAV(bytes.NewReader(streamed))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != time.Second {
+		t.Errorf("Expected streamed duration 1s, got %v", info.Duration)
+	}
+
+	if _, err := parseWAV(bytes.NewReader([]byte("not a wav file"))); err == nil {
+		t.Errorf("Expected error for invalid WAV data, got nil")
+	}
+}
+
+func TestAudioDurationPCM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audio.pcm")
+	if err := os.WriteFile(path, make([]byte, pcmSampleRate*pcmBytes*2), 0o644); err != nil {
+		t.Fatalf("Failed to write PCM file: %v", err)
+	}
+	duration, err := audioDuration(path, "pcm")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if duration != 2*time.Second {
+		t.Errorf("Expected duration 2s, got %v", duration)
+	}
+
+	if _, err := audioDuration(path, "unknown"); err == nil {
+		t.Errorf("Expected error for unsupported format, got nil")
+	}
+}
+
+func TestMP3Duration(t *testing.T) {
+	// MPEG 2 layer III frames at 64 kbps and 24 kHz are 192 bytes of 576 samples.
+	frame := make([]byte, 192)
+	copy(frame, []byte{0xff, 0xf3, 0x84, 0xc4})
+
+	var mp3 bytes.Buffer
+	mp3.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5})
+	mp3.WriteString("junk!")
+	infoFrame := append([]byte(nil), frame...)
+	// MPEG 2 mono frames have 9 bytes of side information before the header.
+	copy(infoFrame[13:], "Info")
+	mp3.Write(infoFrame)
+	for range 50 {
+		mp3.Write(frame)
+	}
+
+	info, err := parseMP3(&mp3)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != 1200*time.Millisecond || info.SampleRate != 24000 || info.Channels != 1 {
+		t.Errorf("Expected 1.2s of 24 kHz mono, got %+v", info)
+	}
+
+	if _, err := parseMP3(bytes.NewReader([]byte("not an mp3 file"))); err == nil {
+		t.Errorf("Expected error for data without MPEG frames, got nil")
+	}
+}
+
+func TestParseMP3VBRHeaders(t *testing.T) {
+	// MPEG 1 layer III stereo frames at 128 kbps and 44.1 kHz are 417 bytes.
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+
+	xing := append([]byte(nil), frame...)
+	copy(xing[36:], "Xing\x00\x00\x00\x01\x00\x00\x03\xe8")
+	info, err := parseMP3(bytes.NewReader(append(xing, bytes.Repeat(frame, 3)...)))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := samplesAtRate(1000*1152, 44100); info.Duration != expected || info.Channels != 2 || info.SampleRate != 44100 {
+		t.Errorf("Expected %v of 44.1 kHz stereo from the Xing frame count, got %+v", expected, info)
+	}
+
+	vbri := append([]byte(nil), frame...)
+	copy(vbri[36:], "VBRI")
+	binary.BigEndian.PutUint32(vbri[36+14:], 441)
+	info, err = parseMP3(bytes.NewReader(append(vbri, frame...)))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != 11520*time.Millisecond {
+		t.Errorf("Expected 11.52s from the VBRI frame count, got %v", info.Duration)
+	}
+}
+
+func TestParseADTS(t *testing.T) {
+	// AAC LC frames at 24 kHz, mono, 100 bytes long.
+	frame := make([]byte, 100)
+	copy(frame, []byte{0xff, 0xf1, 0x58, 0x40, 0x0c, 0x9f, 0xfc})
+	aac := append(id3Tag(id3Frame("TIT2", id3Text("Title"))), bytes.Repeat(frame, 75)...)
+
+	info, err := parseADTS(bytes.NewReader(aac))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != 3200*time.Millisecond || info.SampleRate != 24000 || info.Channels != 1 {
+		t.Errorf("Expected 3.2s of 24 kHz mono, got %+v", info)
+	}
+
+	if _, err := parseADTS(bytes.NewReader([]byte("not aac"))); err == nil {
+		t.Errorf("Expected error for data without ADTS frames, got nil")
+	}
+}
+
+func TestParseOpus(t *testing.T) {
+	head := append([]byte("OpusHead\x01\x01"), 0x38, 0x01, 0xc0, 0x5d, 0, 0, 0, 0, 0)
+	pages := []oggPage{
+		{HeaderType: 0x02, Serial: 3, Segments: []byte{19}, Data: head},
+		{Serial: 3, Sequence: 1, Segments: []byte{16}, Data: append([]byte("OpusTags"), make([]byte, 8)...)},
+		{Granule: 48312, Serial: 3, Sequence: 2, Segments: []byte{2}, Data: []byte{1, 2}},
+		{Granule: ^uint64(0), Serial: 3, Sequence: 3, Segments: []byte{255}, Data: make([]byte, 255)},
+		{HeaderType: 0x05, Granule: 96312, Serial: 3, Sequence: 4, Segments: []byte{2}, Data: []byte{3, 4}},
+	}
+	var opus []byte
+	for _, page := range pages {
+		opus = append(opus, page.bytes()...)
+	}
+
+	info, err := parseOpus(bytes.NewReader(opus))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != 2*time.Second || info.SampleRate != 48000 || info.Channels != 1 {
+		t.Errorf("Expected 2s at 48 kHz mono after the pre-skip, got %+v", info)
+	}
+
+	if _, err := parseOpus(bytes.NewReader(pages[2].bytes())); err == nil {
+		t.Errorf("Expected error for Ogg without OpusHead, got nil")
+	}
+}
+
+func TestParseFLAC(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	binary.BigEndian.PutUint16(streamInfo[0:], 192)
+	binary.BigEndian.PutUint16(streamInfo[2:], 192)
+	fields := uint64(24000)<<44 | uint64(15)<<36 | 24000
+	binary.BigEndian.PutUint64(streamInfo[10:], fields)
+	flac := append([]byte("fLaC\x80\x00\x00\x22"), streamInfo...)
+
+	info, err := parseFLAC(bytes.NewReader(flac))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != time.Second || info.SampleRate != 24000 || info.Channels != 1 {
+		t.Errorf("Expected 1s of 24 kHz mono from STREAMINFO, got %+v", info)
+	}
+
+	// A streamed file has no sample count, so the last frame gives the length.
+	binary.BigEndian.PutUint64(streamInfo[10:], fields&^0xfffffffff)
+	streamed := append([]byte("fLaC\x80\x00\x00\x22"), streamInfo...)
+	for _, number := range []byte{0, 1, 124} {
+		header := []byte{0xff, 0xf8, 0x10, 0x08, number}
+		streamed = append(streamed, append(header, flacCRC8(header))...)
+		streamed = append(streamed, "audio data"...)
+	}
+	streamed = append(streamed, 0xff, 0xf8, 0x10, 0x08, 0x7d, 0x00, 'x')
+
+	info, err = parseFLAC(bytes.NewReader(streamed))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if info.Duration != time.Second {
+		t.Errorf("Expected 1s from the last frame, got %v", info.Duration)
+	}
+}
+
+func TestFLACFrame(t *testing.T) {
+	header := []byte{0xff, 0xf9, 0x70, 0x08, 0xe1, 0x80, 0x80, 0x0f, 0xff}
+	header = append(header, flacCRC8(header))
+	position, size, ok := flacFrame(header, 0)
+	if !ok || position != 4096 || size != 4096 {
+		t.Errorf("Expected sample 4096 and a block of 4096, got %d, %d, %v", position, size, ok)
+	}
+}
+
+func TestReadAudioInfo(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "speech")
+	if err := os.WriteFile(path, makeWAV(24000, 2, 16, 12000, 48000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := readAudioInfo(path, "")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := (audioInfo{Format: "wav", Duration: 500 * time.Millisecond, SampleRate: 24000, Channels: 2}); info != expected {
+		t.Errorf("Expected %+v detected from the contents, got %+v", expected, info)
+	}
+
+	pcm := filepath.Join(dir, "speech.pcm")
+	if err := os.WriteFile(pcm, make([]byte, pcmSampleRate*pcmBytes), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := readAudioInfo(pcm, ""); err != nil || info.Duration != time.Second || info.Channels != 1 {
+		t.Errorf("Expected 1s of mono PCM, got %+v, %v", info, err)
+	}
+}
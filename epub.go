@@ -0,0 +1,417 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const epubContainerPath = "META-INF/container.xml"
+
+// chapter is one spine document of an EPUB, or several when a chapter is split
+// across files that only the first of has a table of contents entry for.
+type chapter struct {
+	Title string
+	Text  string
+}
+
+type epubContainer struct {
+	Rootfiles []struct {
+		FullPath string `xml:"full-path,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// epubBook is the text and metadata read from an EPUB.
+type epubBook struct {
+	Title    string
+	Author   string
+	Cover    *coverImage
+	Chapters []chapter
+}
+
+type opfPackage struct {
+	Titles   []string `xml:"metadata>title"`
+	Creators []string `xml:"metadata>creator"`
+	Metas    []struct {
+		Name    string `xml:"name,attr"`
+		Content string `xml:"content,attr"`
+	} `xml:"metadata>meta"`
+	Manifest []opfItem `xml:"manifest>item"`
+	Spine    struct {
+		TOC      string `xml:"toc,attr"`
+		ItemRefs []struct {
+			IDRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+type ncxPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Points []ncxPoint `xml:"navPoint"`
+}
+
+// blockElements end a paragraph of extracted text.
+var blockElements = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "body": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "figcaption": true, "figure": true,
+	"footer": true, "h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+	"header": true, "hr": true, "li": true, "nav": true, "ol": true, "p": true, "pre": true,
+	"section": true, "table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// skippedElements hold no readable text.
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "svg": true, "math": true, "rt": true, "rp": true,
+}
+
+func isEPUB(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".epub")
+}
+
+// readEPUB returns the chapters of an EPUB in spine order, titled from the
+// EPUB 3 navigation document or the EPUB 2 NCX, along with its title, author
+// and cover. Documents without readable text, such as cover pages, are left
+// out.
+func readEPUB(name string) (epubBook, error) {
+	var book epubBook
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return book, fmt.Errorf("unable to open EPUB: %w", err)
+	}
+	defer func() {
+		_ = archive.Close()
+	}()
+
+	var container epubContainer
+	if err := decodeZipXML(&archive.Reader, epubContainerPath, &container); err != nil {
+		return book, err
+	}
+	if len(container.Rootfiles) == 0 || container.Rootfiles[0].FullPath == "" {
+		return book, fmt.Errorf("EPUB container lists no package document")
+	}
+	opfPath := container.Rootfiles[0].FullPath
+
+	var pkg opfPackage
+	if err := decodeZipXML(&archive.Reader, opfPath, &pkg); err != nil {
+		return book, err
+	}
+	items := make(map[string]opfItem, len(pkg.Manifest))
+	for _, item := range pkg.Manifest {
+		item.Href = resolveHref(opfPath, item.Href)
+		items[item.ID] = item
+	}
+
+	titles, err := epubTitles(&archive.Reader, pkg, items)
+	if err != nil {
+		return book, err
+	}
+
+	book.Title = strings.Join(strings.Fields(first(pkg.Titles)), " ")
+	book.Author = strings.Join(strings.Fields(strings.Join(pkg.Creators, ", ")), " ")
+	book.Cover = epubCover(&archive.Reader, pkg, items)
+
+	var chapters []chapter
+	for _, ref := range pkg.Spine.ItemRefs {
+		item, ok := items[ref.IDRef]
+		if !ok {
+			return book, fmt.Errorf("EPUB spine refers to unknown item %q", ref.IDRef)
+		}
+		if ref.Linear == "no" {
+			continue
+		}
+		file, err := archive.Open(item.Href)
+		if err != nil {
+			return book, fmt.Errorf("unable to open %s in EPUB: %w", item.Href, err)
+		}
+		text, heading, err := xhtmlText(file)
+		_ = file.Close()
+		if err != nil {
+			return book, fmt.Errorf("unable to read %s in EPUB: %w", item.Href, err)
+		}
+		if text == "" {
+			continue
+		}
+
+		title, listed := titles[item.Href]
+		if !listed && len(titles) > 0 && len(chapters) > 0 {
+			previous := &chapters[len(chapters)-1]
+			previous.Text += "\n\n" + text
+			continue
+		}
+		if title == "" {
+			title = heading
+		}
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", len(chapters)+1)
+		}
+		chapters = append(chapters, chapter{Title: title, Text: text})
+	}
+	if len(chapters) == 0 {
+		return book, fmt.Errorf("EPUB has no readable chapters")
+	}
+	book.Chapters = chapters
+	return book, nil
+}
+
+// epubCover returns the cover image named by the EPUB 3 cover-image property
+// or the EPUB 2 cover meta element, or nil when the book has none or it cannot
+// be embedded.
+func epubCover(archive *zip.Reader, pkg opfPackage, items map[string]opfItem) *coverImage {
+	var cover opfItem
+	for _, manifest := range pkg.Manifest {
+		if strings.Contains(" "+manifest.Properties+" ", " cover-image ") {
+			cover = items[manifest.ID]
+		}
+	}
+	for _, meta := range pkg.Metas {
+		if cover.Href == "" && meta.Name == "cover" {
+			cover = items[meta.Content]
+		}
+	}
+	if cover.Href == "" || !strings.HasPrefix(cover.MediaType, "image/") {
+		return nil
+	}
+
+	file, err := archive.Open(cover.Href)
+	if err != nil {
+		return warnCover(nil, fmt.Errorf("unable to open %s in EPUB: %w", cover.Href, err))
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return warnCover(nil, fmt.Errorf("unable to read %s in EPUB: %w", cover.Href, err))
+	}
+	return warnCover(newCoverImage(data, cover.Href))
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func decodeZipXML(archive *zip.Reader, name string, v any) error {
+	file, err := archive.Open(name)
+	if err != nil {
+		return fmt.Errorf("unable to open %s in EPUB: %w", name, err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	if err := xml.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("unable to parse %s in EPUB: %w", name, err)
+	}
+	return nil
+}
+
+// resolveHref returns the archive path of href, which is relative to the
+// document base and may be URL encoded or carry a fragment.
+func resolveHref(base, href string) string {
+	href, _, _ = strings.Cut(href, "#")
+	if unescaped, err := url.PathUnescape(href); err == nil {
+		href = unescaped
+	}
+	return path.Join(path.Dir(base), href)
+}
+
+// epubTitles maps spine documents to their first table of contents entry.
+func epubTitles(archive *zip.Reader, pkg opfPackage, items map[string]opfItem) (map[string]string, error) {
+	titles := make(map[string]string)
+	add := func(base, href, title string) {
+		title = strings.Join(strings.Fields(title), " ")
+		href = resolveHref(base, href)
+		if _, ok := titles[href]; !ok && title != "" {
+			titles[href] = title
+		}
+	}
+
+	for _, manifest := range pkg.Manifest {
+		item := items[manifest.ID]
+		if !strings.Contains(" "+item.Properties+" ", " nav ") {
+			continue
+		}
+		file, err := archive.Open(item.Href)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open %s in EPUB: %w", item.Href, err)
+		}
+		entries, err := navEntries(file)
+		_ = file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s in EPUB: %w", item.Href, err)
+		}
+		for _, entry := range entries {
+			add(item.Href, entry[0], entry[1])
+		}
+		return titles, nil
+	}
+
+	ncxItem, ok := items[pkg.Spine.TOC]
+	if !ok {
+		return titles, nil
+	}
+	var ncx struct {
+		Points []ncxPoint `xml:"navMap>navPoint"`
+	}
+	if err := decodeZipXML(archive, ncxItem.Href, &ncx); err != nil {
+		return nil, err
+	}
+	var walk func(points []ncxPoint)
+	walk = func(points []ncxPoint) {
+		for _, point := range points {
+			add(ncxItem.Href, point.Content.Src, point.Label)
+			walk(point.Points)
+		}
+	}
+	walk(ncx.Points)
+	return titles, nil
+}
+
+// navEntries returns the href and text of each link in the toc nav element of
+// an EPUB 3 navigation document.
+func navEntries(r io.Reader) ([][2]string, error) {
+	decoder := newXHTMLDecoder(r)
+	var entries [][2]string
+	navDepth := 0
+	var link *[2]string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch {
+			case navDepth > 0:
+				navDepth++
+				if t.Name.Local == "a" {
+					link = &[2]string{attribute(t, "href"), ""}
+				}
+			case t.Name.Local == "nav" && strings.Contains(attribute(t, "type"), "toc"):
+				navDepth = 1
+			}
+		case xml.EndElement:
+			if navDepth == 0 {
+				continue
+			}
+			navDepth--
+			if t.Name.Local == "a" && link != nil {
+				entries = append(entries, *link)
+				link = nil
+			}
+		case xml.CharData:
+			if link != nil {
+				link[1] += string(t)
+			}
+		}
+	}
+}
+
+// xhtmlText returns the readable text of an XHTML document with a blank line
+// between paragraphs, and the text of its first heading.
+func xhtmlText(r io.Reader) (string, string, error) {
+	decoder := newXHTMLDecoder(r)
+	var paragraphs []string
+	var current strings.Builder
+	var heading string
+	inHeading := false
+	skip := 0
+
+	flush := func() {
+		var lines []string
+		for _, line := range strings.Split(current.String(), "\n") {
+			if line = strings.Join(strings.Fields(line), " "); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		current.Reset()
+		if len(lines) == 0 {
+			return
+		}
+		text := strings.Join(lines, "\n")
+		if inHeading && heading == "" {
+			heading = strings.Join(lines, " ")
+		}
+		paragraphs = append(paragraphs, text)
+	}
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", "", err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := strings.ToLower(t.Name.Local)
+			epubType := attribute(t, "type")
+			switch {
+			case skip > 0 || skippedElements[name] ||
+				strings.Contains(epubType, "noteref") || strings.Contains(epubType, "pagebreak"):
+				skip++
+			case name == "br":
+				current.WriteString("\n")
+			case blockElements[name]:
+				flush()
+				inHeading = len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6'
+			}
+		case xml.EndElement:
+			name := strings.ToLower(t.Name.Local)
+			switch {
+			case skip > 0:
+				skip--
+			case blockElements[name]:
+				flush()
+				inHeading = false
+			}
+		case xml.CharData:
+			// Line breaks in the source are layout, only <br> breaks a line.
+			if skip == 0 {
+				current.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(string(t)))
+			}
+		}
+	}
+	flush()
+	return strings.Join(paragraphs, "\n\n"), heading, nil
+}
+
+// newXHTMLDecoder returns a lenient decoder, since EPUB documents often use
+// HTML entities and unclosed void elements.
+func newXHTMLDecoder(r io.Reader) *xml.Decoder {
+	decoder := xml.NewDecoder(r)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	return decoder
+}
+
+func attribute(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
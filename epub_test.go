@@ -0,0 +1,272 @@
+package main
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const testOPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>A Test
+      Book</dc:title>
+    <dc:creator>Ann Author</dc:creator>
+    <dc:creator>Bo Writer</dc:creator>
+    <meta name="cover" content="cover-image"/>
+  </metadata>
+  <manifest>
+    <item id="ch2" href="text/chapter%202.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="text/chapter1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1b" href="text/chapter1b.xhtml" media-type="application/xhtml+xml"/>
+    <item id="cover" href="cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="notes" href="text/notes.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="cover-image" href="images/cover.png" media-type="image/png"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="cover"/>
+    <itemref idref="ch1"/>
+    <itemref idref="ch1b"/>
+    <itemref idref="notes" linear="no"/>
+    <itemref idref="ch2"/>
+  </spine>
+</package>`
+
+const testNCX = `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1">
+  <navMap>
+    <navPoint id="p1"><navLabel><text>Chapter One:
+      The Start</text></navLabel><content src="text/chapter1.xhtml"/>
+      <navPoint id="p1a"><navLabel><text>A Section</text></navLabel><content src="text/chapter1.xhtml#s1"/></navPoint>
+    </navPoint>
+    <navPoint id="p2"><navLabel><text>Chapter Two</text></navLabel><content src="text/chapter%202.xhtml"/></navPoint>
+  </navMap>
+</ncx>`
+
+const testChapter1 = `<?xml version="1.0" encoding="UTF-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>Ignored</title><style>p { margin: 0 }</style></head>
+<body>
+  <h1>Chapter One</h1>
+  <p>It was a   <em>dark</em> and
+     stormy night&mdash;or so they said.<a epub:type="noteref" href="notes.xhtml#n1">1</a></p>
+  <p>Roses are red,<br/>violets are blue.</p>
+  <span epub:type="pagebreak" id="page2">2</span>
+</body>
+</html>`
+
+const testChapter1b = `<html><body><p>The chapter continues.</p></body></html>`
+
+const testChapter2 = `<html><body><div><h2>Two</h2><p>The end &amp; more.</p></div></body></html>`
+
+// testPNG is the signature and header of a PNG image, enough to detect it.
+const testPNG = "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+
+const testCover = `<html><body><div><img src="cover.jpg" alt="Cover"/></div></body></html>`
+
+func writeTestEPUB(t *testing.T, files map[string]string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "book.epub")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	for _, path := range []string{"mimetype", epubContainerPath} {
+		w, _ := archive.Create(path)
+		if path == "mimetype" {
+			_, _ = w.Write([]byte("application/epub+zip"))
+		} else {
+			_, _ = w.Write([]byte(testContainer))
+		}
+	}
+	for path, content := range files {
+		w, err := archive.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReadEPUB(t *testing.T) {
+	name := writeTestEPUB(t, map[string]string{
+		"OEBPS/content.opf":          testOPF,
+		"OEBPS/toc.ncx":              testNCX,
+		"OEBPS/cover.xhtml":          testCover,
+		"OEBPS/text/chapter1.xhtml":  testChapter1,
+		"OEBPS/text/chapter1b.xhtml": testChapter1b,
+		"OEBPS/text/chapter 2.xhtml": testChapter2,
+		"OEBPS/text/notes.xhtml":     `<html><body><p>A note.</p></body></html>`,
+		"OEBPS/images/cover.png":     testPNG,
+	})
+
+	epub, err := readEPUB(name)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if epub.Title != "A Test Book" || epub.Author != "Ann Author, Bo Writer" {
+		t.Errorf("Unexpected title %q and author %q", epub.Title, epub.Author)
+	}
+	if epub.Cover == nil || epub.Cover.MIMEType != "image/png" || string(epub.Cover.Data) != testPNG {
+		t.Errorf("Expected the PNG cover, got %+v", epub.Cover)
+	}
+	expected := []chapter{
+		{
+			Title: "Chapter One: The Start",
+			Text:  "Chapter One\n\nIt was a dark and stormy night—or so they said.\n\nRoses are red,\nviolets are blue.\n\nThe chapter continues.",
+		},
+		{Title: "Chapter Two", Text: "Two\n\nThe end & more."},
+	}
+	if !reflect.DeepEqual(epub.Chapters, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, epub.Chapters)
+	}
+}
+
+func TestReadEPUBNav(t *testing.T) {
+	opf := strings.NewReplacer(
+		`version="2.0"`, `version="3.0"`,
+		`<item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>`,
+		`<item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`,
+		`<spine toc="ncx">`, `<spine>`,
+	).Replace(testOPF)
+	nav := `<html xmlns:epub="http://www.idpf.org/2007/ops"><body>
+<nav epub:type="landmarks"><ol><li><a href="text/chapter%202.xhtml">Wrong</a></li></ol></nav>
+<nav epub:type="toc"><ol>
+  <li><a href="text/chapter1.xhtml">First <b>Chapter</b></a></li>
+  <li><a href="text/chapter%202.xhtml#top">Second Chapter</a></li>
+</ol></nav></body></html>`
+
+	name := writeTestEPUB(t, map[string]string{
+		"OEBPS/content.opf":          opf,
+		"OEBPS/nav.xhtml":            nav,
+		"OEBPS/cover.xhtml":          testCover,
+		"OEBPS/text/chapter1.xhtml":  testChapter1,
+		"OEBPS/text/chapter1b.xhtml": testChapter1b,
+		"OEBPS/text/chapter 2.xhtml": testChapter2,
+		"OEBPS/text/notes.xhtml":     `<html><body><p>A note.</p></body></html>`,
+	})
+
+	epub, err := readEPUB(name)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if epub.Cover != nil {
+		t.Errorf("Expected a missing cover image to be ignored, got %+v", epub.Cover)
+	}
+	var titles []string
+	for _, chapter := range epub.Chapters {
+		titles = append(titles, chapter.Title)
+	}
+	if expected := []string{"First Chapter", "Second Chapter"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected titles %v, got %v", expected, titles)
+	}
+}
+
+func TestReadEPUBWithoutTOC(t *testing.T) {
+	opf := strings.Replace(testOPF, `<spine toc="ncx">`, `<spine>`, 1)
+	name := writeTestEPUB(t, map[string]string{
+		"OEBPS/content.opf":          opf,
+		"OEBPS/cover.xhtml":          testCover,
+		"OEBPS/text/chapter1.xhtml":  testChapter1,
+		"OEBPS/text/chapter1b.xhtml": testChapter1b,
+		"OEBPS/text/chapter 2.xhtml": testChapter2,
+	})
+
+	epub, err := readEPUB(name)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var titles []string
+	for _, chapter := range epub.Chapters {
+		titles = append(titles, chapter.Title)
+	}
+	if expected := []string{"Chapter One", "Chapter 2", "Two"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected titles from headings, got %v", titles)
+	}
+}
+
+func TestReadEPUBErrors(t *testing.T) {
+	missing := writeTestEPUB(t, map[string]string{
+		"OEBPS/content.opf": strings.Replace(testOPF, `<itemref idref="ch2"/>`, `<itemref idref="ch3"/>`, 1),
+		"OEBPS/toc.ncx":     testNCX,
+	})
+	if _, err := readEPUB(missing); err == nil {
+		t.Error("Expected an error for a spine item missing from the manifest")
+	}
+
+	notZip := filepath.Join(t.TempDir(), "book.epub")
+	if err := os.WriteFile(notZip, []byte("plain text"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readEPUB(notZip); err == nil {
+		t.Error("Expected an error for a file that is not a zip archive")
+	}
+}
+
+func TestReadBookEPUB(t *testing.T) {
+	name := writeTestEPUB(t, map[string]string{
+		"OEBPS/content.opf":          testOPF,
+		"OEBPS/toc.ncx":              testNCX,
+		"OEBPS/cover.xhtml":          testCover,
+		"OEBPS/text/chapter1.xhtml":  testChapter1,
+		"OEBPS/text/chapter1b.xhtml": testChapter1b,
+		"OEBPS/text/chapter 2.xhtml": testChapter2,
+	})
+
+	b, err := readBook(Flags{InputFile: name, OutputFile: "out/book.mp3", CombineFiles: true, NoLexicon: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	parts := b.Parts
+	if len(parts) != 2 {
+		t.Fatalf("Expected 2 parts, got %d", len(parts))
+	}
+	if parts[0].Output != "out/book_01_chapter-one-the-start.mp3" || parts[1].Output != "out/book_02_chapter-two.mp3" {
+		t.Errorf("Unexpected outputs %q and %q", parts[0].Output, parts[1].Output)
+	}
+	if len(parts[1].Segments) != 1 || parts[1].Segments[0].Text != "Two\n\nThe end & more." {
+		t.Errorf("Unexpected segments %+v", parts[1].Segments)
+	}
+
+	if _, err := readBook(Flags{InputFile: name, Script: true}); err == nil {
+		t.Error("Expected an error for --script with EPUB input")
+	}
+}
+
+func TestPartOutput(t *testing.T) {
+	cases := []struct {
+		output        string
+		number, total int
+		title         string
+		expected      string
+	}{
+		{"book.mp3", 3, 12, "The Return!", "book_03_the-return.mp3"},
+		{"dir/book.wav", 7, 120, "Chapter VII — Élan", "dir/book_007_chapter-vii-élan.wav"},
+		{"book.mp3", 1, 2, "***", "book_01.mp3"},
+		{"book.mp3", 1, 2, strings.Repeat("word ", 40), "book_01_" + strings.TrimSuffix(strings.Repeat("word-", 12), "-") + ".mp3"},
+	}
+	for _, c := range cases {
+		if output := partOutput(c.output, c.number, c.total, c.title); output != c.expected {
+			t.Errorf("partOutput(%q, %d, %d, %q) = %q; expected %q", c.output, c.number, c.total, c.title, output, c.expected)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+// feedTypes maps the extensions of episodes to their MIME types. Raw PCM has
+// no container a podcast player could read and is left out.
+var feedTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".m4b":  "audio/mp4",
+	".aac":  "audio/aac",
+	".opus": "audio/ogg",
+	".ogg":  "audio/ogg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+}
+
+type feedOptions struct {
+	Dir         string
+	BaseURL     string
+	Title       string
+	Description string
+	Author      string
+	Image       string
+	Language    string
+	// NameTemplate names the chunk files kept next to combined outputs, which
+	// are not episodes.
+	NameTemplate string
+}
+
+// feedSidecar is the metadata read from a JSON file next to an episode, such
+// as update.json for update.mp3. A run report written with --report works as
+// a sidecar too and dates the episode when the run finished.
+type feedSidecar struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	PubDate     string    `json:"pub_date"`
+	FinishedAt  time.Time `json:"finished_at"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Language      string    `xml:"language"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Generator     string    `xml:"generator"`
+	Author        string    `xml:"itunes:author,omitempty"`
+	Image         *rssImage `xml:"itunes:image,omitempty"`
+	Explicit      string    `xml:"itunes:explicit"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssImage struct {
+	Href string `xml:"href,attr"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Description string       `xml:"description,omitempty"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Duration    string       `xml:"itunes:duration,omitempty"`
+
+	published time.Time
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// runFeed writes a podcast feed of the audio files in a directory to stdout.
+func runFeed(args []string) error {
+	options := feedOptions{}
+	fs := flag.NewFlagSet("feed", flag.ContinueOnError)
+	fs.StringVar(&options.Dir, "dir", "", "Directory of audio files to list")
+	fs.StringVar(&options.BaseURL, "base-url", "", "URL the directory is published at")
+	fs.StringVar(&options.Title, "title", "", "Podcast title (default: the directory name)")
+	fs.StringVar(&options.Description, "description", "", "Podcast description (default: the title)")
+	fs.StringVar(&options.Author, "author", "", "Podcast author")
+	fs.StringVar(&options.Image, "image", "", "URL of the podcast cover art")
+	fs.StringVar(&options.Language, "language", "en-us", "Language of the podcast")
+	fs.StringVar(&options.NameTemplate, "name-template", defaultNameTemplate, "Name template of chunk files to leave out next to combined outputs")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("unable to parse feed flags: %w", err)
+	}
+	if options.Dir == "" || options.BaseURL == "" {
+		return fmt.Errorf("a directory and base URL must be specified. Usage: tts feed --dir DIR --base-url URL")
+	}
+	if _, err := url.Parse(options.BaseURL); err != nil {
+		return fmt.Errorf("invalid base URL: %w", err)
+	}
+	if err := validateNameTemplate(options.NameTemplate); err != nil {
+		return err
+	}
+	return writeFeed(os.Stdout, options)
+}
+
+// writeFeed lists each audio file in the directory as an episode, newest
+// first, leaving out the chunk files of combined outputs. The feed only
+// depends on the files, so running it again over the same directory gives the
+// same feed.
+func writeFeed(w io.Writer, options feedOptions) error {
+	entries, err := os.ReadDir(options.Dir)
+	if err != nil {
+		return fmt.Errorf("unable to read feed directory: %w", err)
+	}
+	chunks := chunkFiles(entries, cmp.Or(options.NameTemplate, defaultNameTemplate))
+	var items []rssItem
+	for _, entry := range entries {
+		mimeType, ok := feedTypes[strings.ToLower(filepath.Ext(entry.Name()))]
+		if !ok || entry.IsDir() {
+			continue
+		}
+		if chunks[entry.Name()] {
+			slog.Debug("Leaving out chunk file", "file", entry.Name())
+			continue
+		}
+		item, err := feedItem(options, entry, mimeType)
+		if err != nil {
+			return err
+		}
+		items = append(items, item)
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].published.Equal(items[j].published) {
+			return items[i].published.After(items[j].published)
+		}
+		return items[i].Enclosure.URL < items[j].Enclosure.URL
+	})
+
+	title := cmp.Or(options.Title, filepath.Base(filepath.Clean(options.Dir)))
+	feed := rssFeed{
+		Version: "2.0",
+		Itunes:  itunesNamespace,
+		Channel: rssChannel{
+			Title:       title,
+			Link:        options.BaseURL,
+			Description: cmp.Or(options.Description, title),
+			Language:    options.Language,
+			Generator:   tool + " " + version,
+			Author:      options.Author,
+			Explicit:    "false",
+			Items:       items,
+		},
+	}
+	if options.Image != "" {
+		feed.Channel.Image = &rssImage{Href: options.Image}
+	}
+	if len(items) > 0 {
+		feed.Channel.LastBuildDate = items[0].PubDate
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("unable to write feed: %w", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		return fmt.Errorf("unable to write feed: %w", err)
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// chunkFiles finds the files that match the name template of chunks and
+// belong to another audio file in the directory, such as book_01.mp3 next to
+// book.mp3.
+func chunkFiles(entries []os.DirEntry, template string) map[string]bool {
+	outputs := make(map[string]bool)
+	for _, entry := range entries {
+		if _, ok := feedTypes[strings.ToLower(filepath.Ext(entry.Name()))]; ok && !entry.IsDir() {
+			outputs[strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))] = true
+		}
+	}
+
+	pattern := nameTemplatePattern(template)
+	base := pattern.SubexpIndex("base")
+	chunks := make(map[string]bool)
+	for _, entry := range entries {
+		m := pattern.FindStringSubmatch(entry.Name())
+		if m != nil && base >= 0 && outputs[m[base]] {
+			chunks[entry.Name()] = true
+		}
+	}
+	return chunks
+}
+
+// feedItem describes an episode from the file and its sidecar. Without a
+// sidecar the episode is titled after the file and dated by its modification
+// time.
+func feedItem(options feedOptions, entry os.DirEntry, mimeType string) (rssItem, error) {
+	path := filepath.Join(options.Dir, entry.Name())
+	stat, err := entry.Info()
+	if err != nil {
+		return rssItem{}, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	sidecar, err := readSidecar(strings.TrimSuffix(path, filepath.Ext(path)) + ".json")
+	if err != nil {
+		return rssItem{}, err
+	}
+
+	published := stat.ModTime()
+	switch {
+	case sidecar.PubDate != "":
+		if published, err = parsePubDate(sidecar.PubDate); err != nil {
+			return rssItem{}, fmt.Errorf("invalid pub_date for %s: %w", path, err)
+		}
+	case !sidecar.FinishedAt.IsZero():
+		published = sidecar.FinishedAt
+	}
+	published = published.UTC().Truncate(time.Second)
+
+	link := strings.TrimSuffix(options.BaseURL, "/") + "/" + url.PathEscape(entry.Name())
+	item := rssItem{
+		Title:       cmp.Or(sidecar.Title, strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))),
+		Description: sidecar.Description,
+		Enclosure:   rssEnclosure{URL: link, Length: stat.Size(), Type: mimeType},
+		GUID:        rssGUID{IsPermaLink: true, Value: link},
+		PubDate:     published.Format(time.RFC1123Z),
+		published:   published,
+	}
+	format := formatExtensions[strings.ToLower(filepath.Ext(path))]
+	if duration, err := mediaDuration(path, format); err == nil {
+		item.Duration = feedDuration(duration)
+	} else {
+		slog.Warn("Unable to measure episode, leaving out its duration", "file", path, "error", err)
+	}
+	return item, nil
+}
+
+func readSidecar(name string) (feedSidecar, error) {
+	var sidecar feedSidecar
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return sidecar, nil
+	}
+	if err != nil {
+		return sidecar, fmt.Errorf("unable to read sidecar: %w", err)
+	}
+	if err := json.Unmarshal(data, &sidecar); err != nil {
+		return sidecar, fmt.Errorf("unable to parse sidecar %s: %w", name, err)
+	}
+	return sidecar, nil
+}
+
+// parsePubDate accepts RFC 3339 times and plain dates such as 2025-03-14.
+func parsePubDate(value string) (time.Time, error) {
+	if published, err := time.Parse(time.RFC3339, value); err == nil {
+		return published, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// feedDuration formats d as HH:MM:SS for itunes:duration.
+func feedDuration(d time.Duration) string {
+	seconds := int(d.Round(time.Second).Seconds())
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteFeed(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"update-1.wav":  makeWAV(24000, 1, 16, 24000*90, 48000*90),
+		"update-1.json": []byte(`{"title": "Update & News", "description": "March notes.", "pub_date": "2025-03-14"}`),
+		"update-2.wav":  makeWAV(24000, 1, 16, 24000, 48000),
+		"update-2.json": []byte(`{"tool": "tts", "finished_at": "2025-03-21T09:30:00Z"}`),
+		"notes.md":      []byte("# Not audio"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	options := feedOptions{Dir: dir, BaseURL: "https://example.com/updates/", Title: "Weekly", Author: "Docs Team", Language: "en-us"}
+
+	var first, second bytes.Buffer
+	if err := writeFeed(&first, options); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := writeFeed(&second, options); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if first.String() != second.String() {
+		t.Errorf("Expected the same feed on a second run")
+	}
+
+	var feed rssFeed
+	if err := xml.Unmarshal(first.Bytes(), &feed); err != nil {
+		t.Fatalf("Expected valid XML, got %v", err)
+	}
+	items := feed.Channel.Items
+	if len(items) != 2 {
+		t.Fatalf("Expected 2 episodes, got %d", len(items))
+	}
+	if items[0].Title != "update-2" || items[0].PubDate != "Fri, 21 Mar 2025 09:30:00 +0000" {
+		t.Errorf("Expected the run report to date the newest episode, got %+v", items[0])
+	}
+	expected := rssEnclosure{URL: "https://example.com/updates/update-1.wav", Length: int64(len(files["update-1.wav"])), Type: "audio/wav"}
+	if items[1].Title != "Update & News" || items[1].Enclosure != expected {
+		t.Errorf("Unexpected episode %+v", items[1])
+	}
+	if feed.Channel.LastBuildDate != items[0].PubDate {
+		t.Errorf("Expected lastBuildDate %q, got %q", items[0].PubDate, feed.Channel.LastBuildDate)
+	}
+
+	output := first.String()
+	for _, expected := range []string{
+		`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">`,
+		"<itunes:author>Docs Team</itunes:author>",
+		"<itunes:duration>00:01:30</itunes:duration>",
+		"<title>Update &amp; News</title>",
+		`<guid isPermaLink="true">https://example.com/updates/update-1.wav</guid>`,
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected the feed to contain %q, got %s", expected, output)
+		}
+	}
+}
+
+func TestWriteFeedLeavesOutChunks(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"book.wav", "book_1.wav", "book_2.wav", "notes_1.wav", "story.wav", "story-01-intro.wav"} {
+		if err := os.WriteFile(filepath.Join(dir, name), makeWAV(24000, 1, 16, 24000, 48000), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for template, expected := range map[string][]string{
+		defaultNameTemplate:              {"book", "notes_1", "story", "story-01-intro"},
+		"{base}-{index}-{slug}.{format}": {"book", "book_1", "book_2", "notes_1", "story"},
+	} {
+		var buffer bytes.Buffer
+		if err := writeFeed(&buffer, feedOptions{Dir: dir, BaseURL: "https://example.com", NameTemplate: template}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var feed rssFeed
+		if err := xml.Unmarshal(buffer.Bytes(), &feed); err != nil {
+			t.Fatalf("Expected valid XML, got %v", err)
+		}
+		var titles []string
+		for _, item := range feed.Channel.Items {
+			titles = append(titles, item.Title)
+		}
+		sort.Strings(titles)
+		if !reflect.DeepEqual(titles, expected) {
+			t.Errorf("Expected episodes %q with %s, got %q", expected, template, titles)
+		}
+	}
+}
+
+func TestWriteFeedInvalidSidecar(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.wav"), makeWAV(24000, 1, 16, 10, 20), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.json"), []byte(`{"pub_date": "next week"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFeed(&bytes.Buffer{}, feedOptions{Dir: dir, BaseURL: "https://example.com"}); err == nil {
+		t.Errorf("Expected an error for an invalid pub_date, got nil")
+	}
+}
+
+func TestFeedDuration(t *testing.T) {
+	if duration := feedDuration(time.Hour + 2*time.Minute + 3600*time.Millisecond); duration != "01:02:04" {
+		t.Errorf("Expected 01:02:04, got %s", duration)
+	}
+}
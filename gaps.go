@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+func registerCombineFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.DurationVar(&flags.Gap, "gap", 0, "Silence between chunks in combined output, such as 600ms")
+	fs.DurationVar(&flags.ParagraphGap, "paragraph-gap", 0, "Silence between paragraphs in combined output (default: --gap)")
+	fs.DurationVar(&flags.Crossfade, "crossfade", 0, "Crossfade between parts of combined output, such as 30ms")
+	fs.DurationVar(&flags.MaxPartDuration, "max-part-duration", 0, "Split combined output into parts no longer than this, such as 30m")
+}
+
+func validateCombineFlags(flags Flags) error {
+	for _, option := range []struct {
+		name  string
+		value time.Duration
+	}{{"gap", flags.Gap}, {"paragraph-gap", flags.ParagraphGap}, {"crossfade", flags.Crossfade}} {
+		if option.value < 0 || option.value > maxPause {
+			return fmt.Errorf("--%s must be between 0 and %s", option.name, maxPause)
+		}
+	}
+	if flags.Crossfade > 0 {
+		for _, gap := range []time.Duration{flags.Gap, flags.ParagraphGap} {
+			if gap > 0 && gap <= flags.Crossfade {
+				return fmt.Errorf("--crossfade must be shorter than the gaps it blends")
+			}
+		}
+	}
+	switch {
+	case flags.MaxPartDuration < 0:
+		return fmt.Errorf("--max-part-duration must not be negative")
+	case flags.MaxPartDuration > 0 && isM4B(flags.OutputFile):
+		return fmt.Errorf("--max-part-duration cannot split M4B output, which keeps its chapters in one file")
+	case flags.MaxPartDuration > 0 && flags.Captions != "":
+		return fmt.Errorf("--max-part-duration cannot be used with --captions")
+	}
+	return nil
+}
+
+// insertGaps adds silent segments between consecutive spoken segments.
+// Boundaries that already have a pause from markup are left alone.
+func insertGaps(segments []segment, flags Flags) []segment {
+	paragraphGap := flags.ParagraphGap
+	if paragraphGap == 0 {
+		paragraphGap = flags.Gap
+	}
+	if flags.Gap == 0 && paragraphGap == 0 {
+		return segments
+	}
+
+	var result []segment
+	for i, current := range segments {
+		if i > 0 {
+			previous := segments[i-1]
+			if previous.Pause == 0 && current.Pause == 0 {
+				gap := flags.Gap
+				if paragraphBreak(previous, current, bufferFor(flags)) {
+					gap = paragraphGap
+				}
+				if gap > 0 {
+					result = append(result, segment{Speaker: current.Speaker, Pause: gap})
+				}
+			}
+		}
+		result = append(result, current)
+	}
+	return result
+}
+
+// paragraphSeparator matches a line break followed by blank lines.
+var paragraphSeparator = regexp.MustCompile(`\r?\n(?:[ \t]*\r?\n)+`)
+
+// gapParagraphs splits text after each run of blank lines when a paragraph gap
+// is inserted in combined output, so paragraphs inside one chunk are separated
+// too. The blank lines stay at the end of the paragraph before them, where
+// paragraphBreak finds them.
+func gapParagraphs(text string, flags Flags) []string {
+	if flags.ParagraphGap == 0 || (!flags.CombineFiles && !flags.Incremental) {
+		return []string{text}
+	}
+	var paragraphs []string
+	leading := ""
+	for rest := text; len(rest) > 0; {
+		end := len(rest)
+		if loc := paragraphSeparator.FindStringIndex(rest); loc != nil {
+			end = loc[1]
+		}
+		switch {
+		case strings.TrimSpace(rest[:end]) != "":
+			paragraphs = append(paragraphs, leading+rest[:end])
+			leading = ""
+		case len(paragraphs) > 0:
+			paragraphs[len(paragraphs)-1] += rest[:end]
+		default:
+			leading += rest[:end]
+		}
+		rest = rest[end:]
+	}
+	if len(paragraphs) == 0 {
+		return []string{text}
+	}
+	return paragraphs
+}
+
+// paragraphBreak reports whether a blank line or a change of speaker separates
+// two segments.
+func paragraphBreak(previous, next segment, buffer synth.Buffer) bool {
+	if previous.Speaker != next.Speaker {
+		return true
+	}
+	before := buffer.Strip(previous.Text)
+	after := buffer.Strip(next.Text)
+	trailing := before[len(strings.TrimRight(before, " \t\r\n")):]
+	leading := after[:len(after)-len(strings.TrimLeft(after, " \t\r\n"))]
+	return strings.Count(trailing+leading, "\n") >= 2
+}
+
+// applyGaps inserts gaps when the output is combined and warns that they are
+// ignored otherwise.
+func applyGaps(segments []segment, flags Flags) []segment {
+	if flags.CombineFiles || flags.Incremental {
+		return insertGaps(segments, flags)
+	}
+	if flags.Gap > 0 || flags.ParagraphGap > 0 || flags.Crossfade > 0 {
+		slog.Warn("Gaps and crossfades are only applied when combining files with -c")
+	}
+	return segments
+}
+
+// crossfadeArgs builds an ffmpeg command that joins files with acrossfade
+// instead of the concat demuxer, which can only butt files together.
+func crossfadeArgs(files []string, crossfade time.Duration, format, output string) []string {
+	args := []string{"-y"}
+	for _, file := range files {
+		if format == "pcm" {
+			args = append(args, "-f", "s16le", "-ar", strconv.Itoa(pcmSampleRate), "-ac", "1")
+		}
+		args = append(args, "-i", file)
+	}
+
+	seconds := strconv.FormatFloat(crossfade.Seconds(), 'f', 3, 64)
+	var filter strings.Builder
+	previous := "[0:a]"
+	for i := 1; i < len(files); i++ {
+		label := fmt.Sprintf("[x%d]", i)
+		fmt.Fprintf(&filter, "%s[%d:a]acrossfade=d=%s%s", previous, i, seconds, label)
+		if i < len(files)-1 {
+			filter.WriteString(";")
+		}
+		previous = label
+	}
+
+	args = append(args, "-filter_complex", filter.String(), "-map", previous)
+	args = append(args, ffmpegOutputArgs(format)...)
+	return append(args, output)
+}
+
+// readConcatList returns the files listed in an ffmpeg concat list written by
+// appendToTextFile.
+func readConcatList(textFileName string) ([]string, error) {
+	data, err := os.ReadFile(textFileName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read text file: %w", err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "file '") && strings.HasSuffix(line, "'") {
+			files = append(files, strings.TrimSuffix(strings.TrimPrefix(line, "file '"), "'"))
+		}
+	}
+	return files, nil
+}
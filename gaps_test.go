@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+func TestInsertGaps(t *testing.T) {
+	segments := []segment{
+		{Text: "First paragraph, part one "},
+		{Text: "and part two.\n\n"},
+		{Text: "Second paragraph."},
+		{Pause: time.Second},
+		{Text: "After a pause."},
+		{Text: "Another speaker.", Speaker: "BOB"},
+	}
+	flags := Flags{Gap: 200 * time.Millisecond, ParagraphGap: 600 * time.Millisecond}
+
+	var pauses []time.Duration
+	for _, segment := range insertGaps(segments, flags) {
+		if segment.Pause > 0 {
+			pauses = append(pauses, segment.Pause)
+		}
+	}
+	expected := []time.Duration{200 * time.Millisecond, 600 * time.Millisecond, time.Second, 600 * time.Millisecond}
+	if !reflect.DeepEqual(pauses, expected) {
+		t.Errorf("Expected pauses %v, got %v", expected, pauses)
+	}
+}
+
+func TestInsertGapsParagraphsOnly(t *testing.T) {
+	segments := []segment{{Text: "One "}, {Text: "two.\n"}, {Text: "\nThree."}}
+	result := insertGaps(segments, Flags{ParagraphGap: time.Second})
+	if len(result) != 4 || result[2].Pause != time.Second {
+		t.Errorf("Expected a single paragraph gap, got %+v", result)
+	}
+}
+
+func TestGapParagraphs(t *testing.T) {
+	flags := Flags{ParagraphGap: time.Second, CombineFiles: true}
+	paragraphs := gapParagraphs("\nOne.\r\n\r\nTwo.\n  \n\nThree.\n", flags)
+	if expected := []string{"\nOne.\r\n\r\n", "Two.\n  \n\n", "Three.\n"}; !reflect.DeepEqual(paragraphs, expected) {
+		t.Errorf("Expected paragraphs %q, got %q", expected, paragraphs)
+	}
+	if paragraphs := gapParagraphs("One.\n\nTwo.", Flags{ParagraphGap: time.Second}); len(paragraphs) != 1 {
+		t.Errorf("Expected text to stay whole when files are not combined, got %q", paragraphs)
+	}
+}
+
+func TestReadSegmentsParagraphGapInsideChunk(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.md")
+	if err := os.WriteFile(path, []byte("First paragraph.\n\nSecond paragraph.\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+	segments, err := readSegments(Flags{InputFile: path, CombineFiles: true, ParagraphGap: 700 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []segment{
+		{Text: "First paragraph.\n\n"},
+		{Pause: 700 * time.Millisecond},
+		{Text: "Second paragraph.\n"},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("Expected a gap between the paragraphs of one chunk, got %+v", segments)
+	}
+}
+
+func TestParagraphBreakIgnoresBufferText(t *testing.T) {
+	previous := segment{Text: "Begin Text\nOne.\n\n\nEnd Text"}
+	next := segment{Text: "Begin Text\nTwo.\nEnd Text"}
+	if !paragraphBreak(previous, next, synth.Buffer{}) {
+		t.Error("Expected a paragraph break inside buffer words to be detected")
+	}
+}
+
+func TestValidateCombineFlags(t *testing.T) {
+	invalid := []Flags{
+		{Gap: -time.Second},
+		{ParagraphGap: 2 * time.Minute},
+		{Gap: 20 * time.Millisecond, Crossfade: 50 * time.Millisecond},
+		{MaxPartDuration: -time.Minute},
+		{MaxPartDuration: 30 * time.Minute, OutputFile: "book.m4b"},
+		{MaxPartDuration: 30 * time.Minute, Captions: "book.vtt"},
+	}
+	for _, flags := range invalid {
+		if err := validateCombineFlags(flags); err == nil {
+			t.Errorf("Expected an error for %+v", flags)
+		}
+	}
+	if err := validateCombineFlags(Flags{Gap: 600 * time.Millisecond, Crossfade: 30 * time.Millisecond}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestCrossfadeArgs(t *testing.T) {
+	args := crossfadeArgs([]string{"a.mp3", "b.mp3", "c.mp3"}, 30*time.Millisecond, "mp3", "out.mp3")
+	command := strings.Join(args, " ")
+	expected := "-y -i a.mp3 -i b.mp3 -i c.mp3 -filter_complex [0:a][1:a]acrossfade=d=0.030[x1];[x1][2:a]acrossfade=d=0.030[x2] -map [x2] -f mp3 out.mp3"
+	if command != expected {
+		t.Errorf("Expected %q, got %q", expected, command)
+	}
+}
+
+func TestProcessChunksSharesSilenceFiles(t *testing.T) {
+	config := Config{OpenAIAPIKey: "test-api-key", httpClient: &MockHTTPClient{DoFunc: mockAudio}}
+	flags := Flags{
+		OutputFile:   filepath.Join(t.TempDir(), "out.wav"),
+		FormatOption: "wav",
+		CombineFiles: true,
+		Gap:          500 * time.Millisecond,
+	}
+	segments := insertGaps([]segment{{Text: "One."}, {Text: "Two."}, {Text: "Three."}}, flags)
+
+	var createdFiles []string
+	if err := processChunks(context.Background(), segments, flags, config, &createdFiles); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	files, err := readConcatList(strings.TrimSuffix(flags.OutputFile, ".wav") + ".txt")
+	if err != nil {
+		t.Fatalf("Failed to read concat list: %v", err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("Expected 5 files in the concat list, got %v", files)
+	}
+	if files[1] != files[3] {
+		t.Errorf("Expected both gaps to use the same silence file, got %s and %s", files[1], files[3])
+	}
+	if duration, err := audioDuration(files[1], "wav"); err != nil || duration != 500*time.Millisecond {
+		t.Errorf("Expected 500ms of silence, got %v (%v)", duration, err)
+	}
+}
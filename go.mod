@@ -1,3 +1,3 @@
 module github.com/StevenDStanton/cli-tools/tts
 
-go 1.22.4
+go 1.23
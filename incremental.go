@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+const manifestVersion = 1
+
+// buildManifest records the chunks of the last incremental build so the next
+// build can tell which chunks changed.
+type buildManifest struct {
+	Version int             `json:"version"`
+	Output  string          `json:"output"`
+	Chunks  []manifestChunk `json:"chunks"`
+}
+
+type manifestChunk struct {
+	Index      int    `json:"index"`
+	Key        string `json:"key"`
+	File       string `json:"file"`
+	Characters int    `json:"characters"`
+}
+
+func incrementalPaths(outputFile string) (chunkDir, manifestPath string) {
+	base := strings.TrimSuffix(outputFile, filepath.Ext(outputFile))
+	return base + ".chunks", base + ".manifest.json"
+}
+
+// synthesizeIncremental stores each chunk under a name derived from its text and
+// synthesis settings, synthesizes only chunks the previous build did not
+// produce, and recombines all of them into the output file.
+func synthesizeIncremental(ctx context.Context, segments []segment, flags Flags, config Config) error {
+	chunkDir, manifestPath := incrementalPaths(flags.OutputFile)
+	previous, err := loadManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(chunkDir, 0o755); err != nil {
+		return fmt.Errorf("unable to create chunk directory: %w", err)
+	}
+
+	known := make(map[string]bool, len(previous.Chunks))
+	for _, chunk := range previous.Chunks {
+		known[chunk.Key] = true
+	}
+
+	synthesizer := synthesizerFor(flags, config)
+	manifest := buildManifest{Version: manifestVersion, Output: flags.OutputFile}
+	var files []string
+	reused, synthesized := 0, 0
+
+	for i, segment := range segments {
+		ttsRequest := segment.request(flags)
+		key := chunkKey(ttsRequest, flags)
+		if segment.Pause > 0 {
+			key = fmt.Sprintf("pause-%d", segment.Pause.Milliseconds())
+		}
+		chunkFile := filepath.Join(chunkDir, key+"."+flags.FormatOption)
+		characters := utf8.RuneCountInString(segment.Text)
+
+		_, statErr := os.Stat(chunkFile)
+		exists := statErr == nil
+
+		config.progress.startChunk(i + 1)
+		switch {
+		case segment.Pause > 0:
+			if !exists {
+				if err := writeSilence(chunkFile, flags.FormatOption, segment.Pause); err != nil {
+					return err
+				}
+			}
+		case exists && known[key]:
+			reused++
+			config.report.addReusedChunk(i+1, ttsRequest, chunkFile)
+		default:
+			response, err := synthesizeChunkFile(ctx, ttsRequest, chunkFile, flags, synthesizer, config)
+			if err != nil {
+				return err
+			}
+			config.report.addChunk(i+1, ttsRequest, chunkFile, response)
+			synthesized++
+		}
+		config.progress.finishChunk(characters)
+
+		files = append(files, chunkFile)
+		manifest.Chunks = append(manifest.Chunks, manifestChunk{
+			Index:      i + 1,
+			Key:        key,
+			File:       chunkFile,
+			Characters: characters,
+		})
+	}
+
+	slog.Info("Incremental build", "chunks", len(segments), "reused", reused, "synthesized", synthesized)
+
+	split, err := splitChunks(segments, files, flags, config)
+	if err != nil {
+		return err
+	}
+	if !split {
+		if err := assembleChunks(flags, files); err != nil {
+			return err
+		}
+		tags := newAudioTags(flags, segments)
+		durations := measureChunks(files, flags, config)
+		tags.Chapters = chunkChapters(segments, durations, flags)
+		config.captions.add(segments, durations, flags)
+		tagAudio(flags.OutputFile, flags.FormatOption, tags)
+		config.report.setCombined(flags.OutputFile, flags.FormatOption)
+	}
+
+	removeOrphanedChunks(chunkDir, manifest)
+	return saveManifest(manifestPath, manifest)
+}
+
+// chunkKey names the stored audio of a chunk. Besides the request it covers
+// the buffer trimming applied after synthesis, so changing --keep-buffer or the
+// buffer phrases never reuses audio trimmed the other way.
+func chunkKey(ttsRequest TTSRequest, flags Flags) string {
+	key := requestKey(ttsRequest)
+	if !flags.BufferTextFlag || flags.KeepBuffer {
+		return key
+	}
+	trimStart, trimEnd := trimSides(flags)
+	trim := fmt.Sprintf("trim:%t:%t:%v:%v:%v:%v:%v:%v", trimStart, trimEnd,
+		trimWindow, trimMinSilence, trimMinPhrase, trimMaxPhrase, trimPadding, trimThreshold)
+	sum := sha256.Sum256([]byte(key + "\x00" + trim))
+	return hex.EncodeToString(sum[:])
+}
+
+// pendingChunks counts the chunks an incremental build of parts still has to
+// synthesize and the ones it can reuse from the previous build.
+func pendingChunks(parts []part, flags Flags) (pending, reused int) {
+	for _, part := range parts {
+		partFlags := flags
+		partFlags.OutputFile = part.Output
+		chunkDir, manifestPath := incrementalPaths(part.Output)
+		previous, err := loadManifest(manifestPath)
+		if err != nil {
+			slog.Debug("Unable to load build manifest", "file", manifestPath, "error", err)
+		}
+		known := make(map[string]bool, len(previous.Chunks))
+		for _, chunk := range previous.Chunks {
+			known[chunk.Key] = true
+		}
+
+		for _, segment := range part.Segments {
+			if segment.Pause > 0 {
+				continue
+			}
+			key := chunkKey(segment.request(partFlags), partFlags)
+			if _, err := os.Stat(filepath.Join(chunkDir, key+"."+flags.FormatOption)); err == nil && known[key] {
+				reused++
+			} else {
+				pending++
+			}
+		}
+	}
+	return pending, reused
+}
+
+// synthesizeChunkFile writes to a temporary file first so an interrupted run
+// never leaves a partial chunk that a later build would reuse.
+func synthesizeChunkFile(ctx context.Context, ttsRequest TTSRequest, chunkFile string, flags Flags, synthesizer *synth.Synthesizer, config Config) (result synth.Result, err error) {
+	tmpFile := chunkFile + ".tmp"
+	result, err = processChunk(ctx, ttsRequest, tmpFile, synthesizer, config)
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		return result, err
+	}
+	trimBufferWords(tmpFile, flags)
+	if err := os.Rename(tmpFile, chunkFile); err != nil {
+		_ = os.Remove(tmpFile)
+		return result, fmt.Errorf("unable to store chunk: %w", err)
+	}
+	return result, nil
+}
+
+func assembleChunks(flags Flags, files []string) error {
+	if len(files) == 1 {
+		return copyFile(files[0], flags.OutputFile)
+	}
+
+	textFileName := fmt.Sprintf("%s.txt", strings.TrimSuffix(flags.OutputFile, filepath.Ext(flags.OutputFile)))
+	_ = os.Remove(textFileName)
+	for _, file := range files {
+		absFile, err := filepath.Abs(file)
+		if err != nil {
+			return fmt.Errorf("unable to get absolute path for chunk file: %w", err)
+		}
+		if err := appendToTextFile(textFileName, absFile); err != nil {
+			return err
+		}
+	}
+	return combineFiles(flags, []string{textFileName})
+}
+
+func removeOrphanedChunks(chunkDir string, manifest buildManifest) {
+	current := make(map[string]bool, len(manifest.Chunks))
+	for _, chunk := range manifest.Chunks {
+		current[filepath.Base(chunk.File)] = true
+	}
+
+	entries, err := os.ReadDir(chunkDir)
+	if err != nil {
+		slog.Warn("Unable to read chunk directory", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || current[entry.Name()] {
+			continue
+		}
+		slog.Debug("Removing unused chunk", "file", entry.Name())
+		if err := os.Remove(filepath.Join(chunkDir, entry.Name())); err != nil {
+			slog.Warn("Unable to remove unused chunk", "file", entry.Name(), "error", err)
+		}
+	}
+}
+
+func loadManifest(path string) (buildManifest, error) {
+	var manifest buildManifest
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, fmt.Errorf("unable to read build manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("unable to parse build manifest: %w", err)
+	}
+	if manifest.Version != manifestVersion {
+		slog.Warn("Ignoring build manifest from a different version", "file", path)
+		return buildManifest{}, nil
+	}
+	return manifest, nil
+}
+
+func saveManifest(path string, manifest buildManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode build manifest: %w", err)
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return fmt.Errorf("unable to save build manifest: %w", err)
+	}
+	return nil
+}
+
+func copyFile(source, destination string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", source, err)
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.Create(destination)
+	if err != nil {
+		return fmt.Errorf("unable to create output file: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return fmt.Errorf("unable to copy %s: %w", source, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSynthesizeIncremental(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	config := Config{
+		OpenAIAPIKey: "test-api-key",
+		httpClient: &MockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			calls++
+			return mockAudio(req)
+		}},
+	}
+	flags := Flags{
+		OutputFile:   filepath.Join(dir, "book.mp3"),
+		FormatOption: "mp3",
+		ModelOption:  "tts-1",
+		VoiceOption:  "nova",
+		SpeedOption:  "1.0",
+		Incremental:  true,
+	}
+
+	if err := synthesizeFile(context.Background(), textSegments([]string{"First draft."}), flags, config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected 1 API call, got %d", calls)
+	}
+	data, err := os.ReadFile(flags.OutputFile)
+	if err != nil || string(data) != "Mock audio data" {
+		t.Fatalf("Expected combined output, got %q (%v)", data, err)
+	}
+
+	if err := synthesizeFile(context.Background(), textSegments([]string{"First draft."}), flags, config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected an unchanged chunk to be reused, got %d API calls", calls)
+	}
+	parts := []part{{Output: flags.OutputFile, Segments: textSegments([]string{"First draft.", "New text."})}}
+	if pending, reused := pendingChunks(parts, flags); pending != 1 || reused != 1 {
+		t.Errorf("Expected 1 pending and 1 reused chunk, got %d and %d", pending, reused)
+	}
+
+	if err := synthesizeFile(context.Background(), textSegments([]string{"Second draft."}), flags, config); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected an edited chunk to be resynthesized, got %d API calls", calls)
+	}
+
+	chunkDir, manifestPath := incrementalPaths(flags.OutputFile)
+	entries, err := os.ReadDir(chunkDir)
+	if err != nil {
+		t.Fatalf("Failed to read chunk directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected the replaced chunk to be removed, got %d files", len(entries))
+	}
+
+	manifest, err := loadManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(manifest.Chunks) != 1 || filepath.Base(manifest.Chunks[0].File) != entries[0].Name() {
+		t.Errorf("Expected manifest to list the current chunk, got %+v", manifest.Chunks)
+	}
+}
+
+func TestSynthesizeIncrementalFailureKeepsNoPartialChunk(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{
+		OpenAIAPIKey: "test-api-key",
+		httpClient: &MockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			return nil, context.Canceled
+		}},
+	}
+	flags := Flags{OutputFile: filepath.Join(dir, "book.mp3"), FormatOption: "mp3", Incremental: true}
+
+	if err := synthesizeFile(context.Background(), textSegments([]string{"Text"}), flags, config); err == nil {
+		t.Fatal("Expected an error")
+	}
+	chunkDir, _ := incrementalPaths(flags.OutputFile)
+	entries, _ := os.ReadDir(chunkDir)
+	if len(entries) != 0 {
+		t.Errorf("Expected no chunk files after a failure, got %d", len(entries))
+	}
+}
+
+func TestChunkKeyCoversBufferTrimming(t *testing.T) {
+	ttsRequest := TTSRequest{Model: "tts-1", Voice: "nova", Input: "Begin Text\nHello.\nEnd Text", Format: "mp3", Speed: "1.0"}
+	plain := chunkKey(ttsRequest, Flags{})
+	trimmed := chunkKey(ttsRequest, Flags{BufferTextFlag: true})
+	kept := chunkKey(ttsRequest, Flags{BufferTextFlag: true, KeepBuffer: true})
+	startOnly := chunkKey(ttsRequest, Flags{BufferTextFlag: true, BufferStart: "Begin Text"})
+
+	if plain != requestKey(ttsRequest) || kept != plain {
+		t.Errorf("Expected untrimmed chunks to use the request key")
+	}
+	if trimmed == kept || startOnly == trimmed {
+		t.Errorf("Expected the buffer trimming to change the key, got %s, %s and %s", trimmed, kept, startOnly)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fileInfo is the JSON form of an audio file's measurements.
+type fileInfo struct {
+	File       string  `json:"file"`
+	Format     string  `json:"format"`
+	Duration   float64 `json:"duration_seconds"`
+	SampleRate int     `json:"sample_rate"`
+	Channels   int     `json:"channels"`
+}
+
+// runInfo prints the format, duration, sample rate and channels of audio
+// files, measured by the native parsers.
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ContinueOnError)
+	format := fs.String("fmt", "", "Audio format, detected from the file when not set")
+	asJSON := fs.Bool("json", false, "Write the measurements as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("unable to parse info flags: %w", err)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("a file must be specified. Usage: tts info [--fmt FORMAT] [--json] FILE...")
+	}
+	return writeAudioInfo(os.Stdout, fs.Args(), *format, *asJSON)
+}
+
+func writeAudioInfo(w io.Writer, files []string, format string, asJSON bool) error {
+	infos := make([]fileInfo, 0, len(files))
+	for _, file := range files {
+		info, err := readAudioInfo(file, format)
+		if err != nil {
+			return fmt.Errorf("unable to measure %s: %w", file, err)
+		}
+		infos = append(infos, fileInfo{
+			File:       file,
+			Format:     info.Format,
+			Duration:   info.Duration.Seconds(),
+			SampleRate: info.SampleRate,
+			Channels:   info.Channels,
+		})
+		if !asJSON {
+			channels := "channels"
+			if info.Channels == 1 {
+				channels = "channel"
+			}
+			fmt.Fprintf(w, "%s: %s, %s, %d Hz, %d %s\n",
+				file, info.Format, captionTime(info.Duration, true), info.SampleRate, info.Channels, channels)
+		}
+	}
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	}
+	return nil
+}
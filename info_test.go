@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAudioInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "speech.wav")
+	if err := os.WriteFile(path, makeWAV(24000, 1, 16, 36000, 72000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := writeAudioInfo(&out, []string{path}, "", false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := path + ": wav, 00:00:01.500, 24000 Hz, 1 channel\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	out.Reset()
+	if err := writeAudioInfo(&out, []string{path}, "", true); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var infos []fileInfo
+	if err := json.Unmarshal(out.Bytes(), &infos); err != nil {
+		t.Fatalf("Expected JSON, got %v", err)
+	}
+	if len(infos) != 1 || infos[0].Duration != 1.5 || infos[0].Format != "wav" {
+		t.Errorf("Unexpected JSON %+v", infos)
+	}
+
+	if err := writeAudioInfo(&out, []string{filepath.Join(t.TempDir(), "missing.mp3")}, "", false); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	globalLexiconFile  = "tts.lexicon"
+	projectLexiconFile = ".tts-lexicon"
+)
+
+// lexicon rewrites text before it is chunked so product names and acronyms are
+// pronounced correctly. Rules apply in order, each to the output of the last.
+type lexicon struct {
+	rules []lexiconRule
+}
+
+type lexiconRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+	literal     bool
+	voices      []string
+	models      []string
+	source      string
+}
+
+// lexiconPaths lists the lexicon files in order of precedence: the --lexicon
+// file, the project file in the working directory, then the global file.
+func lexiconPaths(flags Flags) []string {
+	var paths []string
+	if flags.LexiconFile != "" {
+		paths = append(paths, flags.LexiconFile)
+	}
+	paths = append(paths, projectLexiconFile)
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, config_dir, globalLexiconFile))
+	}
+	return paths
+}
+
+// loadLexicon reads the lexicon files that exist. Only an explicitly named
+// file is required.
+func loadLexicon(flags Flags) (*lexicon, error) {
+	lex := &lexicon{}
+	if flags.NoLexicon {
+		return lex, nil
+	}
+	for _, path := range lexiconPaths(flags) {
+		file, err := os.Open(path)
+		if os.IsNotExist(err) && path != flags.LexiconFile {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to open lexicon: %w", err)
+		}
+		rules, err := parseLexicon(file, path)
+		_ = file.Close()
+		if err != nil {
+			return nil, err
+		}
+		lex.rules = append(lex.rules, rules...)
+	}
+	return lex, nil
+}
+
+// parseLexicon reads rules of the form
+//
+//	[@voice=V1,V2] [@model=M] PATTERN => REPLACEMENT
+//
+// PATTERN is a literal matched as a whole word, or /regexp/ with an optional
+// i flag, in which case REPLACEMENT may refer to groups as $1. Blank lines
+// and lines starting with # are ignored.
+func parseLexicon(r io.Reader, name string) ([]lexiconRule, error) {
+	var rules []lexiconRule
+	scanner := bufio.NewScanner(r)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseLexiconRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, number, err)
+		}
+		rule.source = fmt.Sprintf("%s:%d", name, number)
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read lexicon %s: %w", name, err)
+	}
+	return rules, nil
+}
+
+func parseLexiconRule(line string) (lexiconRule, error) {
+	var rule lexiconRule
+	for strings.HasPrefix(line, "@") {
+		scope, rest, _ := strings.Cut(line, " ")
+		key, values, ok := strings.Cut(scope[1:], "=")
+		if !ok || values == "" {
+			return rule, fmt.Errorf("invalid scope %q, expected @voice=NAME or @model=NAME", scope)
+		}
+		switch key {
+		case "voice":
+			rule.voices = append(rule.voices, strings.Split(values, ",")...)
+		case "model":
+			rule.models = append(rule.models, strings.Split(values, ",")...)
+		default:
+			return rule, fmt.Errorf("unknown scope %q", key)
+		}
+		line = strings.TrimSpace(rest)
+	}
+
+	if strings.HasPrefix(line, "/") {
+		end := closingSlash(line)
+		if end < 0 {
+			return rule, fmt.Errorf("unterminated regular expression")
+		}
+		expression := line[1:end]
+		rest := line[end+1:]
+		flags := rest[:len(rest)-len(strings.TrimLeft(rest, "i"))]
+		if flags != "" {
+			expression = "(?i)" + expression
+		}
+		after, ok := strings.CutPrefix(strings.TrimSpace(rest[len(flags):]), "=>")
+		if !ok {
+			return rule, fmt.Errorf("expected => after the regular expression")
+		}
+		compiled, err := regexp.Compile(expression)
+		if err != nil {
+			return rule, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		rule.pattern = compiled
+		rule.replacement = strings.TrimSpace(after)
+		return rule, nil
+	}
+
+	pattern, replacement, ok := strings.Cut(line, "=>")
+	pattern = strings.TrimSpace(pattern)
+	if !ok || pattern == "" {
+		return rule, fmt.Errorf("expected PATTERN => REPLACEMENT")
+	}
+	expression := regexp.QuoteMeta(pattern)
+	if isWordByte(pattern[0]) {
+		expression = `\b` + expression
+	}
+	if isWordByte(pattern[len(pattern)-1]) {
+		expression += `\b`
+	}
+	rule.pattern = regexp.MustCompile(expression)
+	rule.replacement = strings.TrimSpace(replacement)
+	rule.literal = true
+	return rule, nil
+}
+
+func closingSlash(line string) int {
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '/':
+			return i
+		}
+	}
+	return -1
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (r lexiconRule) appliesTo(voice, model string) bool {
+	return (len(r.voices) == 0 || slices.Contains(r.voices, voice)) &&
+		(len(r.models) == 0 || slices.Contains(r.models, model))
+}
+
+func (l *lexicon) apply(text, voice, model string) string {
+	if l == nil {
+		return text
+	}
+	for _, rule := range l.rules {
+		if !rule.appliesTo(voice, model) {
+			continue
+		}
+		var rewritten string
+		if rule.literal {
+			rewritten = rule.pattern.ReplaceAllLiteralString(text, rule.replacement)
+		} else {
+			rewritten = rule.pattern.ReplaceAllString(text, rule.replacement)
+		}
+		if rewritten != text {
+			slog.Debug("Applied lexicon rule", "rule", rule.source)
+			text = rewritten
+		}
+	}
+	return text
+}
+
+func runLexicon(args []string) error {
+	if len(args) == 0 || args[0] != "test" {
+		return fmt.Errorf("unknown lexicon command. Usage: tts lexicon test [OPTIONS] \"text\"")
+	}
+
+	flags := Flags{}
+	fs := flag.NewFlagSet("lexicon test", flag.ContinueOnError)
+	fs.StringVar(&flags.VoiceOption, "v", default_voice, "Voice the rules are scoped to")
+	fs.StringVar(&flags.ModelOption, "m", default_model, "Model the rules are scoped to")
+	fs.StringVar(&flags.LexiconFile, "lexicon", "", "Additional lexicon file")
+	registerNormalizeFlags(fs, &flags)
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("unable to parse lexicon flags: %w", err)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("text must be specified. Usage: tts lexicon test [OPTIONS] \"text\"")
+	}
+
+	lex, err := loadLexicon(flags)
+	if err != nil {
+		return err
+	}
+	norm, err := newNormalizer(flags)
+	if err != nil {
+		return err
+	}
+	fmt.Println(norm.apply(lex.apply(strings.Join(fs.Args(), " "), flags.VoiceOption, flags.ModelOption)))
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLexicon = `# Product names
+kubectl => cube control
+SQL => sequel
+/\bnginx\b/i => engine x
+/(\d+)ms\b/ => $1 milliseconds
+@voice=onyx GIF => jif
+@model=tts-1 @voice=nova,alloy GIF => gift
+C++ => C plus plus
+`
+
+func TestLexiconApply(t *testing.T) {
+	rules, err := parseLexicon(strings.NewReader(testLexicon), "test.lexicon")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	lex := &lexicon{rules: rules}
+
+	cases := []struct {
+		text, voice, model, expected string
+	}{
+		{"Run kubectl against SQL and NGINX.", "nova", "tts-1-hd", "Run cube control against sequel and engine x."},
+		{"MySQL is not SQL.", "nova", "tts-1-hd", "MySQL is not sequel."},
+		{"Wait 800ms.", "nova", "tts-1-hd", "Wait 800 milliseconds."},
+		{"A GIF.", "onyx", "tts-1-hd", "A jif."},
+		{"A GIF.", "nova", "tts-1", "A gift."},
+		{"A GIF.", "nova", "tts-1-hd", "A GIF."},
+		{"I like C++.", "nova", "tts-1-hd", "I like C plus plus."},
+	}
+	for _, c := range cases {
+		if rewritten := lex.apply(c.text, c.voice, c.model); rewritten != c.expected {
+			t.Errorf("apply(%q, %s, %s) = %q; expected %q", c.text, c.voice, c.model, rewritten, c.expected)
+		}
+	}
+}
+
+func TestParseLexiconErrors(t *testing.T) {
+	for _, line := range []string{
+		"no arrow here",
+		"=> missing pattern",
+		"/unterminated => x",
+		"/[/ => x",
+		"/ok/ missing arrow",
+		"@pitch=high word => x",
+		"@voice word => x",
+	} {
+		if _, err := parseLexicon(strings.NewReader(line), "bad.lexicon"); err == nil {
+			t.Errorf("Expected an error for %q", line)
+		} else if !strings.HasPrefix(err.Error(), "bad.lexicon:1:") {
+			t.Errorf("Expected the error to name the file and line, got %v", err)
+		}
+	}
+}
+
+func TestLoadLexiconPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+
+	if err := os.MkdirAll(filepath.Join(dir, config_dir), 0o755); err != nil {
+		t.Fatalf("Failed to create config directory: %v", err)
+	}
+	files := map[string]string{
+		filepath.Join(dir, config_dir, globalLexiconFile): "SQL => S Q L\nnginx => engine x\n",
+		projectLexiconFile: "SQL => sequel\n",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	lex, err := loadLexicon(Flags{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if rewritten := lex.apply("SQL behind nginx", "nova", "tts-1"); rewritten != "sequel behind engine x" {
+		t.Errorf("Expected project rules to win over global rules, got %q", rewritten)
+	}
+
+	if _, err := loadLexicon(Flags{LexiconFile: "missing.lexicon"}); err == nil {
+		t.Error("Expected an error for a missing --lexicon file")
+	}
+	if lex, _ := loadLexicon(Flags{NoLexicon: true}); len(lex.rules) != 0 {
+		t.Errorf("Expected --no-lexicon to load no rules, got %d", len(lex.rules))
+	}
+}
+
+func TestReadSegmentsAppliesLexiconPerVoice(t *testing.T) {
+	dir := t.TempDir()
+	lexiconFile := filepath.Join(dir, "rules.lexicon")
+	input := filepath.Join(dir, "input.md")
+	if err := os.WriteFile(lexiconFile, []byte("@voice=onyx GIF => jif\nGIF => gif\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write lexicon: %v", err)
+	}
+	if err := os.WriteFile(input, []byte("A GIF. [voice onyx]A GIF.[/voice]"), 0o644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	segments, err := readSegments(Flags{InputFile: input, LexiconFile: lexiconFile, VoiceOption: "nova", Markup: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(segments) != 2 || segments[0].Text != "A gif. " || segments[1].Text != "A jif." {
+		t.Errorf("Expected voice scoped rules, got %+v", segments)
+	}
+}
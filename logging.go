@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+)
+
+const redacted = "[REDACTED]"
+
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+func setupLogger(flags Flags, w io.Writer) error {
+	level := slog.LevelInfo
+	switch {
+	case flags.Quiet:
+		level = slog.LevelWarn
+	case flags.Verbose, flags.Debug:
+		level = slog.LevelDebug
+	}
+
+	var handler slog.Handler
+	switch flags.LogFormat {
+	case "", "text":
+		handler = slog.NewTextHandler(w, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			},
+		})
+	case "json":
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: level})
+	default:
+		return fmt.Errorf("unsupported log format: %s (expected text or json)", flags.LogFormat)
+	}
+
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+func terminalPath() string {
+	if runtime.GOOS == "windows" {
+		return "CONOUT$"
+	}
+	return "/dev/tty"
+}
+
+var promptOutput = func() io.WriteCloser {
+	tty, err := os.OpenFile(terminalPath(), os.O_WRONLY, 0)
+	if err != nil {
+		return nopWriteCloser{os.Stderr}
+	}
+	return tty
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func prompt(format string, args ...any) {
+	out := promptOutput()
+	defer func() {
+		_ = out.Close()
+	}()
+	_, _ = fmt.Fprintf(out, format, args...)
+}
+
+type debugHTTPClient struct {
+	next HTTPClient
+}
+
+func newHTTPClient(client HTTPClient, flags Flags) HTTPClient {
+	if flags.Debug {
+		return &debugHTTPClient{next: client}
+	}
+	return client
+}
+
+func (c *debugHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	slog.Debug("HTTP request",
+		"method", req.Method,
+		"url", req.URL.String(),
+		"body_bytes", req.ContentLength,
+		headerGroup(req.Header),
+	)
+
+	resp, err := c.next.Do(req)
+	if err != nil {
+		slog.Debug("HTTP request failed", "error", err)
+		return resp, err
+	}
+
+	slog.Debug("HTTP response",
+		"status", resp.StatusCode,
+		"body_bytes", resp.ContentLength,
+		headerGroup(resp.Header),
+	)
+	return resp, nil
+}
+
+func headerGroup(header http.Header) slog.Attr {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]any, 0, len(keys))
+	for _, key := range keys {
+		value := redactHeader(key, header.Values(key))
+		attrs = append(attrs, slog.String(key, value))
+	}
+	return slog.Group("headers", attrs...)
+}
+
+func redactHeader(key string, values []string) string {
+	if sensitiveHeaders[http.CanonicalHeaderKey(key)] {
+		return redacted
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return fmt.Sprint(values)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSetupLogger(t *testing.T) {
+	original := slog.Default()
+	defer slog.SetDefault(original)
+
+	var buf bytes.Buffer
+	if err := setupLogger(Flags{Quiet: true}, &buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	slog.Info("hidden")
+	slog.Warn("shown")
+	if strings.Contains(buf.String(), "hidden") || !strings.Contains(buf.String(), "shown") {
+		t.Errorf("Expected only warnings in quiet mode, got '%s'", buf.String())
+	}
+	if strings.Contains(buf.String(), "time=") {
+		t.Errorf("Expected text logs without timestamps, got '%s'", buf.String())
+	}
+
+	buf.Reset()
+	if err := setupLogger(Flags{Verbose: true, LogFormat: "json"}, &buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	slog.Debug("detail", "file", "out.mp3")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected JSON log line, got '%s': %v", buf.String(), err)
+	}
+	if entry["msg"] != "detail" || entry["file"] != "out.mp3" {
+		t.Errorf("Unexpected JSON log entry: %v", entry)
+	}
+
+	if err := setupLogger(Flags{LogFormat: "xml"}, &buf); err == nil {
+		t.Errorf("Expected error for unsupported log format, got nil")
+	}
+}
+
+func TestDebugHTTPClient(t *testing.T) {
+	original := slog.Default()
+	defer slog.SetDefault(original)
+
+	var buf bytes.Buffer
+	if err := setupLogger(Flags{Debug: true}, &buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			header := http.Header{}
+			header.Set("X-Request-Id", "req_abc")
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(""))}, nil
+		},
+	}
+	client := newHTTPClient(mockClient, Flags{Debug: true})
+
+	req, err := http.NewRequest("POST", api_url, strings.NewReader(`{"input":"hello"}`))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	if _, err := client.Do(req); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	output := buf.String()
+	if strings.Contains(output, "sk-secret") {
+		t.Errorf("Expected Authorization header to be redacted, got '%s'", output)
+	}
+	for _, expected := range []string{redacted, "body_bytes=17", "req_abc"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected debug output to contain '%s', got '%s'", expected, output)
+		}
+	}
+
+	if client := newHTTPClient(mockClient, Flags{}); client != mockClient {
+		t.Errorf("Expected client to be returned unchanged without --debug")
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// m4bBitrate is the AAC bitrate of M4B output, plenty for mono speech.
+const m4bBitrate = "64k"
+
+type chapterMark struct {
+	Title string
+	Start time.Duration
+	End   time.Duration
+}
+
+func isM4B(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".m4b")
+}
+
+// m4bPartsOutput names the chapter files an M4B is bound from after the
+// output, with the extension of the synthesized format.
+func m4bPartsOutput(flags Flags) string {
+	return strings.TrimSuffix(flags.OutputFile, filepath.Ext(flags.OutputFile)) + "." + flags.FormatOption
+}
+
+func m4bMetadataFile(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + ".ffmetadata"
+}
+
+// assembleM4B binds the chapter files of b into the M4B output with a chapter
+// table, title, author and cover. The chapter files are removed afterwards.
+func assembleM4B(flags Flags, b book) error {
+	base := strings.TrimSuffix(flags.OutputFile, filepath.Ext(flags.OutputFile))
+	textFileName := base + ".txt"
+	_ = os.Remove(textFileName)
+	createdFiles := []string{textFileName}
+
+	marks := make([]chapterMark, 0, len(b.Parts))
+	var start time.Duration
+	for _, part := range b.Parts {
+		duration, err := mediaDuration(part.Output, flags.FormatOption)
+		if err != nil {
+			return fmt.Errorf("unable to measure chapter %q: %w", part.Title, err)
+		}
+		marks = append(marks, chapterMark{Title: part.Title, Start: start, End: start + duration})
+		start += duration
+
+		absFile, err := filepath.Abs(part.Output)
+		if err != nil {
+			return fmt.Errorf("unable to get absolute path for chapter file: %w", err)
+		}
+		if err := appendToTextFile(textFileName, absFile); err != nil {
+			return err
+		}
+		createdFiles = append(createdFiles, part.Output)
+	}
+
+	tags := audioTags{Title: b.Title, Artist: b.Author, Album: cmp.Or(b.Album, b.Title), Comment: b.Comment}
+	if flags.Disclose {
+		var segments []segment
+		for _, part := range b.Parts {
+			segments = append(segments, part.Segments...)
+		}
+		tags.Disclosure = disclosure(flags, segments)
+	}
+	metadataFile := m4bMetadataFile(flags.OutputFile)
+	if err := os.WriteFile(metadataFile, []byte(chapterMetadata(tags, marks)), 0o644); err != nil {
+		return fmt.Errorf("unable to write chapter metadata: %w", err)
+	}
+	createdFiles = append(createdFiles, metadataFile)
+
+	flags.Cover = ""
+	if b.Cover != nil {
+		flags.Cover = base + ".cover" + b.Cover.extension()
+		if err := os.WriteFile(flags.Cover, b.Cover.Data, 0o644); err != nil {
+			return fmt.Errorf("unable to write cover image: %w", err)
+		}
+		createdFiles = append(createdFiles, flags.Cover)
+	}
+
+	return combineFiles(flags, createdFiles)
+}
+
+// chapterMetadata writes an ffmetadata file with the book's tags and a
+// chapter for each mark, timed in milliseconds. The disclosure is stored as
+// the description, since MP4 has no free form tags ffmpeg writes.
+func chapterMetadata(tags audioTags, marks []chapterMark) string {
+	var metadata strings.Builder
+	metadata.WriteString(";FFMETADATA1\n")
+	for _, field := range []struct{ key, value string }{
+		{"title", tags.Title},
+		{"album", tags.Album},
+		{"artist", tags.Artist},
+		{"album_artist", tags.Artist},
+		{"comment", tags.Comment},
+		{"description", tags.Disclosure},
+		{"genre", "Audiobook"},
+	} {
+		if field.value != "" {
+			fmt.Fprintf(&metadata, "%s=%s\n", field.key, escapeMetadata(field.value))
+		}
+	}
+	for _, mark := range marks {
+		fmt.Fprintf(&metadata, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			mark.Start.Milliseconds(), mark.End.Milliseconds(), escapeMetadata(mark.Title))
+	}
+	return metadata.String()
+}
+
+func escapeMetadata(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(value)
+}
+
+// m4bArgs builds the ffmpeg command that transcodes the concatenated chapter
+// files to AAC, since MP3 and other formats cannot be copied into an M4B, and
+// adds the chapters and tags from metadataFile and the cover.
+func m4bArgs(textFile, metadataFile, coverFile, output string) []string {
+	args := []string{"-y", "-f", "concat", "-safe", "0", "-i", textFile, "-i", metadataFile}
+	if coverFile != "" {
+		args = append(args, "-i", coverFile)
+	}
+	args = append(args, "-map", "0:a", "-map_metadata", "1", "-map_chapters", "1")
+	if coverFile != "" {
+		args = append(args, "-map", "2:v", "-c:v", "copy", "-disposition:v:0", "attached_pic")
+	}
+	return append(args, "-c:a", "aac", "-b:a", m4bBitrate, "-movflags", "+faststart", "-f", "mp4", output)
+}
+
+// mediaDuration measures audio the native parsers cannot by decoding it with
+// ffmpeg and counting the samples.
+func mediaDuration(path, format string) (time.Duration, error) {
+	if duration, err := audioDuration(path, format); err == nil {
+		return duration, nil
+	}
+	if !isCommandAvailable("ffmpeg") {
+		return 0, errFFmpegRequired
+	}
+
+	cmd := exec.Command("ffmpeg", "-i", path, "-f", "s16le", "-ac", "1", "-ar", strconv.Itoa(pcmSampleRate), "-")
+	var counter countingWriter
+	var stderr bytes.Buffer
+	cmd.Stdout = &counter
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("unable to decode audio: %w, stdErr: %s", err, stderr.String())
+	}
+	return bytesToDuration(counter.n, pcmSampleRate*pcmBytes), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.n += int64(len(b))
+	return len(b), nil
+}
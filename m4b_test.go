@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChapterMetadata(t *testing.T) {
+	tags := audioTags{
+		Title:      "Tales; Volume=1",
+		Album:      "Tales; Volume=1",
+		Artist:     "Ann Author",
+		Disclosure: "Synthetic speech generated with OpenAI tts-1, voice nova",
+	}
+	marks := []chapterMark{
+		{Title: "Opening", Start: 0, End: 1500 * time.Millisecond},
+		{Title: "#2 The Return", Start: 1500 * time.Millisecond, End: 4 * time.Second},
+	}
+	expected := `;FFMETADATA1
+title=Tales\; Volume\=1
+album=Tales\; Volume\=1
+artist=Ann Author
+album_artist=Ann Author
+description=Synthetic speech generated with OpenAI tts-1, voice nova
+genre=Audiobook
+
+[CHAPTER]
+TIMEBASE=1/1000
+START=0
+END=1500
+title=Opening
+
+[CHAPTER]
+TIMEBASE=1/1000
+START=1500
+END=4000
+title=\#2 The Return
+`
+	if metadata := chapterMetadata(tags, marks); metadata != expected {
+		t.Errorf("Expected metadata:\n%s\ngot:\n%s", expected, metadata)
+	}
+}
+
+func TestM4BArgs(t *testing.T) {
+	args := m4bArgs("book.txt", "book.ffmetadata", "book.cover.jpg", "book.m4b")
+	expected := []string{
+		"-y", "-f", "concat", "-safe", "0", "-i", "book.txt", "-i", "book.ffmetadata", "-i", "book.cover.jpg",
+		"-map", "0:a", "-map_metadata", "1", "-map_chapters", "1",
+		"-map", "2:v", "-c:v", "copy", "-disposition:v:0", "attached_pic",
+		"-c:a", "aac", "-b:a", m4bBitrate, "-movflags", "+faststart", "-f", "mp4", "book.m4b",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %v, got %v", expected, args)
+	}
+
+	args = m4bArgs("book.txt", "book.ffmetadata", "", "book.m4b")
+	if strings.Contains(strings.Join(args, " "), "attached_pic") {
+		t.Errorf("Expected no cover stream without a cover, got %v", args)
+	}
+}
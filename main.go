@@ -3,77 +3,144 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
-	"unicode"
 	"unicode/utf8"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
 )
 
 const (
 	config_file    = "tts.config"
 	config_dir     = ".cli-tools"
-	default_voice  = "nova"
-	default_model  = "tts-1-hd"
-	default_format = "mp3"
-	default_speed  = "1.0"
+	default_voice  = synth.DefaultVoice
+	default_model  = synth.DefaultModel
+	default_format = synth.DefaultFormat
+	default_speed  = synth.DefaultSpeed
 	version        = "v1.3.9"
 	tool           = "tts"
-	api_max_chars  = 4096
-	api_url        = "https://api.openai.com/v1/audio/speech"
+	api_max_chars  = synth.MaxChars
+	api_url        = synth.DefaultURL
 )
 
-type TTSRequest struct {
-	Model  string `json:"model"`
-	Input  string `json:"input"`
-	Voice  string `json:"voice"`
-	Format string `json:"response_format"`
-	Speed  string `json:"speed"`
+type TTSRequest = synth.Request
+
+var audioMIMETypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	"pcm":  "audio/pcm",
+}
+
+var errFFmpegRequired = errors.New("ffmpeg is required for combining files. Please install ffmpeg and try again")
+
+var subcommands = map[string]func(args []string) error{
+	"serve":   runServe,
+	"watch":   runWatch,
+	"lexicon": runLexicon,
+	"info":    runInfo,
+	"feed":    runFeed,
 }
 
 type Config struct {
 	OpenAIAPIKey string
-	rateLimiter  <-chan time.Time
+	rateLimiter  synth.RateLimiter
 	configPath   string
+	progress     *progress
+	report       *runReport
+	captions     *captionTrack
+	httpClient   HTTPClient
 }
 
 type Flags struct {
-	InputFile      string
-	OutputFile     string
-	VoiceOption    string
-	ModelOption    string
-	FormatOption   string
-	SpeedOption    string
-	ConfigureMode  bool
-	HelpFlag       bool
-	VersionFlag    bool
-	BufferTextFlag bool
-	RateLimit      int
-	CombineFiles   bool
+	InputFile       string
+	OutputFile      string
+	VoiceOption     string
+	ModelOption     string
+	FormatOption    string
+	SpeedOption     string
+	ConfigureMode   bool
+	HelpFlag        bool
+	VersionFlag     bool
+	BufferTextFlag  bool
+	RateLimit       int
+	CombineFiles    bool
+	ReportFile      string
+	JSONReport      bool
+	Quiet           bool
+	Verbose         bool
+	Debug           bool
+	LogFormat       string
+	Retries         int
+	Incremental     bool
+	Script          bool
+	Speakers        []string
+	Markup          bool
+	Gap             time.Duration
+	ParagraphGap    time.Duration
+	Crossfade       time.Duration
+	MaxPartDuration time.Duration
+	BufferStart     string
+	BufferEnd       string
+	KeepBuffer      bool
+	LexiconFile     string
+	NoLexicon       bool
+	Normalize       string
+	Locale          string
+	Cover           string
+	Title           string
+	Artist          string
+	Album           string
+	Track           string
+	Comment         string
+	Disclose        bool
+	Captions        string
+	SplitBy         int
+	NameTemplate    string
+	Playlist        string
+	HTMLPlayer      bool
 }
 
-type HTTPClient interface {
-	Do(req *http.Request) (*http.Response, error)
-}
+type HTTPClient = synth.HTTPClient
 
 func main() {
 	if err := run(); err != nil {
-		log.Fatalf("Error: %v", err)
+		slog.Error(err.Error())
+		os.Exit(1)
 	}
 }
 
-func run() error {
+func run() (err error) {
+	if len(os.Args) > 1 {
+		if command, ok := subcommands[os.Args[1]]; ok {
+			return command(os.Args[2:])
+		}
+	}
+
 	flags := parseFlags()
 	var config Config
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if err := setupLogger(flags, os.Stderr); err != nil {
+		return err
+	}
+
 	if err := config.configure(flags.RateLimit); err != nil {
 		return fmt.Errorf("unable to configure: %w", err)
 	}
@@ -86,145 +153,185 @@ func run() error {
 		return nil
 	}
 
+	if err := validateCombineFlags(flags); err != nil {
+		return err
+	}
+
 	if err := checkPrerequisites(flags); err != nil {
 		return err
 	}
 
-	chunks, err := readInputFile(flags.InputFile, flags.BufferTextFlag)
+	b, err := readBook(flags)
 	if err != nil {
 		return err
 	}
+	parts := b.Parts
 
-	multiFile := len(chunks) > 1
+	// An incremental rebuild that reuses chunks only pays for the changed ones,
+	// so it goes ahead without asking.
+	numFiles, reused := partSegments(parts), 0
+	if flags.Incremental {
+		numFiles, reused = pendingChunks(parts, flags)
+		slog.Debug("Incremental chunks", "pending", numFiles, "reused", reused)
+	}
 
-	if multiFile {
-		proceed, err := promptForConfirmation(len(chunks))
+	if numFiles > 1 && reused == 0 {
+		proceed, err := promptForConfirmation(numFiles)
 		if err != nil {
 			return err
 		}
 		if !proceed {
-			log.Printf("Operation cancelled.")
+			slog.Info("Operation cancelled.")
 			return nil
 		}
 	}
 
-	var createdFiles []string
-
-	if err := processChunks(chunks, flags, config, &createdFiles); err != nil {
-		return err
-	}
-
-	if multiFile && flags.CombineFiles {
-		if err := combineFiles(flags, createdFiles); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func tts(ttsRequest TTSRequest, output io.Writer, client HTTPClient, config Config) error {
-	requestBody, err := json.Marshal(ttsRequest)
-	if err != nil {
-		return fmt.Errorf("unable to create request payload: %w", err)
+	if flags.ReportFile != "" || flags.JSONReport {
+		config.report = newRunReport(flags)
+		defer func() {
+			config.report.finish(err)
+			if reportErr := writeReport(config.report, flags); reportErr != nil && err == nil {
+				err = reportErr
+			}
+		}()
 	}
 
-	req, err := http.NewRequest("POST", api_url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return fmt.Errorf("unable to create HTTP request: %w", err)
+	// Parts bound into an M4B share one caption track, other parts get their
+	// own captions file.
+	captionParts := len(parts) > 1 && !isM4B(flags.OutputFile)
+	if flags.Captions != "" {
+		config.captions = &captionTrack{}
 	}
 
-	req.Header.Set("Authorization", "Bearer "+config.OpenAIAPIKey)
-	req.Header.Set("Content-Type", "application/json")
+	live := isTerminal(os.Stderr) && !flags.Quiet && flags.LogFormat != "json"
+	for i, part := range parts {
+		partFlags := partTags(flags, b, i+1)
+		partFlags.OutputFile = part.Output
+		if len(parts) > 1 {
+			slog.Info("Synthesizing chapter", "chapter", i+1, "of", len(parts), "title", part.Title, "output", part.Output)
+		}
+		if captionParts && config.captions != nil {
+			config.captions = &captionTrack{}
+		}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("unable to send request to OpenAI API: %w", err)
+		config.progress = newProgress(os.Stderr, segmentTexts(part.Segments), live)
+		config.progress.begin()
+		err = synthesizeFile(ctx, part.Segments, partFlags, config)
+		config.progress.finish()
+		if err != nil {
+			return err
+		}
+		if _, statErr := os.Stat(part.Output); len(parts) > 1 && statErr == nil {
+			config.report.addPart(part.Title, part.Output, flags.FormatOption)
+		}
+		if captionParts {
+			if err := config.captions.write(partOutput(flags.Captions, i+1, len(parts), part.Title)); err != nil {
+				return err
+			}
+		}
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
-
-	if resp.StatusCode != http.StatusOK {
-		responseBody, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("OpenAI API request failed with status code: %d, response body: %s", resp.StatusCode, responseBody)
+	if !captionParts {
+		if err := config.captions.write(flags.Captions); err != nil {
+			return err
+		}
 	}
 
-	_, err = io.Copy(output, resp.Body)
-	if err != nil {
-		return fmt.Errorf("unable to write to output: %w", err)
+	if isM4B(flags.OutputFile) {
+		if err := assembleM4B(flags, b); err != nil {
+			return err
+		}
+		config.report.setCombined(flags.OutputFile, "m4b")
+	} else if flags.SplitBy > 0 {
+		return writeSectionIndex(sectionIndex(flags.OutputFile), b)
 	}
-
-	log.Printf("Audio data processed successfully.\n")
 	return nil
 }
 
-func calculateChunkSize(bufferText bool) int {
-	chunkSize := api_max_chars
-	if bufferText {
-		startText := "Begin Text\n"
-		endText := "\nEnd Text"
-		startTextLen := utf8.RuneCountInString(startText)
-		endTextLen := utf8.RuneCountInString(endText)
-		chunkSize -= (startTextLen + endTextLen)
+func synthesizeFile(ctx context.Context, segments []segment, flags Flags, config Config) error {
+	if flags.Incremental {
+		return synthesizeIncremental(ctx, segments, flags, config)
 	}
-	return chunkSize
-}
 
-func splitIntoChunks(text string, chunkSize int) []string {
-	var chunks []string
-	inputRunes := []rune(text)
+	var createdFiles []string
 
-	for len(inputRunes) > 0 {
-		if len(inputRunes) <= chunkSize {
-			chunks = append(chunks, string(inputRunes))
-			break
-		}
+	if err := processChunks(ctx, segments, flags, config, &createdFiles); err != nil {
+		return err
+	}
 
-		splitIndex := chunkSize
-		for splitIndex > 0 && !unicode.IsSpace(inputRunes[splitIndex]) {
-			splitIndex--
+	if len(segments) > 1 && flags.CombineFiles {
+		tags := newAudioTags(flags, segments)
+		textFileName := fmt.Sprintf("%s.txt", strings.TrimSuffix(flags.OutputFile, filepath.Ext(flags.OutputFile)))
+		if files, err := readConcatList(textFileName); err == nil {
+			split, err := splitChunks(segments, files, flags, config)
+			if err != nil {
+				return err
+			}
+			if split {
+				if err := cleanupFiles(createdFiles); err != nil {
+					slog.Warn("Cleanup completed with errors", "error", err)
+				}
+				return nil
+			}
+			durations := measureChunks(files, flags, config)
+			tags.Chapters = chunkChapters(segments, durations, flags)
+			config.captions.add(segments, durations, flags)
 		}
-		if splitIndex == 0 {
-			splitIndex = chunkSize // If no space found, force split
+		if err := combineFiles(flags, createdFiles); err != nil {
+			return err
 		}
-
-		chunks = append(chunks, string(inputRunes[:splitIndex]))
-		inputRunes = inputRunes[splitIndex:]
+		tagAudio(flags.OutputFile, flags.FormatOption, tags)
+		config.report.setCombined(flags.OutputFile, flags.FormatOption)
+	} else if len(segments) == 1 {
+		config.captions.add(segments, measureChunks([]string{flags.OutputFile}, flags, config), flags)
+	} else {
+		return writePlaylists(segments, flags)
 	}
 
-	return chunks
+	return nil
+}
+
+func newSynthesizer(flags Flags, config Config, client HTTPClient) *synth.Synthesizer {
+	return synth.New(
+		synth.WithProvider(synth.OpenAI{APIKey: config.OpenAIAPIKey}),
+		synth.WithHTTPClient(client),
+		synth.WithRateLimiter(config.rateLimiter),
+		synth.WithRetries(flags.Retries),
+	)
 }
 
-func addBufferText(chunks []string) []string {
-	startText := "Begin Text\n"
-	endText := "\nEnd Text"
-	for i, chunk := range chunks {
-		chunks[i] = startText + chunk + endText
+func synthesizerFor(flags Flags, config Config) *synth.Synthesizer {
+	var httpClient HTTPClient = &http.Client{Timeout: 90 * time.Second}
+	if config.httpClient != nil {
+		httpClient = config.httpClient
 	}
-	return chunks
+	return newSynthesizer(flags, config, newHTTPClient(httpClient, flags))
 }
 
-func processChunk(ttsRequest TTSRequest, outputFileName string, client HTTPClient, config Config) error {
+func processChunk(ctx context.Context, ttsRequest TTSRequest, outputFileName string, synthesizer *synth.Synthesizer, config Config) (synth.Result, error) {
 	outputFileData, err := os.Create(outputFileName)
 	if err != nil {
-		return fmt.Errorf("unable to create output file: %w", err)
+		return synth.Result{}, fmt.Errorf("unable to create output file: %w", err)
 	}
 	defer func() {
 		_ = outputFileData.Close()
 	}()
 
-	err = tts(ttsRequest, outputFileData, client, config)
+	var output io.Writer = outputFileData
+	if config.progress != nil {
+		output = io.MultiWriter(outputFileData, config.progress)
+	}
+
+	response, err := synthesizer.SynthesizeRequest(ctx, ttsRequest, output)
 	if err != nil {
-		return fmt.Errorf("unable to process audio data: %w", err)
+		return response, fmt.Errorf("unable to process audio data: %w", err)
 	}
 
-	return nil
+	return response, nil
 }
 
-func processChunks(chunks []string, flags Flags, config Config, createdFiles *[]string) error {
-	multiFile := len(chunks) > 1
-	httpClient := &http.Client{Timeout: 90 * time.Second}
+func processChunks(ctx context.Context, segments []segment, flags Flags, config Config, createdFiles *[]string) error {
+	multiFile := len(segments) > 1
+	synthesizer := synthesizerFor(flags, config)
 	var textFileName string
 
 	if flags.CombineFiles && multiFile {
@@ -232,10 +339,28 @@ func processChunks(chunks []string, flags Flags, config Config, createdFiles *[]
 		*createdFiles = append(*createdFiles, textFileName)
 	}
 
-	for i, chunk := range chunks {
+	silence := make(map[time.Duration]string)
+	slugs := chunkSlugs(segments)
+
+	for i, segment := range segments {
+		// Repeated pauses of the same length share one silence file.
+		if file, ok := silence[segment.Pause]; ok && segment.Pause > 0 && flags.CombineFiles {
+			config.progress.startChunk(i + 1)
+			if err := appendToTextFile(textFileName, file); err != nil {
+				return err
+			}
+			config.progress.finishChunk(0)
+			continue
+		}
+
 		outputFileName := flags.OutputFile
 		if multiFile {
-			outputFileName = fmt.Sprintf("%s_%d.%s", strings.TrimSuffix(flags.OutputFile, filepath.Ext(flags.OutputFile)), i+1, flags.FormatOption)
+			outputFileName = chunkFileName(flags, segments, i, slugs[i])
+			dirs, err := createChunkDir(filepath.Dir(outputFileName))
+			if err != nil {
+				return fmt.Errorf("unable to create chunk directory: %w", err)
+			}
+			*createdFiles = append(*createdFiles, dirs...)
 			*createdFiles = append(*createdFiles, outputFileName)
 
 			if flags.CombineFiles {
@@ -245,21 +370,31 @@ func processChunks(chunks []string, flags Flags, config Config, createdFiles *[]
 			}
 		}
 
-		ttsRequest := TTSRequest{
-			Model:  flags.ModelOption,
-			Voice:  flags.VoiceOption,
-			Format: flags.FormatOption,
-			Input:  chunk,
-			Speed:  flags.SpeedOption,
-		}
-
-		if flags.RateLimit > 0 {
-			<-config.rateLimiter
+		config.progress.startChunk(i + 1)
+		if segment.Pause > 0 {
+			if err := writeSilence(outputFileName, flags.FormatOption, segment.Pause); err != nil {
+				return err
+			}
+			silence[segment.Pause] = outputFileName
+			config.progress.finishChunk(0)
+			continue
 		}
 
-		if err := processChunk(ttsRequest, outputFileName, httpClient, config); err != nil {
+		ttsRequest := segment.request(flags)
+		response, err := processChunk(ctx, ttsRequest, outputFileName, synthesizer, config)
+		if err != nil {
 			return err
 		}
+		trimBufferWords(outputFileName, flags)
+		if !flags.CombineFiles || !multiFile {
+			tags := newAudioTags(flags, segments)
+			if multiFile {
+				tags.Track = fmt.Sprintf("%d/%d", i+1, len(segments))
+			}
+			tagAudio(outputFileName, flags.FormatOption, tags)
+		}
+		config.progress.finishChunk(utf8.RuneCountInString(segment.Text))
+		config.report.addChunk(i+1, ttsRequest, outputFileName, response)
 	}
 
 	return nil
@@ -289,7 +424,17 @@ func combineFiles(flags Flags, createdFiles []string) error {
 		return fmt.Errorf("unable to get absolute path for the output file: %w", err)
 	}
 
-	cmd := exec.Command("ffmpeg", "-f", "concat", "-safe", "0", "-i", absTextFile, "-c", "copy", flags.OutputFile)
+	cmd := exec.Command("ffmpeg", "-y", "-f", "concat", "-safe", "0", "-i", absTextFile, "-c", "copy", flags.OutputFile)
+	switch {
+	case isM4B(flags.OutputFile):
+		cmd = exec.Command("ffmpeg", m4bArgs(absTextFile, m4bMetadataFile(flags.OutputFile), flags.Cover, flags.OutputFile)...)
+	case flags.Crossfade > 0:
+		files, err := readConcatList(absTextFile)
+		if err != nil {
+			return err
+		}
+		cmd = exec.Command("ffmpeg", crossfadeArgs(files, flags.Crossfade, flags.FormatOption, flags.OutputFile)...)
+	}
 
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
@@ -300,7 +445,7 @@ func combineFiles(flags Flags, createdFiles []string) error {
 	}
 
 	if err := cleanupFiles(createdFiles); err != nil {
-		log.Printf("Cleanup completed with errors:\n%v", err)
+		slog.Warn("Cleanup completed with errors", "error", err)
 	}
 	return nil
 }
@@ -308,27 +453,87 @@ func combineFiles(flags Flags, createdFiles []string) error {
 func parseFlags() Flags {
 	flags := Flags{}
 
-	flag.StringVar(&flags.InputFile, "f", "", "Input Markdown file")
+	flag.StringVar(&flags.InputFile, "f", "", "Input Markdown or EPUB file")
 	flag.StringVar(&flags.OutputFile, "o", "", "Output audio file")
-	flag.StringVar(&flags.VoiceOption, "v", default_voice, "Voice Selection")
-	flag.StringVar(&flags.ModelOption, "m", default_model, "Model Selection")
-	flag.StringVar(&flags.FormatOption, "fmt", default_format, "Select output format")
-	flag.StringVar(&flags.SpeedOption, "s", default_speed, "Set audio speed")
 	flag.BoolVar(&flags.ConfigureMode, "configure", false, "Enter Configuration Mode")
 	flag.BoolVar(&flags.HelpFlag, "help", false, "Displays Help Menu")
 	flag.BoolVar(&flags.VersionFlag, "version", false, "Displays version information")
-	flag.BoolVar(&flags.BufferTextFlag, "b", false, "Places buffer words at start and end of text to help with abrupt starts and ends")
-	flag.IntVar(&flags.RateLimit, "r", 0, "Rate limit for API calls per minute")
+	registerSynthesisFlags(flag.CommandLine, &flags)
 	flag.BoolVar(&flags.CombineFiles, "c", false, "Combine multiple files into a single audio file")
+	flag.BoolVar(&flags.Incremental, "incremental", false, "Only resynthesize chunks that changed since the last build")
+	flag.StringVar(&flags.ReportFile, "report", "", "Write a JSON run report to the given file")
+	flag.BoolVar(&flags.JSONReport, "json", false, "Write a JSON run report to stdout")
+	flag.BoolVar(&flags.Script, "script", false, "Treat the input file as a dialogue script")
+	flag.Func("speaker", "Speaker settings as NAME=VOICE[,speed=S][,instructions=TEXT]", func(value string) error {
+		flags.Speakers = append(flags.Speakers, value)
+		return nil
+	})
+	flag.BoolVar(&flags.Markup, "markup", false, "Apply inline markup tags instead of reading them as text")
+	flag.StringVar(&flags.LexiconFile, "lexicon", "", "Additional lexicon file with pronunciation rules")
+	flag.BoolVar(&flags.NoLexicon, "no-lexicon", false, "Do not apply pronunciation rules")
+	registerNormalizeFlags(flag.CommandLine, &flags)
+	flag.StringVar(&flags.Cover, "cover", "", "Cover image embedded in M4B output")
+	registerTagFlags(flag.CommandLine, &flags)
+	flag.Func("captions", "Write WebVTT (.vtt) or SubRip (.srt) captions aligned to the audio", func(value string) error {
+		if !isCaptionFile(value) {
+			return fmt.Errorf("captions must be a .vtt or .srt file")
+		}
+		flags.Captions = value
+		return nil
+	})
+	flag.Func("split-by", "Write each Markdown section under an h1 or h2 heading to its own file", func(value string) error {
+		switch value {
+		case "h1":
+			flags.SplitBy = 1
+		case "h2":
+			flags.SplitBy = 2
+		default:
+			return fmt.Errorf("split-by must be h1 or h2")
+		}
+		return nil
+	})
+	flag.Func("name-template", "Name chunk files from {base}, {index}, {total}, {slug}, {voice} and {format}", func(value string) error {
+		if err := validateNameTemplate(value); err != nil {
+			return err
+		}
+		flags.NameTemplate = value
+		return nil
+	})
+	registerPlaylistFlags(flag.CommandLine, &flags)
+	registerCombineFlags(flag.CommandLine, &flags)
+	registerServiceFlags(flag.CommandLine, &flags)
 
 	flag.Parse()
+	if flags.Script || isEPUB(flags.InputFile) || isM4B(flags.OutputFile) || flags.Captions != "" || flags.MaxPartDuration > 0 || flags.SplitBy > 0 {
+		flags.CombineFiles = true
+	}
 	return flags
 }
 
+func registerSynthesisFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.StringVar(&flags.VoiceOption, "v", default_voice, "Voice Selection")
+	fs.StringVar(&flags.ModelOption, "m", default_model, "Model Selection")
+	fs.StringVar(&flags.FormatOption, "fmt", default_format, "Select output format")
+	fs.StringVar(&flags.SpeedOption, "s", default_speed, "Set audio speed")
+	fs.BoolVar(&flags.BufferTextFlag, "b", false, "Places buffer words at start and end of text to help with abrupt starts and ends")
+	fs.StringVar(&flags.BufferStart, "buffer-start", defaultBufferStart, "Buffer phrase spoken before each chunk")
+	fs.StringVar(&flags.BufferEnd, "buffer-end", defaultBufferEnd, "Buffer phrase spoken after each chunk")
+	fs.BoolVar(&flags.KeepBuffer, "keep-buffer", false, "Keep the spoken buffer phrases in the audio")
+}
+
+func registerServiceFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.IntVar(&flags.RateLimit, "r", 0, "Rate limit for API calls per minute")
+	fs.IntVar(&flags.Retries, "retries", 2, "Retries for failed API calls")
+	fs.BoolVar(&flags.Quiet, "quiet", false, "Only log warnings and errors")
+	fs.BoolVar(&flags.Verbose, "verbose", false, "Log detailed progress information")
+	fs.BoolVar(&flags.Debug, "debug", false, "Log HTTP request and response headers with credentials redacted")
+	fs.StringVar(&flags.LogFormat, "log-format", "text", "Log format: text or json")
+}
+
 func handleFlags(flags Flags, config *Config) (bool, error) {
 	switch {
 	case flags.HelpFlag:
-		log.Print(printHelp())
+		fmt.Print(printHelp())
 		return true, nil
 	case flags.ConfigureMode:
 		err := config.writeNewConfig()
@@ -337,7 +542,7 @@ func handleFlags(flags Flags, config *Config) (bool, error) {
 		}
 		return true, nil
 	case flags.VersionFlag:
-		log.Print(printVersion(tool, version))
+		fmt.Println(printVersion(tool, version))
 		return true, nil
 	default:
 		if flags.InputFile == "" || flags.OutputFile == "" {
@@ -365,10 +570,7 @@ func (c *Config) configure(ratelimit int) error {
 		}
 	}
 
-	if ratelimit > 0 {
-		ticker := time.NewTicker(time.Minute / time.Duration(ratelimit))
-		c.rateLimiter = ticker.C
-	}
+	c.rateLimiter = synth.PerMinute(ratelimit)
 
 	return nil
 }
@@ -406,7 +608,7 @@ func (c *Config) writeNewConfig() error {
 }
 
 func promptForAPIKey() (string, error) {
-	fmt.Print("Please enter your OpenAI API Key: ")
+	prompt("Please enter your OpenAI API Key: ")
 	var apiKey string
 	_, err := fmt.Scanln(&apiKey)
 	if err != nil {
@@ -452,24 +654,35 @@ func (c *Config) readConfig() error {
 	return nil
 }
 
-func readFileData(r io.Reader, bufferText bool) ([]string, error) {
-	inputContent, err := io.ReadAll(r)
-	if err != nil {
-		return nil, fmt.Errorf("error reading input data: %w", err)
+// newChunker splits text into chunks of at most chunkLimit runes. It leaves out
+// the buffer words, which are added once the chunks are rewritten.
+func newChunker(flags Flags) synth.Chunker {
+	if flags.Incremental {
+		return synth.ContentChunker{Size: chunkLimit(flags)}
 	}
+	return synth.SizeChunker{Size: chunkLimit(flags)}
+}
 
-	chunkSize := calculateChunkSize(bufferText)
-	chunks := splitIntoChunks(string(inputContent), chunkSize)
+// chunkLimit is the number of runes left for text in each request.
+func chunkLimit(flags Flags) int {
+	if flags.BufferTextFlag {
+		return synth.MaxChars - bufferFor(flags).Size()
+	}
+	return synth.MaxChars
+}
 
-	if bufferText {
-		chunks = addBufferText(chunks)
+func readFileData(r io.Reader, chunker synth.Chunker) ([]string, error) {
+	inputContent, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading input data: %w", err)
 	}
 
+	chunks := chunker.Chunk(string(inputContent))
 	return chunks, nil
 }
 
 func promptForConfirmation(numFiles int) (bool, error) {
-	log.Printf("This will create %d files. Are you sure you wish to continue? (y/n): ", numFiles)
+	prompt("This will create %d files. Are you sure you wish to continue? (y/n): ", numFiles)
 	var response string
 	_, err := fmt.Scanln(&response)
 	if err != nil {
@@ -483,13 +696,16 @@ func promptForConfirmation(numFiles int) (bool, error) {
 }
 
 func checkPrerequisites(flags Flags) error {
-	if flags.CombineFiles && !isCommandAvailable("ffmpeg") {
-		return fmt.Errorf("ffmpeg is required for combining files. Please install ffmpeg and try again")
+	if (flags.CombineFiles || flags.Incremental) && !isCommandAvailable("ffmpeg") {
+		return errFFmpegRequired
+	}
+	if isM4B(flags.OutputFile) && flags.FormatOption == "pcm" {
+		return fmt.Errorf("M4B output cannot be bound from raw PCM. Use another -fmt, such as mp3 or wav")
 	}
 	return nil
 }
 
-func readInputFile(inputFileName string, bufferText bool) ([]string, error) {
+func readInputFile(inputFileName string, chunker synth.Chunker) ([]string, error) {
 	inputFile, err := os.Open(inputFileName)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open input file: %w", err)
@@ -498,7 +714,7 @@ func readInputFile(inputFileName string, bufferText bool) ([]string, error) {
 		_ = inputFile.Close()
 	}()
 
-	chunks, err := readFileData(inputFile, bufferText)
+	chunks, err := readFileData(inputFile, chunker)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read input file data: %w", err)
 	}
@@ -510,10 +726,17 @@ var isCommandAvailable = func(name string) bool {
 	return err == nil
 }
 
+// cleanupFiles removes the files in reverse order, so the directories created
+// for them go once they are empty. A directory that still holds other files is
+// kept.
 func cleanupFiles(files []string) error {
 	var errs []string
-	for _, file := range files {
-		log.Printf("Deleting file: %s\n", file)
+	for _, file := range slices.Backward(files) {
+		if entries, err := os.ReadDir(file); err == nil && len(entries) > 0 {
+			slog.Debug("Keeping directory that is not empty", "dir", file)
+			continue
+		}
+		slog.Debug("Deleting file", "file", file)
 		err := os.Remove(file)
 		if err != nil {
 			errs = append(errs, fmt.Sprintf("error deleting file %s: %v", file, err))
@@ -527,12 +750,33 @@ func cleanupFiles(files []string) error {
 
 func printHelp() string {
 	return `Usage: tts [OPTIONS]
+       tts COMMAND [OPTIONS]
 
 Process text files with OpenAI's Text To Speech API.
 
 Options:
-  -f FILE       Input Markdown file
-  -o FILE       Output audio file
+  -f FILE       Input Markdown or EPUB file. An EPUB produces one file
+                per chapter, named OUTPUT_NN_TITLE (requires ffmpeg)
+  -o FILE       Output audio file. A .m4b file is an audiobook with a
+                chapter for each top level heading (requires ffmpeg)
+  --split-by h1|h2
+                Write each Markdown section to its own file named after its
+                heading, such as 03-installing.mp3, next to OUTPUT, with an
+                OUTPUT_index.md linking them. With .m4b output, the heading
+                level of the chapters
+  --cover FILE  JPEG or PNG cover art for .m4b output (default: the
+                front matter cover or the EPUB cover)
+  --title TEXT, --artist TEXT, --album TEXT, --comment TEXT
+                Tags written into the output (default: the front matter
+                title, author, album and comment)
+  --track N[/TOTAL]
+                Track number tag. Chunk and chapter files are numbered
+                automatically
+  --disclose    Tag the output as synthetic speech, recording the model
+                and voices used
+  --captions FILE
+                Write WebVTT (.vtt) or SubRip (.srt) captions timed to the
+                audio. Combines chunks as if -c were given
   -v VOICE      Voice selection (default: nova)
                 Options: alloy, echo, fable, onyx, nova, shimmer
   -m MODEL      Model selection (default: tts-1-hd)
@@ -542,11 +786,88 @@ Options:
   -s SPEED      Set audio speed (default: 1.0)
                 Range: 0.25 to 4.0
   -b            Place buffer words at start and end of text
+  --buffer-start PHRASE, --buffer-end PHRASE
+                Buffer phrases used with -b (default: Begin Text, End Text)
+  --keep-buffer Keep the spoken buffer phrases instead of trimming them
+                from the audio. Trimming re-encodes formats other than
+                WAV and PCM
+  --incremental Keep chunks between runs and only resynthesize the ones
+                whose text changed, then recombine (requires ffmpeg)
+  --name-template TEMPLATE
+                Name chunk files from {base}, {index}, {total}, {slug},
+                {voice} and {format} (default: {base}_{index}.{format})
+  --playlist FORMAT
+                Playlist written next to chunk files that are not combined
+                (default: m3u8)
+                Options: m3u8, pls, none
+  --html        Write an HTML player, such as out.html, with a chapter list
+                and the text of each chunk next to chunk files that are not
+                combined
+  --script      Read the input as a dialogue script of "NAME: text" lines,
+                or YAML when the file ends in .yaml or .yml. Each turn is
+                synthesized with its speaker's voice and combined in order
+                (requires ffmpeg)
+  --speaker NAME=VOICE[,speed=S][,instructions=TEXT]
+                Voice, speed and instructions for a script speaker. Can be
+                repeated. Speakers without a voice get an unused one
+  --markup      Apply [pause], [voice], [speed] and [skip] tags instead
+                of reading them as text
+  --lexicon FILE
+                Pronunciation rules applied before the project .tts-lexicon
+                and the global ~/.cli-tools/tts.lexicon
+  --no-lexicon  Do not apply pronunciation rules
+  --normalize LIST
+                Spell out numbers, dates, times, currency, versions, units
+                and abbreviations before synthesis (default: none)
+                Options: none, prose, all, or a comma separated list of
+                abbreviations, dates, times, currency, versions, units,
+                ordinals, numbers
+  --locale LOCALE
+                Locale used by --normalize (default: en-US)
+                Options: en-US, en-GB
+  --gap DUR     Silence between chunks when combining, such as 600ms
+  --paragraph-gap DUR
+                Silence between paragraphs and speakers when combining
+                (default: --gap)
+  --crossfade DUR
+                Crossfade between parts when combining, such as 30ms.
+                Re-encodes the combined file
+  --max-part-duration DUR
+                Split combined output into parts no longer than DUR,
+                such as 30m, named OUTPUT_part01 and so on
   -r RATE       Rate limit for API calls per minute (default: unlimited)
+  --retries N   Retries for failed API calls (default: 2)
+  --report FILE Write a JSON run report to FILE
+  --json        Write a JSON run report to stdout
+  --quiet       Only log warnings and errors
+  --verbose     Log detailed progress information
+  --debug       Log HTTP headers (credentials redacted) and request sizes
+  --log-format FORMAT
+                Log format (default: text)
+                Options: text, json
   --configure   Enter configuration mode for API key setup
   --help        Display this help and exit
   --version     Output version information and exit
 
+Commands:
+  serve         Run an HTTP server exposing synthesis as a REST API
+                (tts serve --listen :8080)
+  watch         Convert files dropped into a folder automatically
+                (tts watch IN_DIR OUT_DIR)
+  lexicon test  Print text as rewritten by the pronunciation rules
+                and --normalize
+                (tts lexicon test [-v VOICE] [-m MODEL] [--normalize LIST] "text")
+  info          Print the format, duration, sample rate and channels of
+                audio files (tts info [--fmt FORMAT] [--json] FILE...)
+  feed          Write an RSS podcast feed of the audio files in a
+                directory (tts feed --dir DIR --base-url URL > feed.xml)
+
+Markup (with --markup):
+  [pause 800ms]                Insert generated silence (requires -c)
+  [voice onyx]...[/voice]      Read the enclosed text with another voice
+  [speed 0.9]...[/speed]       Read the enclosed text at another speed
+  [skip]...[/skip]             Leave the enclosed text out of the audio
+
 Example:
   tts -f input.md -o output.mp3
 `
@@ -3,80 +3,23 @@
 package main
 
 import (
-	"bytes"
-	"errors"
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strings"
 	"testing"
-	"unicode/utf8"
-)
-
-func TestCalculateChunkSize(t *testing.T) {
-	chunkSize := calculateChunkSize(false)
-	expectedSize := api_max_chars
-	if chunkSize != expectedSize {
-		t.Errorf("Expected chunk size %d, got %d", expectedSize, chunkSize)
-	}
-	chunkSizeWithBuffer := calculateChunkSize(true)
-	startText := "Begin Text\n"
-	endText := "\nEnd Text"
-	startTextLen := utf8.RuneCountInString(startText)
-	endTextLen := utf8.RuneCountInString(endText)
-	expectedSizeWithBuffer := api_max_chars - (startTextLen + endTextLen)
-	if chunkSizeWithBuffer != expectedSizeWithBuffer {
-		t.Errorf("Expected chunk size with buffer %d, got %d", expectedSizeWithBuffer, chunkSizeWithBuffer)
-	}
-}
-
-func TestSplitIntoChunks(t *testing.T) {
-	text := "This is a test. "
-	chunkSize := 10
-	chunks := splitIntoChunks(text, chunkSize)
-	expectedChunks := []string{"This is a", " test. "}
-	if !reflect.DeepEqual(chunks, expectedChunks) {
-		t.Errorf("Expected chunks %v, got %v", expectedChunks, chunks)
-	}
-
-	text = "Short text"
-	chunks = splitIntoChunks(text, chunkSize)
-	expectedChunks = []string{"Short text"}
-	if !reflect.DeepEqual(chunks, expectedChunks) {
-		t.Errorf("Expected chunks %v, got %v", expectedChunks, chunks)
-	}
-
-	text = "Thisisaverylongwordthathasnospacesandshouldbesplitatmaximumchunksize."
-	chunkSize = 20
-	expectedChunks = []string{
-		"Thisisaverylongwordt",
-		"hathasnospacesandsho",
-		"uldbesplitatmaximumc",
-		"hunksize.",
-	}
-	chunks = splitIntoChunks(text, chunkSize)
-	if !reflect.DeepEqual(chunks, expectedChunks) {
-		t.Errorf("Expected chunks %v, got %v", expectedChunks, chunks)
-	}
-}
 
-func TestAddBufferText(t *testing.T) {
-	chunks := []string{"Chunk 1", "Chunk 2"}
-	bufferedChunks := addBufferText(chunks)
-	expectedChunks := []string{"Begin Text\nChunk 1\nEnd Text", "Begin Text\nChunk 2\nEnd Text"}
-	if !reflect.DeepEqual(bufferedChunks, expectedChunks) {
-		t.Errorf("Expected buffered chunks %v, got %v", expectedChunks, bufferedChunks)
-	}
-}
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
 
 func TestReadFileData(t *testing.T) {
 	text := "This is a test text to read and split into chunks."
 	reader := strings.NewReader(text)
 	bufferText := false
-	chunks, err := readFileData(reader, bufferText)
+	chunks, err := readFileData(reader, synth.SizeChunker{BufferText: bufferText})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -88,7 +31,7 @@ func TestReadFileData(t *testing.T) {
 	}
 	bufferText = true
 	reader = strings.NewReader(text)
-	chunks, err = readFileData(reader, bufferText)
+	chunks, err = readFileData(reader, synth.SizeChunker{BufferText: bufferText})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -117,102 +60,6 @@ func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return m.DoFunc(req)
 }
 
-func TestTTS(t *testing.T) {
-	ttsRequest := TTSRequest{
-		Model:  "test-model",
-		Voice:  "test-voice",
-		Format: "mp3",
-		Input:  "Test input text",
-		Speed:  "1.0",
-	}
-	mockClient := &MockHTTPClient{
-		DoFunc: func(req *http.Request) (*http.Response, error) {
-			if req.Method != "POST" {
-				t.Errorf("Expected POST method, got %s", req.Method)
-			}
-			if req.URL.String() != api_url {
-				t.Errorf("Expected URL %s, got %s", api_url, req.URL.String())
-			}
-			response := &http.Response{
-				StatusCode: http.StatusOK,
-				Body:       io.NopCloser(strings.NewReader("Mock audio data")),
-			}
-			return response, nil
-		},
-	}
-	output := &bytes.Buffer{}
-	config := Config{
-		OpenAIAPIKey: "test-api-key",
-	}
-	err := tts(ttsRequest, output, mockClient, config)
-	if err != nil {
-		t.Errorf("Expected no error, got %v", err)
-	}
-	if output.String() != "Mock audio data" {
-		t.Errorf("Expected output 'Mock audio data', got '%s'", output.String())
-	}
-}
-
-func TestTTS_ErrorResponse(t *testing.T) {
-	ttsRequest := TTSRequest{
-		Model:  "test-model",
-		Voice:  "test-voice",
-		Format: "mp3",
-		Input:  "Test input text",
-		Speed:  "1.0",
-	}
-	mockClient := &MockHTTPClient{
-		DoFunc: func(req *http.Request) (*http.Response, error) {
-			response := &http.Response{
-				StatusCode: http.StatusBadRequest,
-				Body:       io.NopCloser(strings.NewReader("Bad request")),
-			}
-			return response, nil
-		},
-	}
-	output := &bytes.Buffer{}
-	config := Config{
-		OpenAIAPIKey: "test-api-key",
-	}
-	err := tts(ttsRequest, output, mockClient, config)
-	if err == nil {
-		t.Errorf("Expected error, got nil")
-	} else {
-		expectedError := "OpenAI API request failed with status code: 400, response body: Bad request"
-		if err.Error() != expectedError {
-			t.Errorf("Expected error '%s', got '%s'", expectedError, err.Error())
-		}
-	}
-}
-
-func TestTTS_RequestError(t *testing.T) {
-	ttsRequest := TTSRequest{
-		Model:  "test-model",
-		Voice:  "test-voice",
-		Format: "mp3",
-		Input:  "Test input text",
-		Speed:  "1.0",
-	}
-	mockClient := &MockHTTPClient{
-		DoFunc: func(req *http.Request) (*http.Response, error) {
-			return nil, errors.New("network error")
-		},
-	}
-	output := &bytes.Buffer{}
-	config := Config{
-		OpenAIAPIKey: "test-api-key",
-	}
-	err := tts(ttsRequest, output, mockClient, config)
-	if err == nil {
-		t.Errorf("Expected error, got nil")
-	} else {
-		expectedError := "unable to send request to OpenAI API: network error"
-		if err.Error() != expectedError {
-			t.Errorf("Expected error '%s', got '%s'", expectedError, err.Error())
-		}
-	}
-}
-
 func TestCleanupFiles(t *testing.T) {
 	file1 := "testfile1.tmp"
 	file2 := "testfile2.tmp"
@@ -295,7 +142,8 @@ func TestProcessChunk(t *testing.T) {
 	defer func() {
 		_ = os.Remove(outputFileName)
 	}()
-	err := processChunk(ttsRequest, outputFileName, mockClient, config)
+	synthesizer := newSynthesizer(Flags{}, config, mockClient)
+	_, err := processChunk(context.Background(), ttsRequest, outputFileName, synthesizer, config)
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -348,7 +196,7 @@ func TestReadInputFile(t *testing.T) {
 	defer func() {
 		_ = os.Remove(inputFileName)
 	}()
-	chunks, err := readInputFile(inputFileName, false)
+	chunks, err := readInputFile(inputFileName, synth.SizeChunker{})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -400,12 +248,33 @@ Part of my CLI Tools for Windows project.`, tool, version)
 
 func TestPrintHelp(t *testing.T) {
 	expectedHelp := `Usage: tts [OPTIONS]
+       tts COMMAND [OPTIONS]
 
 Process text files with OpenAI's Text To Speech API.
 
 Options:
-  -f FILE       Input Markdown file
-  -o FILE       Output audio file
+  -f FILE       Input Markdown or EPUB file. An EPUB produces one file
+                per chapter, named OUTPUT_NN_TITLE (requires ffmpeg)
+  -o FILE       Output audio file. A .m4b file is an audiobook with a
+                chapter for each top level heading (requires ffmpeg)
+  --split-by h1|h2
+                Write each Markdown section to its own file named after its
+                heading, such as 03-installing.mp3, next to OUTPUT, with an
+                OUTPUT_index.md linking them. With .m4b output, the heading
+                level of the chapters
+  --cover FILE  JPEG or PNG cover art for .m4b output (default: the
+                front matter cover or the EPUB cover)
+  --title TEXT, --artist TEXT, --album TEXT, --comment TEXT
+                Tags written into the output (default: the front matter
+                title, author, album and comment)
+  --track N[/TOTAL]
+                Track number tag. Chunk and chapter files are numbered
+                automatically
+  --disclose    Tag the output as synthetic speech, recording the model
+                and voices used
+  --captions FILE
+                Write WebVTT (.vtt) or SubRip (.srt) captions timed to the
+                audio. Combines chunks as if -c were given
   -v VOICE      Voice selection (default: nova)
                 Options: alloy, echo, fable, onyx, nova, shimmer
   -m MODEL      Model selection (default: tts-1-hd)
@@ -415,11 +284,88 @@ Options:
   -s SPEED      Set audio speed (default: 1.0)
                 Range: 0.25 to 4.0
   -b            Place buffer words at start and end of text
+  --buffer-start PHRASE, --buffer-end PHRASE
+                Buffer phrases used with -b (default: Begin Text, End Text)
+  --keep-buffer Keep the spoken buffer phrases instead of trimming them
+                from the audio. Trimming re-encodes formats other than
+                WAV and PCM
+  --incremental Keep chunks between runs and only resynthesize the ones
+                whose text changed, then recombine (requires ffmpeg)
+  --name-template TEMPLATE
+                Name chunk files from {base}, {index}, {total}, {slug},
+                {voice} and {format} (default: {base}_{index}.{format})
+  --playlist FORMAT
+                Playlist written next to chunk files that are not combined
+                (default: m3u8)
+                Options: m3u8, pls, none
+  --html        Write an HTML player, such as out.html, with a chapter list
+                and the text of each chunk next to chunk files that are not
+                combined
+  --script      Read the input as a dialogue script of "NAME: text" lines,
+                or YAML when the file ends in .yaml or .yml. Each turn is
+                synthesized with its speaker's voice and combined in order
+                (requires ffmpeg)
+  --speaker NAME=VOICE[,speed=S][,instructions=TEXT]
+                Voice, speed and instructions for a script speaker. Can be
+                repeated. Speakers without a voice get an unused one
+  --markup      Apply [pause], [voice], [speed] and [skip] tags instead
+                of reading them as text
+  --lexicon FILE
+                Pronunciation rules applied before the project .tts-lexicon
+                and the global ~/.cli-tools/tts.lexicon
+  --no-lexicon  Do not apply pronunciation rules
+  --normalize LIST
+                Spell out numbers, dates, times, currency, versions, units
+                and abbreviations before synthesis (default: none)
+                Options: none, prose, all, or a comma separated list of
+                abbreviations, dates, times, currency, versions, units,
+                ordinals, numbers
+  --locale LOCALE
+                Locale used by --normalize (default: en-US)
+                Options: en-US, en-GB
+  --gap DUR     Silence between chunks when combining, such as 600ms
+  --paragraph-gap DUR
+                Silence between paragraphs and speakers when combining
+                (default: --gap)
+  --crossfade DUR
+                Crossfade between parts when combining, such as 30ms.
+                Re-encodes the combined file
+  --max-part-duration DUR
+                Split combined output into parts no longer than DUR,
+                such as 30m, named OUTPUT_part01 and so on
   -r RATE       Rate limit for API calls per minute (default: unlimited)
+  --retries N   Retries for failed API calls (default: 2)
+  --report FILE Write a JSON run report to FILE
+  --json        Write a JSON run report to stdout
+  --quiet       Only log warnings and errors
+  --verbose     Log detailed progress information
+  --debug       Log HTTP headers (credentials redacted) and request sizes
+  --log-format FORMAT
+                Log format (default: text)
+                Options: text, json
   --configure   Enter configuration mode for API key setup
   --help        Display this help and exit
   --version     Output version information and exit
 
+Commands:
+  serve         Run an HTTP server exposing synthesis as a REST API
+                (tts serve --listen :8080)
+  watch         Convert files dropped into a folder automatically
+                (tts watch IN_DIR OUT_DIR)
+  lexicon test  Print text as rewritten by the pronunciation rules
+                and --normalize
+                (tts lexicon test [-v VOICE] [-m MODEL] [--normalize LIST] "text")
+  info          Print the format, duration, sample rate and channels of
+                audio files (tts info [--fmt FORMAT] [--json] FILE...)
+  feed          Write an RSS podcast feed of the audio files in a
+                directory (tts feed --dir DIR --base-url URL > feed.xml)
+
+Markup (with --markup):
+  [pause 800ms]                Insert generated silence (requires -c)
+  [voice onyx]...[/voice]      Read the enclosed text with another voice
+  [speed 0.9]...[/speed]       Read the enclosed text at another speed
+  [skip]...[/skip]             Leave the enclosed text out of the audio
+
 Example:
   tts -f input.md -o output.mp3
 `
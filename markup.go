@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// markupTag matches [pause DUR], [voice NAME]...[/voice], [speed X]...[/speed]
+// and [skip]...[/skip]. Other bracketed text, such as Markdown links, is left
+// alone.
+var markupTag = regexp.MustCompile(`\[(/?)(pause|voice|speed|skip)(?:\s+([^\[\]]*))?\]`)
+
+// looseTag matches bracketed text that starts like a tag but that markupTag
+// does not accept, such as [Pause 1s] or [speed0.9].
+var looseTag = regexp.MustCompile(`(?i)\[\s*/?\s*(?:pause|voice|speed|skip)(?:\b|[\d.])[^\]]*\]`)
+
+const maxPause = time.Minute
+
+type markupState struct {
+	voices []string
+	speeds []string
+	skip   int
+}
+
+// markupSegments splits text on inline markup and chunks each stretch of text
+// with chunk. Voice and speed tags override base, and pauses become silent
+// segments.
+func markupSegments(text string, base segment, chunk chunkFunc) ([]segment, error) {
+	var segments []segment
+	var state markupState
+
+	emit := func(text string) {
+		if state.skip > 0 || strings.TrimSpace(text) == "" {
+			return
+		}
+		current := base
+		if len(state.voices) > 0 {
+			current.Voice = state.voices[len(state.voices)-1]
+		}
+		if len(state.speeds) > 0 {
+			current.Speed = state.speeds[len(state.speeds)-1]
+		}
+		segments = append(segments, chunk(text, current)...)
+	}
+
+	position := 0
+	for _, match := range markupTag.FindAllStringSubmatchIndex(text, -1) {
+		warnLooseTags(text, position, match[0])
+		emit(text[position:match[0]])
+		position = match[1]
+
+		closing := match[3] > match[2]
+		name := text[match[4]:match[5]]
+		var argument string
+		if match[6] >= 0 {
+			argument = strings.TrimSpace(text[match[6]:match[7]])
+		}
+		tag := text[match[0]:match[1]]
+		line := strings.Count(text[:match[0]], "\n") + 1
+
+		if err := state.apply(name, argument, closing); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", line, tag, err)
+		}
+		if name == "pause" && state.skip == 0 {
+			pause, err := parsePause(argument)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s: %w", line, tag, err)
+			}
+			segments = append(segments, segment{Speaker: base.Speaker, Pause: pause})
+		}
+	}
+	warnLooseTags(text, position, len(text))
+	emit(text[position:])
+
+	return segments, nil
+}
+
+// warnLooseTags warns about text between start and end that looks like a tag
+// but is read as text.
+func warnLooseTags(text string, start, end int) {
+	for _, loc := range looseTag.FindAllStringIndex(text[start:end], -1) {
+		line := strings.Count(text[:start+loc[0]], "\n") + 1
+		slog.Warn("Unable to parse markup tag, reading it as text", "line", line, "tag", text[start+loc[0]:start+loc[1]])
+	}
+}
+
+func (s *markupState) apply(name, argument string, closing bool) error {
+	if closing {
+		if argument != "" {
+			return fmt.Errorf("closing tags take no argument")
+		}
+		var open *[]string
+		switch name {
+		case "voice":
+			open = &s.voices
+		case "speed":
+			open = &s.speeds
+		case "skip":
+			if s.skip == 0 {
+				return fmt.Errorf("no open [skip]")
+			}
+			s.skip--
+			return nil
+		case "pause":
+			return fmt.Errorf("[pause] has no closing tag")
+		}
+		if len(*open) == 0 {
+			return fmt.Errorf("no open [%s]", name)
+		}
+		*open = (*open)[:len(*open)-1]
+		return nil
+	}
+
+	switch name {
+	case "voice":
+		if argument == "" {
+			return fmt.Errorf("a voice name is required")
+		}
+		s.voices = append(s.voices, argument)
+	case "speed":
+		speed, err := strconv.ParseFloat(argument, 64)
+		if err != nil || speed < minSpeed || speed > maxSpeed {
+			return fmt.Errorf("speed must be between %.2f and %.1f", minSpeed, maxSpeed)
+		}
+		s.speeds = append(s.speeds, argument)
+	case "skip":
+		if argument != "" {
+			return fmt.Errorf("[skip] takes no argument")
+		}
+		s.skip++
+	}
+	return nil
+}
+
+// parsePause accepts Go durations such as 800ms or 1.5s. A bare number is
+// read as milliseconds.
+func parsePause(argument string) (time.Duration, error) {
+	if milliseconds, err := strconv.ParseFloat(argument, 64); err == nil {
+		argument = strconv.FormatFloat(milliseconds, 'f', -1, 64) + "ms"
+	}
+	pause, err := time.ParseDuration(argument)
+	if err != nil || pause <= 0 || pause > maxPause {
+		return 0, fmt.Errorf("pause must be a duration between 1ms and %s, such as 800ms", maxPause)
+	}
+	return pause, nil
+}
+
+// dropPauses removes silent segments, which only take effect when files are
+// combined.
+func dropPauses(segments []segment) []segment {
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment.Pause == 0 {
+			kept = append(kept, segment)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMarkupSegments(t *testing.T) {
+	text := "Hello. [pause 800ms]Meet [voice onyx]Bob, who [speed 1.5]talks fast[/speed].[/voice] [skip]Editor note.[/skip]The end. See [the docs](http://example.com)."
+	segments, err := markupSegments(text, segment{Voice: "nova"}, segmentChunker(Flags{}, nil, nil))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []segment{
+		{Text: "Hello. ", Voice: "nova"},
+		{Pause: 800 * time.Millisecond},
+		{Text: "Meet ", Voice: "nova"},
+		{Text: "Bob, who ", Voice: "onyx"},
+		{Text: "talks fast", Voice: "onyx", Speed: "1.5"},
+		{Text: ".", Voice: "onyx"},
+		{Text: "The end. See [the docs](http://example.com).", Voice: "nova"},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("Expected segments %+v, got %+v", expected, segments)
+	}
+}
+
+func TestMarkupSegmentsSkipsPausesInsideSkip(t *testing.T) {
+	segments, err := markupSegments("One [skip]two [pause 1s][/skip]three", segment{}, segmentChunker(Flags{}, nil, nil))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(segments) != 2 || segments[0].Text != "One " || segments[1].Text != "three" {
+		t.Errorf("Expected skipped text and pauses to be dropped, got %+v", segments)
+	}
+}
+
+func TestMarkupSegmentsErrors(t *testing.T) {
+	for _, text := range []string{
+		"[/voice]",
+		"[voice]text[/voice]",
+		"[speed 9]fast[/speed]",
+		"[pause]",
+		"[pause soon]",
+		"[pause 2m]",
+		"[skip]a[/skip][/skip]",
+	} {
+		if _, err := markupSegments(text, segment{}, segmentChunker(Flags{}, nil, nil)); err == nil {
+			t.Errorf("Expected an error for %q", text)
+		}
+	}
+}
+
+func TestMarkupSegmentsWarnsAboutLooseTags(t *testing.T) {
+	original := slog.Default()
+	defer slog.SetDefault(original)
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	text := "One [Pause 1s]two\n[speed0.9]three [pause 1s]four"
+	segments, err := markupSegments(text, segment{}, segmentChunker(Flags{}, nil, nil))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(segments) != 3 || segments[0].Text != "One [Pause 1s]two\n[speed0.9]three " {
+		t.Errorf("Expected loose tags to be read as text, got %+v", segments)
+	}
+	for _, expected := range []string{`line=1 tag="[Pause 1s]"`, `line=2 tag=[speed0.9]`} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected a warning with %s, got '%s'", expected, buf.String())
+		}
+	}
+	if strings.Count(buf.String(), "Unable to parse markup tag") != 2 {
+		t.Errorf("Expected only the loose tags to be warned about, got '%s'", buf.String())
+	}
+}
+
+func TestParsePause(t *testing.T) {
+	cases := map[string]time.Duration{
+		"800ms": 800 * time.Millisecond,
+		"1.5s":  1500 * time.Millisecond,
+		"250":   250 * time.Millisecond,
+	}
+	for argument, expected := range cases {
+		pause, err := parsePause(argument)
+		if err != nil || pause != expected {
+			t.Errorf("parsePause(%q) = %v, %v; expected %v", argument, pause, err, expected)
+		}
+	}
+}
+
+func TestReadSegmentsPausesRequireCombining(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.md")
+	if err := os.WriteFile(path, []byte("One.[pause 1s]Two."), 0o644); err != nil {
+		t.Fatalf("Failed to write input file: %v", err)
+	}
+
+	segments, err := readSegments(Flags{InputFile: path, Markup: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(segments) != 2 {
+		t.Errorf("Expected pauses to be dropped without -c, got %+v", segments)
+	}
+
+	segments, err = readSegments(Flags{InputFile: path, CombineFiles: true, Markup: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(segments) != 3 || segments[1].Pause != time.Second {
+		t.Errorf("Expected a pause segment with -c, got %+v", segments)
+	}
+
+	segments, err = readSegments(Flags{InputFile: path, CombineFiles: true})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(segments) != 1 || segments[0].Text != "One.[pause 1s]Two." {
+		t.Errorf("Expected markup to be read as text without --markup, got %+v", segments)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// defaultNameTemplate names chunk files after the output file, such as
+// out_07.mp3.
+const defaultNameTemplate = "{base}_{index}.{format}"
+
+var namePlaceholder = regexp.MustCompile(`\{([^{}]*)\}`)
+
+var nameFields = map[string]bool{"base": true, "index": true, "total": true, "slug": true, "voice": true, "format": true}
+
+// validateNameTemplate checks that a --name-template only uses known
+// placeholders and numbers each chunk, so no two chunks share a file.
+func validateNameTemplate(template string) error {
+	for _, m := range namePlaceholder.FindAllStringSubmatch(template, -1) {
+		if !nameFields[m[1]] {
+			return fmt.Errorf("unknown placeholder {%s} in name template, expected {base}, {index}, {total}, {slug}, {voice} or {format}", m[1])
+		}
+	}
+	if !strings.Contains(template, "{index}") {
+		return fmt.Errorf("name template must contain {index}")
+	}
+	return nil
+}
+
+// chunkFileName names the file of the chunk at index i from --name-template.
+// The index is zero-padded to the width of the chunk count so the files sort
+// in order, and a relative template is placed in the directory of the output
+// file.
+func chunkFileName(flags Flags, segments []segment, i int, slug string) string {
+	ext := filepath.Ext(flags.OutputFile)
+	total := strconv.Itoa(len(segments))
+	name := strings.NewReplacer(
+		"{base}", filepath.Base(strings.TrimSuffix(flags.OutputFile, ext)),
+		"{index}", fmt.Sprintf("%0*d", len(total), i+1),
+		"{total}", total,
+		"{slug}", slug,
+		"{voice}", cmp.Or(segments[i].Voice, flags.VoiceOption),
+		"{format}", flags.FormatOption,
+	).Replace(cmp.Or(flags.NameTemplate, defaultNameTemplate))
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(flags.OutputFile), name)
+}
+
+// namePatterns match what each placeholder of a name template expands to.
+var namePatterns = map[string]string{"base": `(?P<base>.+)`, "index": `\d+`, "total": `\d+`, "slug": `[^/]*`, "voice": `[^/]*`, "format": `[^/.]+`}
+
+// nameTemplatePattern matches the chunk file names a name template gives. The
+// first {base} is captured as "base", so a chunk can be tied to its output.
+func nameTemplatePattern(template string) *regexp.Regexp {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	captured := false
+	position := 0
+	for _, m := range namePlaceholder.FindAllStringSubmatchIndex(template, -1) {
+		pattern.WriteString(regexp.QuoteMeta(template[position:m[0]]))
+		field := template[m[2]:m[3]]
+		if field == "base" && captured {
+			pattern.WriteString(`.+`)
+		} else {
+			pattern.WriteString(namePatterns[field])
+		}
+		captured = captured || field == "base"
+		position = m[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[position:]))
+	pattern.WriteString("$")
+	return regexp.MustCompile(pattern.String())
+}
+
+// createChunkDir creates the directory for a chunk file and returns the
+// directories that did not exist yet, outermost first, so they can be removed
+// with the chunk files.
+func createChunkDir(dir string) ([]string, error) {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil || filepath.Dir(d) == d {
+			break
+		}
+		missing = append(missing, d)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	slices.Reverse(missing)
+	return missing, nil
+}
+
+// chunkHeadings returns the title of the heading each segment belongs to: the
+// first heading in its text, or else the last heading before it.
+func chunkHeadings(segments []segment) []string {
+	headings := make([]string, len(segments))
+	current := ""
+	for i, segment := range segments {
+		first := ""
+		for _, line := range strings.Split(segment.Text, "\n") {
+			if m := atxHeading.FindStringSubmatch(strings.TrimRight(line, "\r")); m != nil {
+				current = headingTitle(m[2])
+				first = cmp.Or(first, current)
+			}
+		}
+		headings[i] = cmp.Or(first, current)
+	}
+	return headings
+}
+
+func chunkSlugs(segments []segment) []string {
+	slugs := chunkHeadings(segments)
+	for i, heading := range slugs {
+		slugs[i] = slugify(heading)
+	}
+	return slugs
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestValidateNameTemplate(t *testing.T) {
+	if err := validateNameTemplate("{base}/{index}-{slug}-{voice}.{format}"); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	for _, template := range []string{"{base}.{format}", "{base}_{number}.{format}", "{index}_{}.mp3"} {
+		if err := validateNameTemplate(template); err == nil {
+			t.Errorf("Expected an error for %q, got nil", template)
+		}
+	}
+}
+
+func TestChunkFileName(t *testing.T) {
+	segments := make([]segment, 12)
+	segments[10].Voice = "onyx"
+	flags := Flags{OutputFile: filepath.Join("out", "book.mp3"), FormatOption: "mp3", VoiceOption: "nova"}
+
+	if name := chunkFileName(flags, segments, 1, ""); name != filepath.Join("out", "book_02.mp3") {
+		t.Errorf("Expected a zero-padded default name, got %q", name)
+	}
+	flags.NameTemplate = "{base}/{index}of{total}-{slug}-{voice}.{format}"
+	if name := chunkFileName(flags, segments, 10, "setup"); name != filepath.Join("out", "book", "11of12-setup-onyx.mp3") {
+		t.Errorf("Unexpected templated name %q", name)
+	}
+	if name := chunkFileName(Flags{OutputFile: "book.wav", FormatOption: "wav"}, segments[:3], 2, ""); name != "book_3.wav" {
+		t.Errorf("Expected no padding for fewer than 10 chunks, got %q", name)
+	}
+}
+
+func TestChunkSlugs(t *testing.T) {
+	segments := []segment{
+		{Text: "Preface."},
+		{Text: "# Getting Started\nHello."},
+		{Text: "More."},
+		{Text: "End of one.\n## Next **Steps**\nGo."},
+	}
+	expected := []string{"", "getting-started", "getting-started", "next-steps"}
+	if slugs := chunkSlugs(segments); !reflect.DeepEqual(slugs, expected) {
+		t.Errorf("Expected %q, got %q", expected, slugs)
+	}
+}
+
+func TestProcessChunksNameTemplate(t *testing.T) {
+	dir := t.TempDir()
+	config := Config{OpenAIAPIKey: "test-api-key", httpClient: &MockHTTPClient{DoFunc: mockAudio}}
+	flags := Flags{
+		OutputFile:   filepath.Join(dir, "out.wav"),
+		FormatOption: "wav",
+		NameTemplate: "chunks/{index}-{slug}.{format}",
+	}
+	segments := []segment{{Text: "# Intro\nOne."}, {Text: "Two."}}
+
+	var createdFiles []string
+	if err := processChunks(context.Background(), segments, flags, config, &createdFiles); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, name := range []string{"1-intro.wav", "2-intro.wav"} {
+		if _, err := os.Stat(filepath.Join(dir, "chunks", name)); err != nil {
+			t.Errorf("Expected chunk %s, got %v", name, err)
+		}
+	}
+
+	if err := cleanupFiles(createdFiles); err != nil {
+		t.Fatalf("Expected no cleanup error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "chunks")); !os.IsNotExist(err) {
+		t.Errorf("Expected the chunk directory to be removed with its chunks, got %v", err)
+	}
+}
+
+func TestCleanupFilesKeepsDirectoriesInUse(t *testing.T) {
+	dir := t.TempDir()
+	created, err := createChunkDir(filepath.Join(dir, "a", "b"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if expected := []string{filepath.Join(dir, "a"), filepath.Join(dir, "a", "b")}; !reflect.DeepEqual(created, expected) {
+		t.Errorf("Expected created directories %q, got %q", expected, created)
+	}
+	chunk := filepath.Join(dir, "a", "b", "1.wav")
+	other := filepath.Join(dir, "a", "notes.txt")
+	for _, name := range []string{chunk, other} {
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cleanupFiles(append(created, chunk)); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "b")); !os.IsNotExist(err) {
+		t.Errorf("Expected the empty directory to be removed, got %v", err)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("Expected the directory holding other files to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,627 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultLocale = "en-US"
+
+// normalizeCategories lists the rewrites in the order they run. Forms that
+// contain numbers, such as dates and prices, are read before plain numbers.
+var normalizeCategories = []string{"abbreviations", "dates", "times", "currency", "versions", "units", "ordinals", "numbers"}
+
+// normalizeProfiles name common selections of categories for --normalize.
+var normalizeProfiles = map[string][]string{
+	"none":  nil,
+	"prose": {"abbreviations", "dates", "times", "currency", "ordinals", "numbers"},
+	"all":   normalizeCategories,
+}
+
+type locale struct {
+	// dayFirst reads 1/2/2025 as the first of February.
+	dayFirst bool
+	// and reads 105 as one hundred and five.
+	and bool
+	// metre spells units as kilometre and litre.
+	metre bool
+}
+
+var locales = map[string]locale{
+	"en-US": {},
+	"en-GB": {dayFirst: true, and: true, metre: true},
+}
+
+// normalizer spells out numbers and symbols the model reads unpredictably,
+// such as 3.14.2, 1/2/2025, $1.2M and 10GB.
+type normalizer struct {
+	locale locale
+	rules  []normalizeRule
+}
+
+type normalizeRule struct {
+	category string
+	pattern  *regexp.Regexp
+	// expand returns the words for the match m in text, or false to leave it.
+	expand func(n *normalizer, text string, m []int) (string, bool)
+}
+
+const numeral = `(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d+))?`
+
+var normalizeRules = []normalizeRule{
+	{"abbreviations", regexp.MustCompile(`\b(?:e\.g\.|i\.e\.|etc\.|vs\.?|approx\.|Dr\.|Mrs?\.|Ms\.|Prof\.)|\bNo\.(\s?)(\d+)`), (*normalizer).abbreviation},
+	{"dates", regexp.MustCompile(`(\d{1,2})/(\d{1,2})/(\d{4}|\d{2})`), (*normalizer).numericDate},
+	{"dates", regexp.MustCompile(`(\d{4})-(\d{2})-(\d{2})`), (*normalizer).isoDate},
+	{"dates", regexp.MustCompile(`\b` + monthNames + `\.? (\d{1,2})(?:st|nd|rd|th)?(?:,? (\d{4}))?`), (*normalizer).monthDayDate},
+	{"dates", regexp.MustCompile(`(\d{1,2})(?:st|nd|rd|th)? ` + monthNames + `\.?(?:,? (\d{4}))?`), (*normalizer).dayMonthDate},
+	{"times", regexp.MustCompile(`(\d{1,2})(?::(\d{2})(?::(\d{2}))?)?(?:\s?([AaPp])\.?[Mm]\b\.?)?`), (*normalizer).time},
+	{"currency", regexp.MustCompile(`([$£€¥])` + numeral + `(?:\s?(K|M|B|bn|T|thousand|million|billion|trillion)\b)?`), (*normalizer).currency},
+	{"versions", regexp.MustCompile(`\b[vV](\d+(?:\.\d+)*)|(\d+(?:\.\d+){2,})`), (*normalizer).version},
+	{"units", regexp.MustCompile(numeral + `\s?(` + unitPattern() + `)`), (*normalizer).unit},
+	{"ordinals", regexp.MustCompile(`(\d+)(?:st|nd|rd|th)`), (*normalizer).ordinal},
+	{"numbers", regexp.MustCompile(`(\d+)((?::\d+)+)`), (*normalizer).ratio},
+	{"numbers", regexp.MustCompile(`-` + numeral), (*normalizer).negative},
+	{"numbers", regexp.MustCompile(numeral), (*normalizer).number},
+}
+
+func registerNormalizeFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.StringVar(&flags.Normalize, "normalize", "none", "Spell out numbers, dates, currency and units: none, prose, all or a list of categories")
+	fs.StringVar(&flags.Locale, "locale", defaultLocale, "Locale used to spell out normalized text: en-US, en-GB")
+}
+
+// newNormalizer returns the normalizer selected with --normalize, or nil when
+// normalization is off.
+func newNormalizer(flags Flags) (*normalizer, error) {
+	name := flags.Locale
+	if name == "" {
+		name = defaultLocale
+	}
+	loc, ok := locales[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown locale %q. Options: en-US, en-GB", name)
+	}
+
+	enabled := map[string]bool{}
+	for _, name := range strings.Split(flags.Normalize, ",") {
+		name = strings.TrimSpace(name)
+		if profile, ok := normalizeProfiles[name]; ok {
+			for _, category := range profile {
+				enabled[category] = true
+			}
+			continue
+		}
+		if name != "" && !slices.Contains(normalizeCategories, name) {
+			return nil, fmt.Errorf("unknown normalization %q. Options: none, prose, all, %s", name, strings.Join(normalizeCategories, ", "))
+		}
+		enabled[name] = true
+	}
+
+	n := &normalizer{locale: loc}
+	for _, rule := range normalizeRules {
+		if enabled[rule.category] {
+			n.rules = append(n.rules, rule)
+		}
+	}
+	if len(n.rules) == 0 {
+		return nil, nil
+	}
+	return n, nil
+}
+
+func (n *normalizer) apply(text string) string {
+	if n == nil {
+		return text
+	}
+	for _, rule := range n.rules {
+		text = n.replace(rule, text)
+	}
+	return text
+}
+
+func (n *normalizer) replace(rule normalizeRule, text string) string {
+	var result strings.Builder
+	last := 0
+	for _, m := range rule.pattern.FindAllStringSubmatchIndex(text, -1) {
+		if !standalone(text, m[0], m[1]) {
+			continue
+		}
+		words, ok := rule.expand(n, text, m)
+		if !ok {
+			continue
+		}
+		result.WriteString(text[last:m[0]])
+		result.WriteString(words)
+		last = m[1]
+	}
+	result.WriteString(text[last:])
+	return result.String()
+}
+
+// standalone reports whether text[start:end] is not part of a longer word or
+// number, so MP3, x86 and 192.168.0.1 are left alone.
+func standalone(text string, start, end int) bool {
+	if start > 0 {
+		before := text[start-1]
+		if isWordByte(before) || before == '.' && start > 1 && isDigit(text[start-2]) {
+			return false
+		}
+	}
+	if end < len(text) {
+		after := text[end]
+		if isWordByte(after) || after == '.' && end+1 < len(text) && isDigit(text[end+1]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// group returns submatch i of m, or "" when it did not take part.
+func group(text string, m []int, i int) string {
+	if m[2*i] < 0 {
+		return ""
+	}
+	return text[m[2*i]:m[2*i+1]]
+}
+
+var abbreviations = map[string]string{
+	"e.g.":    "for example",
+	"i.e.":    "that is",
+	"etc.":    "et cetera",
+	"vs.":     "versus",
+	"vs":      "versus",
+	"approx.": "approximately",
+	"Dr.":     "Doctor",
+	"Mr.":     "Mister",
+	"Mrs.":    "Missus",
+	"Ms.":     "Miz",
+	"Prof.":   "Professor",
+}
+
+func (n *normalizer) abbreviation(text string, m []int) (string, bool) {
+	if digits := group(text, m, 2); digits != "" {
+		return "number " + digits, true
+	}
+	match := text[m[0]:m[1]]
+	rest := strings.TrimLeft(text[m[1]:], " \t")
+	switch match {
+	case "Dr.", "Mr.", "Mrs.", "Ms.", "Prof.":
+		// Only titles in front of a name, so "Elm Dr." keeps its meaning.
+		if rest == text[m[1]:] || rest == "" || rest[0] < 'A' || rest[0] > 'Z' {
+			return "", false
+		}
+	case "etc.":
+		// Keep the full stop when etc. ends a sentence.
+		if rest == "" || rest[0] == '\n' || rest[0] == '\r' || rest[0] >= 'A' && rest[0] <= 'Z' {
+			return abbreviations[match] + ".", true
+		}
+	}
+	return abbreviations[match], true
+}
+
+const monthNames = `(January|February|March|April|May|June|July|August|September|October|November|December|Jan|Feb|Mar|Apr|Jun|Jul|Aug|Sept|Sep|Oct|Nov|Dec)`
+
+var months = []string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+
+func monthNumber(name string) int {
+	for i, month := range months {
+		if strings.HasPrefix(month, name[:3]) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (n *normalizer) numericDate(text string, m []int) (string, bool) {
+	month, _ := strconv.Atoi(group(text, m, 1))
+	day, _ := strconv.Atoi(group(text, m, 2))
+	if n.locale.dayFirst {
+		month, day = day, month
+	}
+	return n.date(month, day, group(text, m, 3))
+}
+
+func (n *normalizer) isoDate(text string, m []int) (string, bool) {
+	month, _ := strconv.Atoi(group(text, m, 2))
+	day, _ := strconv.Atoi(group(text, m, 3))
+	return n.date(month, day, group(text, m, 1))
+}
+
+func (n *normalizer) date(month, day int, year string) (string, bool) {
+	if month < 1 || month > 12 || day < 1 || day > 31 {
+		return "", false
+	}
+	dayWords := ordinalWords(n.cardinal(uint64(day)))
+	var words string
+	if n.locale.dayFirst {
+		words = "the " + dayWords + " of " + months[month-1]
+	} else {
+		words = months[month-1] + " " + dayWords
+	}
+	if year == "" {
+		return words, true
+	}
+	if !n.locale.dayFirst {
+		words += ","
+	}
+	return words + " " + n.year(year), true
+}
+
+func (n *normalizer) monthDayDate(text string, m []int) (string, bool) {
+	day, _ := strconv.Atoi(group(text, m, 2))
+	if day < 1 || day > 31 {
+		return "", false
+	}
+	words := months[monthNumber(group(text, m, 1))-1] + " " + ordinalWords(n.cardinal(uint64(day)))
+	if year := group(text, m, 3); year != "" {
+		words += ", " + n.year(year)
+	}
+	return words, true
+}
+
+func (n *normalizer) dayMonthDate(text string, m []int) (string, bool) {
+	day, _ := strconv.Atoi(group(text, m, 1))
+	if day < 1 || day > 31 {
+		return "", false
+	}
+	words := "the " + ordinalWords(n.cardinal(uint64(day))) + " of " + months[monthNumber(group(text, m, 2))-1]
+	if year := group(text, m, 3); year != "" {
+		words += " " + n.year(year)
+	}
+	return words, true
+}
+
+// year reads 2025 as twenty twenty-five and 1905 as nineteen oh five.
+func (n *normalizer) year(digits string) string {
+	value, _ := strconv.ParseUint(digits, 10, 64)
+	switch {
+	case len(digits) == 2 && value < 10:
+		return "oh " + ones[value]
+	case len(digits) != 4 || value%1000 < 10:
+		return n.cardinal(value)
+	case value%100 == 0:
+		return n.cardinal(value/100) + " hundred"
+	case value%100 < 10:
+		return n.cardinal(value/100) + " oh " + ones[value%100]
+	}
+	return n.cardinal(value/100) + " " + n.cardinal(value%100)
+}
+
+func (n *normalizer) time(text string, m []int) (string, bool) {
+	minutes, seconds, meridiem := group(text, m, 2), group(text, m, 3), strings.ToUpper(group(text, m, 4))
+	if minutes == "" && meridiem == "" {
+		return "", false
+	}
+	hour, _ := strconv.Atoi(group(text, m, 1))
+	minute, _ := strconv.Atoi(minutes)
+	second, _ := strconv.Atoi(seconds)
+	if hour > 23 || meridiem != "" && (hour < 1 || hour > 12) || minute > 59 || second > 59 {
+		return "", false
+	}
+
+	words := n.cardinal(uint64(hour))
+	switch {
+	case minute > 9:
+		words += " " + n.cardinal(uint64(minute))
+	case minute > 0:
+		words += " oh " + ones[minute]
+	case meridiem != "":
+	case hour >= 1 && hour <= 12:
+		words += " o'clock"
+	default:
+		words += " hundred"
+	}
+	if seconds != "" && second > 0 {
+		words += " and " + n.counted(strconv.Itoa(second), "second", "seconds")
+	}
+	if meridiem != "" {
+		words += " " + meridiem + "M"
+	}
+	return words, true
+}
+
+type currencyName struct {
+	one, many, minorOne, minorMany string
+}
+
+var currencies = map[string]currencyName{
+	"$": {"dollar", "dollars", "cent", "cents"},
+	"£": {"pound", "pounds", "penny", "pence"},
+	"€": {"euro", "euros", "cent", "cents"},
+	"¥": {"yen", "yen", "", ""},
+}
+
+var scales = map[string]string{
+	"K": "thousand", "thousand": "thousand",
+	"M": "million", "million": "million",
+	"B": "billion", "bn": "billion", "billion": "billion",
+	"T": "trillion", "trillion": "trillion",
+}
+
+func (n *normalizer) currency(text string, m []int) (string, bool) {
+	name := currencies[group(text, m, 1)]
+	whole, fraction := group(text, m, 2), group(text, m, 3)
+
+	if scale := group(text, m, 4); scale != "" {
+		return n.numberWords(whole, fraction) + " " + scales[scale] + " " + name.many, true
+	}
+	if len(fraction) != 2 || name.minorMany == "" {
+		if fraction != "" {
+			return n.numberWords(whole, fraction) + " " + name.many, true
+		}
+		return n.counted(whole, name.one, name.many), true
+	}
+
+	var parts []string
+	if strings.Trim(strings.ReplaceAll(whole, ",", ""), "0") != "" {
+		parts = append(parts, n.counted(whole, name.one, name.many))
+	}
+	if fraction != "00" {
+		parts = append(parts, n.counted(strings.TrimPrefix(fraction, "0"), name.minorOne, name.minorMany))
+	}
+	if len(parts) == 0 {
+		return "zero " + name.many, true
+	}
+	return strings.Join(parts, " and "), true
+}
+
+func (n *normalizer) version(text string, m []int) (string, bool) {
+	numbers := group(text, m, 1)
+	prefix := "version "
+	if numbers == "" {
+		numbers, prefix = group(text, m, 2), ""
+	}
+	var words []string
+	for _, part := range strings.Split(numbers, ".") {
+		words = append(words, n.integer(part))
+	}
+	return prefix + strings.Join(words, " point "), true
+}
+
+type unitName struct {
+	one, many string
+}
+
+var units = map[string]unitName{
+	"%":    {"percent", "percent"},
+	"°C":   {"degree Celsius", "degrees Celsius"},
+	"°F":   {"degree Fahrenheit", "degrees Fahrenheit"},
+	"KB":   {"kilobyte", "kilobytes"},
+	"kB":   {"kilobyte", "kilobytes"},
+	"MB":   {"megabyte", "megabytes"},
+	"GB":   {"gigabyte", "gigabytes"},
+	"TB":   {"terabyte", "terabytes"},
+	"PB":   {"petabyte", "petabytes"},
+	"Mbps": {"megabit per second", "megabits per second"},
+	"Gbps": {"gigabit per second", "gigabits per second"},
+	"Hz":   {"hertz", "hertz"},
+	"kHz":  {"kilohertz", "kilohertz"},
+	"MHz":  {"megahertz", "megahertz"},
+	"GHz":  {"gigahertz", "gigahertz"},
+	"ns":   {"nanosecond", "nanoseconds"},
+	"ms":   {"millisecond", "milliseconds"},
+	"mm":   {"millimeter", "millimeters"},
+	"cm":   {"centimeter", "centimeters"},
+	"km":   {"kilometer", "kilometers"},
+	"km/h": {"kilometer per hour", "kilometers per hour"},
+	"mph":  {"mile per hour", "miles per hour"},
+	"ft":   {"foot", "feet"},
+	"mg":   {"milligram", "milligrams"},
+	"kg":   {"kilogram", "kilograms"},
+	"lb":   {"pound", "pounds"},
+	"lbs":  {"pound", "pounds"},
+	"oz":   {"ounce", "ounces"},
+	"ml":   {"milliliter", "milliliters"},
+	"mL":   {"milliliter", "milliliters"},
+	"kW":   {"kilowatt", "kilowatts"},
+	"kWh":  {"kilowatt hour", "kilowatt hours"},
+	"px":   {"pixel", "pixels"},
+	"fps":  {"frame per second", "frames per second"},
+}
+
+// unitPattern lists the unit symbols longest first, so km/h wins over km.
+func unitPattern() string {
+	var symbols []string
+	for symbol := range units {
+		symbols = append(symbols, symbol)
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		if len(symbols[i]) != len(symbols[j]) {
+			return len(symbols[i]) > len(symbols[j])
+		}
+		return symbols[i] < symbols[j]
+	})
+	for i, symbol := range symbols {
+		symbols[i] = regexp.QuoteMeta(symbol)
+	}
+	return strings.Join(symbols, "|")
+}
+
+func (n *normalizer) unit(text string, m []int) (string, bool) {
+	name := units[group(text, m, 3)]
+	if n.locale.metre {
+		name.one = strings.NewReplacer("meter", "metre", "liter", "litre").Replace(name.one)
+		name.many = strings.NewReplacer("meter", "metre", "liter", "litre").Replace(name.many)
+	}
+	whole, fraction := group(text, m, 1), group(text, m, 2)
+	if fraction != "" {
+		return n.numberWords(whole, fraction) + " " + name.many, true
+	}
+	return n.counted(whole, name.one, name.many), true
+}
+
+func (n *normalizer) ordinal(text string, m []int) (string, bool) {
+	return ordinalWords(n.integer(group(text, m, 1))), true
+}
+
+func (n *normalizer) negative(text string, m []int) (string, bool) {
+	return "minus " + n.numberWords(group(text, m, 1), group(text, m, 2)), true
+}
+
+// yearWords are the words after which a bare four digit number is a year,
+// along with the names of the months.
+var yearWords = []string{"in", "since", "from", "by", "until", "till", "during", "before", "after", "circa", "year"}
+
+// yearList matches a four digit number listed before another, as 1999 in
+// "1999 and 2024" or "1999, 2024".
+var yearList = regexp.MustCompile(`(?:^|\s)(\d{4})(?:,?\s+(?:and|or|to|through|nor)|,)\s+$`)
+
+func (n *normalizer) number(text string, m []int) (string, bool) {
+	whole, fraction := group(text, m, 1), group(text, m, 2)
+	if m[0] > 1 && text[m[0]-1] == ':' && isDigit(text[m[0]-2]) || m[1]+1 < len(text) && text[m[1]] == ':' && isDigit(text[m[1]+1]) {
+		// Part of a time the ratio rule left alone.
+		return "", false
+	}
+	if len(whole) == 4 && fraction == "" && isYear(text, m[0]) {
+		return n.year(whole), true
+	}
+	return n.numberWords(whole, fraction), true
+}
+
+// isYear reports whether the four digit number at start follows a word such as
+// "in" or a month name, or is listed with such a year, so "in 1999 and 2024"
+// reads both numbers as years.
+func isYear(text string, start int) bool {
+	window := max(start-24, 0)
+	if list := yearList.FindStringSubmatchIndex(text[window:start]); list != nil && (list[2] > 0 || window == 0) {
+		return isYear(text, window+list[2])
+	}
+	fields := strings.Fields(text[max(start-16, 0):start])
+	if len(fields) == 0 {
+		return false
+	}
+	previous := strings.TrimSuffix(fields[len(fields)-1], ",")
+	return slices.Contains(yearWords, strings.ToLower(previous)) || slices.Contains(months, previous)
+}
+
+// ratio reads 3:2 as three to two. Times such as 10:30 are read by the times
+// rule first, so only ratios are left when it is enabled. Without it, numbers
+// shaped like a time are left alone.
+func (n *normalizer) ratio(text string, m []int) (string, bool) {
+	parts := strings.Split(text[m[0]:m[1]], ":")
+	if len(parts) <= 3 && len(parts[0]) <= 2 && len(parts[1]) == 2 && (len(parts) == 2 || len(parts[2]) == 2) {
+		return text[m[0]:m[1]], true
+	}
+	var words []string
+	for _, part := range parts {
+		words = append(words, n.integer(part))
+	}
+	return strings.Join(words, " to "), true
+}
+
+// numberWords reads a numeral with thousands separators and an optional
+// fraction, reading the digits of the fraction one at a time.
+func (n *normalizer) numberWords(whole, fraction string) string {
+	words := n.integer(whole)
+	if fraction != "" {
+		words += " point " + digitWords(fraction)
+	}
+	return words
+}
+
+// counted reads a whole number followed by the singular or plural noun.
+func (n *normalizer) counted(whole, one, many string) string {
+	if whole == "1" {
+		return "one " + one
+	}
+	return n.integer(whole) + " " + many
+}
+
+// integer reads digits as a cardinal number. Numbers with leading zeros, such
+// as 007, and numbers too large to name are read digit by digit.
+func (n *normalizer) integer(digits string) string {
+	digits = strings.ReplaceAll(digits, ",", "")
+	if len(digits) > 1 && digits[0] == '0' {
+		return digitWords(digits)
+	}
+	value, err := strconv.ParseUint(digits, 10, 64)
+	if err != nil {
+		return digitWords(digits)
+	}
+	return n.cardinal(value)
+}
+
+var ones = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "ten",
+	"eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+
+var tens = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+
+var powers = []string{"", "thousand", "million", "billion", "trillion", "quadrillion", "quintillion"}
+
+func (n *normalizer) cardinal(value uint64) string {
+	if value == 0 {
+		return "zero"
+	}
+	var groups []string
+	for power := 0; value > 0; power++ {
+		part := int(value % 1000)
+		value /= 1000
+		if part == 0 {
+			continue
+		}
+		words := n.belowThousand(part)
+		if power > 0 {
+			words += " " + powers[power]
+		} else if n.locale.and && value > 0 && part < 100 {
+			words = "and " + words
+		}
+		groups = append([]string{words}, groups...)
+	}
+	return strings.Join(groups, " ")
+}
+
+func (n *normalizer) belowThousand(value int) string {
+	var words []string
+	if value >= 100 {
+		words = append(words, ones[value/100], "hundred")
+		value %= 100
+		if value > 0 && n.locale.and {
+			words = append(words, "and")
+		}
+	}
+	switch {
+	case value >= 20 && value%10 > 0:
+		words = append(words, tens[value/10]+"-"+ones[value%10])
+	case value >= 20:
+		words = append(words, tens[value/10])
+	case value > 0:
+		words = append(words, ones[value])
+	}
+	return strings.Join(words, " ")
+}
+
+func digitWords(digits string) string {
+	words := make([]string, 0, len(digits))
+	for _, digit := range digits {
+		words = append(words, ones[digit-'0'])
+	}
+	return strings.Join(words, " ")
+}
+
+var irregularOrdinals = map[string]string{
+	"one": "first", "two": "second", "three": "third", "five": "fifth",
+	"eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+}
+
+// ordinalWords turns the last word of a cardinal number into an ordinal.
+func ordinalWords(cardinal string) string {
+	cut := strings.LastIndexAny(cardinal, " -") + 1
+	head, last := cardinal[:cut], cardinal[cut:]
+	switch {
+	case irregularOrdinals[last] != "":
+		last = irregularOrdinals[last]
+	case strings.HasSuffix(last, "y"):
+		last = strings.TrimSuffix(last, "y") + "ieth"
+	default:
+		last += "th"
+	}
+	return head + last
+}
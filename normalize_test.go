@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := []struct {
+		locale, text, expected string
+	}{
+		{"en-US", "Upgrade to 3.14.2 or v2.", "Upgrade to three point fourteen point two or version two."},
+		{"en-US", "Released on 1/2/2025.", "Released on January second, twenty twenty-five."},
+		{"en-GB", "Released on 1/2/2025.", "Released on the first of February twenty twenty-five."},
+		{"en-US", "Due 2025-03-04 or March 5th, 2026.", "Due March fourth, twenty twenty-five or March fifth, twenty twenty-six."},
+		{"en-US", "Born 5 June 1905.", "Born the fifth of June nineteen oh five."},
+		{"en-US", "Raised $1.2M from 3 investors.", "Raised one point two million dollars from three investors."},
+		{"en-US", "It costs $5.99, not $1 or $0.05.", "It costs five dollars and ninety-nine cents, not one dollar or five cents."},
+		{"en-GB", "A £3.50 ticket.", "A three pounds and fifty pence ticket."},
+		{"en-US", "Download 10GB at 100 Mbps.", "Download ten gigabytes at one hundred megabits per second."},
+		{"en-GB", "Drive 1 km, then 2.5 km.", "Drive one kilometre, then two point five kilometres."},
+		{"en-US", "Meet at 10:30 or 7pm, not 14:00.", "Meet at ten thirty or seven PM, not fourteen hundred."},
+		{"en-US", "Wake at 6:05 a.m. sharp.", "Wake at six oh five AM sharp."},
+		{"en-US", "The 21st and 3rd runs.", "The twenty-first and third runs."},
+		{"en-US", "We had 1,234 users, 105 admins and -3 degrees.", "We had one thousand two hundred thirty-four users, one hundred five admins and minus three degrees."},
+		{"en-GB", "We had 1,234 users and 1005 visits.", "We had one thousand two hundred and thirty-four users and one thousand and five visits."},
+		{"en-US", "Founded in 1999 with 1999 staff.", "Founded in nineteen ninety-nine with one thousand nine hundred ninety-nine staff."},
+		{"en-US", "Shipped in 1999 and 2024, or 1905, 2005 and 2010.", "Shipped in nineteen ninety-nine and twenty twenty-four, or nineteen oh five, two thousand five and twenty ten."},
+		{"en-US", "Mix 3:2 at 16:9 or 1:1000, not 10:30.", "Mix three to two at sixteen to nine or one to one thousand, not ten thirty."},
+		{"en-US", "Pi is 3.14 and agent 007.", "Pi is three point one four and agent zero zero seven."},
+		{"en-US", "Apples, pears, etc. are fruit, e.g. these. Cats vs. dogs, etc.", "Apples, pears, et cetera are fruit, for example these. Cats versus dogs, et cetera."},
+		{"en-US", "Ask Dr. Smith on Elm Dr. about No. 5.", "Ask Doctor Smith on Elm Dr. about number five."},
+		{"en-US", "Play MP3 files on x86 at 192.168.0.1.", "Play MP3 files on x86 at one hundred ninety-two point one hundred sixty-eight point zero point one."},
+	}
+	for _, c := range cases {
+		norm, err := newNormalizer(Flags{Normalize: "all", Locale: c.locale})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if normalized := norm.apply(c.text); normalized != c.expected {
+			t.Errorf("apply(%q) in %s = %q; expected %q", c.text, c.locale, normalized, c.expected)
+		}
+	}
+}
+
+func TestNormalizeCategories(t *testing.T) {
+	text := "Version 3.14.2 costs $5 on 1/2/2025."
+	cases := []struct {
+		normalize, expected string
+	}{
+		{"", text},
+		{"none", text},
+		{"currency", "Version 3.14.2 costs five dollars on 1/2/2025."},
+		{"prose", "Version 3.14.2 costs five dollars on January second, twenty twenty-five."},
+		{"versions, dates", "Version three point fourteen point two costs $5 on January second, twenty twenty-five."},
+	}
+	for _, c := range cases {
+		norm, err := newNormalizer(Flags{Normalize: c.normalize})
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", c.normalize, err)
+		}
+		if normalized := norm.apply(text); normalized != c.expected {
+			t.Errorf("--normalize %q gave %q; expected %q", c.normalize, normalized, c.expected)
+		}
+	}
+
+	norm, err := newNormalizer(Flags{Normalize: "numbers"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if normalized := norm.apply("Meet at 10:30 and mix 3:2."); normalized != "Meet at 10:30 and mix three to two." {
+		t.Errorf("Expected times to be left for the times category, got %q", normalized)
+	}
+
+	if _, err := newNormalizer(Flags{Normalize: "numbers,colours"}); err == nil {
+		t.Error("Expected an error for an unknown category")
+	}
+	if _, err := newNormalizer(Flags{Normalize: "all", Locale: "fr-FR"}); err == nil {
+		t.Error("Expected an error for an unknown locale")
+	}
+}
+
+func TestReadSegmentsNormalizesAfterLexicon(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "input.md")
+	if err := os.WriteFile(input, []byte("Install 3.14.2 on 2 hosts."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	lexiconFile := filepath.Join(dir, "test.lexicon")
+	if err := os.WriteFile(lexiconFile, []byte("3.14.2 => the March release\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := readSegments(Flags{InputFile: input, LexiconFile: lexiconFile, Normalize: "all"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(segments) != 1 || !strings.Contains(segments[0].Text, "Install the March release on two hosts.") {
+		t.Errorf("Expected lexicon rules to run before normalization, got %+v", segments)
+	}
+	if segments[0].Source != "Install 3.14.2 on 2 hosts." {
+		t.Errorf("Expected the segment to keep the text as written, got %q", segments[0].Source)
+	}
+}
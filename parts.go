@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxSlugLength = 60
+
+// part is one output file of a run and the segments synthesized into it.
+type part struct {
+	Title    string
+	Output   string
+	Segments []segment
+}
+
+// partOutput names the output of a part after the output file, its number and
+// its title, such as book_03_the-return.mp3.
+func partOutput(output string, number, total int, title string) string {
+	ext := filepath.Ext(output)
+	width := max(len(strconv.Itoa(total)), 2)
+	name := fmt.Sprintf("%s_%0*d", strings.TrimSuffix(output, ext), width, number)
+	if slug := slugify(title); slug != "" {
+		name += "_" + slug
+	}
+	return name + ext
+}
+
+// sectionOutput names the output of a section split with --split-by after its
+// number and heading, such as 03-installing-the-agent.mp3, in the directory of
+// the output file.
+func sectionOutput(output string, number, total int, title string) string {
+	width := max(len(strconv.Itoa(total)), 2)
+	name := fmt.Sprintf("%0*d", width, number)
+	if slug := slugify(title); slug != "" {
+		name += "-" + slug
+	}
+	return filepath.Join(filepath.Dir(output), name+filepath.Ext(output))
+}
+
+// sectionIndex names the index of sections written next to them, such as
+// book_index.md for book.mp3.
+func sectionIndex(output string) string {
+	return strings.TrimSuffix(output, filepath.Ext(output)) + "_index.md"
+}
+
+// writeSectionIndex writes a Markdown list that links each heading to the file
+// it was synthesized into, or to each file when it was split into parts.
+func writeSectionIndex(name string, b book) error {
+	escape := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+	var index strings.Builder
+	fmt.Fprintf(&index, "# %s\n\n", b.Title)
+	for i, part := range b.Parts {
+		files := partFiles(part.Output)
+		switch len(files) {
+		case 0:
+			fmt.Fprintf(&index, "%d. %s\n", i+1, part.Title)
+		case 1:
+			fmt.Fprintf(&index, "%d. [%s](%s)\n", i+1, escape.Replace(part.Title), filepath.Base(files[0]))
+		default:
+			fmt.Fprintf(&index, "%d. %s\n", i+1, part.Title)
+			for k, file := range files {
+				fmt.Fprintf(&index, "   - [Part %d](%s)\n", k+1, filepath.Base(file))
+			}
+		}
+	}
+	if err := os.WriteFile(name, []byte(index.String()), 0o644); err != nil {
+		return fmt.Errorf("unable to write section index: %w", err)
+	}
+	return nil
+}
+
+// partFiles returns the output file of a part, or the files it was split into
+// by --max-part-duration.
+func partFiles(output string) []string {
+	if _, err := os.Stat(output); err == nil {
+		return []string{output}
+	}
+	return splitOutputs(output)
+}
+
+// slugify lowercases title and joins its words with hyphens, so it can be
+// used in a file name. Long titles are cut at a word boundary.
+func slugify(title string) string {
+	words := strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var slug string
+	for _, word := range words {
+		next := word
+		if slug != "" {
+			next = slug + "-" + word
+		}
+		if utf8.RuneCountInString(next) > maxSlugLength {
+			if slug == "" {
+				return string([]rune(word)[:maxSlugLength])
+			}
+			break
+		}
+		slug = next
+	}
+	return slug
+}
+
+func partSegments(parts []part) int {
+	total := 0
+	for _, part := range parts {
+		total += len(part.Segments)
+	}
+	return total
+}
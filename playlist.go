@@ -0,0 +1,234 @@
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// playlistTrack is a chunk file kept as its own track.
+type playlistTrack struct {
+	Title    string
+	File     string
+	Duration time.Duration
+	Text     string
+}
+
+// playlistChapter links a heading to the track it starts in.
+type playlistChapter struct {
+	Title string
+	Track int
+}
+
+func registerPlaylistFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.Func("playlist", "Playlist written next to chunk files that are not combined: m3u8, pls or none", func(value string) error {
+		if value != "m3u8" && value != "pls" && value != "none" {
+			return fmt.Errorf("playlist must be m3u8, pls or none")
+		}
+		flags.Playlist = value
+		return nil
+	})
+	fs.BoolVar(&flags.HTMLPlayer, "html", false, "Write an HTML player named after the output next to chunk files that are not combined")
+}
+
+// writePlaylists lists the chunk files of a run that were not combined in a
+// playlist next to them, and in an HTML player such as out.html when --html is
+// given.
+func writePlaylists(segments []segment, flags Flags) error {
+	if flags.Playlist == "none" && !flags.HTMLPlayer {
+		return nil
+	}
+	base := strings.TrimSuffix(flags.OutputFile, filepath.Ext(flags.OutputFile))
+	title := cmp.Or(flags.Title, filepath.Base(base))
+	tracks, chapters := playlistTracks(segments, flags)
+
+	switch cmp.Or(flags.Playlist, "m3u8") {
+	case "m3u8":
+		if err := os.WriteFile(base+".m3u8", []byte(m3uPlaylist(title, tracks)), 0o644); err != nil {
+			return fmt.Errorf("unable to write playlist: %w", err)
+		}
+	case "pls":
+		if err := os.WriteFile(base+".pls", []byte(plsPlaylist(tracks)), 0o644); err != nil {
+			return fmt.Errorf("unable to write playlist: %w", err)
+		}
+	}
+	if flags.HTMLPlayer {
+		return writeHTMLPlayer(base+".html", title, tracks, chapters)
+	}
+	return nil
+}
+
+// playlistTracks describes each chunk file, titled after the heading it
+// belongs to, and the headings where chapters start. Chunk files are named
+// relative to the playlist. Pauses are left out, as they are only heard when
+// files are combined.
+func playlistTracks(segments []segment, flags Flags) ([]playlistTrack, []playlistChapter) {
+	dir := filepath.Dir(flags.OutputFile)
+	headings := chunkHeadings(segments)
+	slugs := chunkSlugs(segments)
+	buffer := bufferFor(flags)
+
+	var tracks []playlistTrack
+	var chapters []playlistChapter
+	sectionTrack := 0
+	previous := ""
+	for i, segment := range segments {
+		if segment.Pause > 0 {
+			continue
+		}
+		file := chunkFileName(flags, segments, i, slugs[i])
+		duration, err := mediaDuration(file, flags.FormatOption)
+		if err != nil {
+			slog.Warn("Unable to measure chunk for the playlist", "file", file, "error", err)
+			duration = -1
+		}
+		if relative, err := filepath.Rel(dir, file); err == nil {
+			file = relative
+		}
+
+		title := headings[i]
+		if len(tracks) == 0 || title != previous {
+			sectionTrack = len(tracks)
+			if title != "" {
+				chapters = append(chapters, playlistChapter{Title: title, Track: len(tracks)})
+			}
+		}
+		previous = title
+		switch {
+		case title == "":
+			title = fmt.Sprintf("Part %d", len(tracks)+1)
+		case len(tracks) > sectionTrack:
+			title = fmt.Sprintf("%s (%d)", title, len(tracks)-sectionTrack+1)
+		}
+		tracks = append(tracks, playlistTrack{
+			Title:    title,
+			File:     filepath.ToSlash(file),
+			Duration: duration,
+			Text:     strings.TrimSpace(cmp.Or(segment.Source, buffer.Strip(segment.Text))),
+		})
+	}
+	return tracks, chapters
+}
+
+// playlistSeconds rounds a track's duration up to whole seconds, or returns
+// -1 when it is unknown.
+func playlistSeconds(d time.Duration) int {
+	if d < 0 {
+		return -1
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// m3uPlaylist writes an extended M3U playlist in UTF-8.
+func m3uPlaylist(title string, tracks []playlistTrack) string {
+	var playlist strings.Builder
+	fmt.Fprintf(&playlist, "#EXTM3U\n#PLAYLIST:%s\n", title)
+	for _, track := range tracks {
+		fmt.Fprintf(&playlist, "#EXTINF:%d,%s\n%s\n", playlistSeconds(track.Duration), track.Title, track.File)
+	}
+	return playlist.String()
+}
+
+func plsPlaylist(tracks []playlistTrack) string {
+	var playlist strings.Builder
+	playlist.WriteString("[playlist]\n")
+	for i, track := range tracks {
+		fmt.Fprintf(&playlist, "File%d=%s\nTitle%d=%s\nLength%d=%d\n", i+1, track.File, i+1, track.Title, i+1, playlistSeconds(track.Duration))
+	}
+	fmt.Fprintf(&playlist, "NumberOfEntries=%d\nVersion=2\n", len(tracks))
+	return playlist.String()
+}
+
+var htmlPlayer = template.Must(template.New("player").Funcs(template.FuncMap{
+	"duration": func(d time.Duration) string {
+		if d < 0 {
+			return ""
+		}
+		seconds := int(d.Round(time.Second).Seconds())
+		return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: system-ui, sans-serif; max-width: 48rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.5; }
+audio { width: 100%; position: sticky; top: 0; background: #fff; }
+section { padding: 0.5rem 0.75rem; border-left: 3px solid transparent; }
+section.playing { border-left-color: #3b82f6; background: #f0f6ff; }
+.text { white-space: pre-wrap; }
+.length { color: #666; font-size: 0.9em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<audio id="player" controls preload="none" src="{{(index .Tracks 0).File}}"></audio>
+{{- if .Chapters}}
+<nav>
+<h2>Chapters</h2>
+<ol>
+{{- range .Chapters}}
+<li><a href="#track-{{.Track}}" data-track="{{.Track}}">{{.Title}}</a></li>
+{{- end}}
+</ol>
+</nav>
+{{- end}}
+<main>
+{{- range $i, $track := .Tracks}}
+<section id="track-{{$i}}" data-src="{{$track.File}}">
+<h3><a href="#track-{{$i}}" data-track="{{$i}}">{{$track.Title}}</a> <span class="length">{{duration $track.Duration}}</span></h3>
+<div class="text">{{$track.Text}}</div>
+</section>
+{{- end}}
+</main>
+<script>
+const player = document.getElementById("player");
+const sections = Array.from(document.querySelectorAll("section"));
+let current = 0;
+function play(index) {
+  if (index >= sections.length) return;
+  sections[current].classList.remove("playing");
+  current = index;
+  sections[current].classList.add("playing");
+  player.src = sections[current].dataset.src;
+  player.play();
+}
+document.querySelectorAll("[data-track]").forEach(link => link.addEventListener("click", event => {
+  event.preventDefault();
+  play(Number(link.dataset.track));
+}));
+player.addEventListener("ended", () => play(current + 1));
+</script>
+</body>
+</html>
+`))
+
+// writeHTMLPlayer writes a static page that plays the tracks in order, with a
+// chapter list and the text of each track.
+func writeHTMLPlayer(name, title string, tracks []playlistTrack, chapters []playlistChapter) error {
+	if len(tracks) == 0 {
+		return nil
+	}
+	var page strings.Builder
+	data := struct {
+		Title    string
+		Tracks   []playlistTrack
+		Chapters []playlistChapter
+	}{title, tracks, chapters}
+	if err := htmlPlayer.Execute(&page, data); err != nil {
+		return fmt.Errorf("unable to render HTML player: %w", err)
+	}
+	if err := os.WriteFile(name, []byte(page.String()), 0o644); err != nil {
+		return fmt.Errorf("unable to write HTML player: %w", err)
+	}
+	return nil
+}
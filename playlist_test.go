@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlaylistTracks(t *testing.T) {
+	dir := t.TempDir()
+	flags := Flags{OutputFile: filepath.Join(dir, "guide.wav"), FormatOption: "wav"}
+	segments := []segment{{Text: "Preface."}, {Text: "# Setup\nInstall it."}, {Pause: time.Second}, {Text: "Then run it two times.", Source: "Then run it 2 times."}, {Text: "# Use\nSpeak."}}
+	for i := range segments {
+		if segments[i].Pause > 0 {
+			continue
+		}
+		if err := os.WriteFile(chunkFileName(flags, segments, i, ""), makeWAV(24000, 1, 16, 24000*(i+1), uint32(48000*(i+1))), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tracks, chapters := playlistTracks(segments, flags)
+	var titles []string
+	for _, track := range tracks {
+		titles = append(titles, track.Title)
+	}
+	if expected := []string{"Part 1", "Setup", "Setup (2)", "Use"}; !reflect.DeepEqual(titles, expected) {
+		t.Errorf("Expected titles %q, got %q", expected, titles)
+	}
+	if expected := []playlistChapter{{Title: "Setup", Track: 1}, {Title: "Use", Track: 3}}; !reflect.DeepEqual(chapters, expected) {
+		t.Errorf("Expected chapters %+v, got %+v", expected, chapters)
+	}
+	if tracks[2].File != "guide_4.wav" || tracks[2].Duration != 4*time.Second || tracks[2].Text != "Then run it 2 times." {
+		t.Errorf("Unexpected track %+v", tracks[2])
+	}
+
+	flags.HTMLPlayer = true
+	if err := writePlaylists(segments, flags); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "guide.html")); err != nil {
+		t.Errorf("Expected the player to be named after the output: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "index.html")); err == nil {
+		t.Errorf("Expected no index.html to be written")
+	}
+	playlist, _ := os.ReadFile(filepath.Join(dir, "guide.m3u8"))
+	if strings.Count(string(playlist), "#EXTINF") != 4 {
+		t.Errorf("Expected the pause to be left out of the playlist, got %s", playlist)
+	}
+}
+
+func TestM3UPlaylist(t *testing.T) {
+	tracks := []playlistTrack{
+		{Title: "Setup", File: "guide_1.mp3", Duration: 1500 * time.Millisecond},
+		{Title: "Use", File: "chunks/guide_2.mp3", Duration: -1},
+	}
+	expected := "#EXTM3U\n#PLAYLIST:Guide\n#EXTINF:2,Setup\nguide_1.mp3\n#EXTINF:-1,Use\nchunks/guide_2.mp3\n"
+	if playlist := m3uPlaylist("Guide", tracks); playlist != expected {
+		t.Errorf("Expected %q, got %q", expected, playlist)
+	}
+
+	expected = "[playlist]\nFile1=guide_1.mp3\nTitle1=Setup\nLength1=2\nFile2=chunks/guide_2.mp3\nTitle2=Use\nLength2=-1\nNumberOfEntries=2\nVersion=2\n"
+	if playlist := plsPlaylist(tracks); playlist != expected {
+		t.Errorf("Expected %q, got %q", expected, playlist)
+	}
+}
+
+func TestWriteHTMLPlayer(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "index.html")
+	tracks := []playlistTrack{{Title: "Setup", File: "guide 1.mp3", Duration: 75 * time.Second, Text: "Run <b>it</b> & wait."}}
+	if err := writeHTMLPlayer(name, "Guide", tracks, []playlistChapter{{Title: "Setup", Track: 0}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(data)
+	for _, expected := range []string{
+		"<title>Guide</title>",
+		`data-src="guide%201.mp3"`,
+		`<a href="#track-0" data-track="0">Setup</a></li>`,
+		`<span class="length">1:15</span>`,
+		"Run &lt;b&gt;it&lt;/b&gt; &amp; wait.",
+	} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected the page to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	progressBarWidth      = 20
+	progressLiveInterval  = 200 * time.Millisecond
+	progressPrintInterval = 10 * time.Second
+)
+
+type progress struct {
+	mu          sync.Mutex
+	out         io.Writer
+	live        bool
+	interval    time.Duration
+	now         func() time.Time
+	totalChunks int
+	totalChars  int
+	chunk       int
+	charsDone   int
+	chunkBytes  int64
+	start       time.Time
+	stop        chan struct{}
+	stopped     sync.WaitGroup
+}
+
+func newProgress(out io.Writer, chunks []string, live bool) *progress {
+	interval := progressPrintInterval
+	if live {
+		interval = progressLiveInterval
+	}
+
+	totalChars := 0
+	for _, chunk := range chunks {
+		totalChars += utf8.RuneCountInString(chunk)
+	}
+
+	return &progress{
+		out:         out,
+		live:        live,
+		interval:    interval,
+		now:         time.Now,
+		totalChunks: len(chunks),
+		totalChars:  totalChars,
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func (p *progress) begin() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.start = p.now()
+	p.stop = make(chan struct{})
+	p.mu.Unlock()
+
+	p.stopped.Add(1)
+	go func() {
+		defer p.stopped.Done()
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.mu.Lock()
+				p.render()
+				p.mu.Unlock()
+			}
+		}
+	}()
+}
+
+func (p *progress) startChunk(index int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.chunk = index
+	p.chunkBytes = 0
+	if p.live {
+		p.render()
+	}
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.chunkBytes += int64(len(b))
+	return len(b), nil
+}
+
+func (p *progress) finishChunk(chars int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.charsDone += chars
+	p.render()
+}
+
+func (p *progress) finish() {
+	if p == nil || p.stop == nil {
+		return
+	}
+	close(p.stop)
+	p.stopped.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.live {
+		_, _ = fmt.Fprintln(p.out)
+	}
+}
+
+func (p *progress) render() {
+	if p.live {
+		_, _ = fmt.Fprintf(p.out, "\r%s\x1b[K", p.line())
+		return
+	}
+	elapsed := p.now().Sub(p.start)
+	eta := "unknown"
+	if remaining, ok := p.eta(elapsed); ok {
+		eta = formatClock(remaining)
+	}
+	slog.Info("Progress",
+		"chunk", fmt.Sprintf("%d/%d", p.chunk, p.totalChunks),
+		"chars", fmt.Sprintf("%d/%d", p.charsDone, p.totalChars),
+		"received", formatBytes(p.chunkBytes),
+		"elapsed", formatClock(elapsed),
+		"eta", eta,
+	)
+}
+
+func (p *progress) line() string {
+	elapsed := p.now().Sub(p.start)
+	eta := "--:--"
+	if remaining, ok := p.eta(elapsed); ok {
+		eta = formatClock(remaining)
+	}
+
+	return fmt.Sprintf("%s chunk %d/%d | %d/%d chars | %s | elapsed %s | ETA %s",
+		progressBar(p.charsDone, p.totalChars, progressBarWidth),
+		p.chunk, p.totalChunks, p.charsDone, p.totalChars, formatBytes(p.chunkBytes), formatClock(elapsed), eta)
+}
+
+func (p *progress) eta(elapsed time.Duration) (time.Duration, bool) {
+	if p.charsDone == 0 || p.totalChars == 0 {
+		return 0, false
+	}
+	remaining := p.totalChars - p.charsDone
+	if remaining <= 0 {
+		return 0, true
+	}
+	return time.Duration(float64(elapsed) * float64(remaining) / float64(p.charsDone)), true
+}
+
+func progressBar(done, total, width int) string {
+	filled := 0
+	if total > 0 {
+		filled = done * width / total
+	}
+	if filled > width {
+		filled = width
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatClock(d time.Duration) string {
+	d = d.Round(time.Second)
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	s := int(d % time.Minute / time.Second)
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
+}
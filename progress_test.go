@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[int64]string{
+		0:               "0 B",
+		512:             "512 B",
+		1024:            "1.0 KB",
+		1536:            "1.5 KB",
+		5 * 1024 * 1024: "5.0 MB",
+	}
+	for input, expected := range tests {
+		if output := formatBytes(input); output != expected {
+			t.Errorf("Expected formatBytes(%d) to be '%s', got '%s'", input, expected, output)
+		}
+	}
+}
+
+func TestFormatClock(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                 "00:00",
+		32 * time.Second:  "00:32",
+		105 * time.Second: "01:45",
+		time.Hour + 2*time.Minute + 3*time.Second: "1:02:03",
+	}
+	for input, expected := range tests {
+		if output := formatClock(input); output != expected {
+			t.Errorf("Expected formatClock(%v) to be '%s', got '%s'", input, expected, output)
+		}
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	if bar := progressBar(0, 100, 10); bar != "[          ]" {
+		t.Errorf("Expected empty bar, got '%s'", bar)
+	}
+	if bar := progressBar(50, 100, 10); bar != "[=====     ]" {
+		t.Errorf("Expected half bar, got '%s'", bar)
+	}
+	if bar := progressBar(150, 100, 10); bar != "[==========]" {
+		t.Errorf("Expected full bar, got '%s'", bar)
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(30 * time.Second)
+	p := &progress{
+		out:         &bytes.Buffer{},
+		now:         func() time.Time { return now },
+		start:       start,
+		totalChunks: 4,
+		totalChars:  4000,
+	}
+
+	p.startChunk(2)
+	_, _ = p.Write(make([]byte, 2048))
+	p.charsDone = 1000
+
+	line := p.line()
+	expected := "[=====               ] chunk 2/4 | 1000/4000 chars | 2.0 KB | elapsed 00:30 | ETA 01:30"
+	if line != expected {
+		t.Errorf("Expected line '%s', got '%s'", expected, line)
+	}
+}
+
+func TestProgressETAUnknown(t *testing.T) {
+	p := &progress{totalChars: 100}
+	if _, ok := p.eta(time.Second); ok {
+		t.Errorf("Expected ETA to be unknown before any characters are processed")
+	}
+}
+
+func TestProgressNil(t *testing.T) {
+	var p *progress
+	p.begin()
+	p.startChunk(1)
+	p.finishChunk(10)
+	p.finish()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+const (
+	minSpeed        = 0.25
+	maxSpeed        = 4.0
+	defaultCacheTTL = 7 * 24 * time.Hour
+)
+
+type openAISpeechRequest struct {
+	Model          string      `json:"model"`
+	Input          string      `json:"input"`
+	Voice          string      `json:"voice"`
+	ResponseFormat string      `json:"response_format"`
+	Speed          json.Number `json:"speed"`
+	Instructions   string      `json:"instructions"`
+}
+
+type audioCache struct {
+	dir string
+	ttl time.Duration
+}
+
+func (s *server) handleAudioSpeech(w http.ResponseWriter, r *http.Request) {
+	var body openAISpeechRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBytes)).Decode(&body); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	req, err := body.speechRequest()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key := cacheKey(req)
+	if path, ok := s.cache.lookup(key, req.Format); ok {
+		w.Header().Set("X-Cache", "HIT")
+		serveAudio(w, r, path, req.Format)
+		return
+	}
+	w.Header().Set("X-Cache", "MISS")
+
+	chunks := synth.SizeChunker{}.Chunk(req.Text)
+	if len(chunks) == 1 || streamable(req.Format) {
+		s.streamToCache(w, r, req, chunks, key)
+		return
+	}
+
+	base := s.options.JobDir
+	if s.cache != nil {
+		base = s.cache.dir
+	}
+	dir, err := os.MkdirTemp(base, "proxy-")
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	output := filepath.Join(dir, "speech."+req.Format)
+	if err := s.render(r.Context(), req, chunks, output); err != nil {
+		writeOpenAIError(w, statusForError(err), err.Error())
+		return
+	}
+	if s.cache != nil {
+		if cached, err := s.cache.store(output, key, req.Format); err == nil {
+			output = cached
+		} else {
+			slog.Warn("Unable to cache audio", "error", err)
+		}
+	}
+	serveAudio(w, r, output, req.Format)
+}
+
+func (s *server) streamToCache(w http.ResponseWriter, r *http.Request, req speechRequest, chunks []string, key string) {
+	tmp, err := s.cache.create(key)
+	if err != nil {
+		slog.Warn("Unable to cache audio", "error", err)
+	}
+
+	var tee io.Writer = io.Discard
+	if tmp != nil {
+		tee = tmp
+	}
+	err = s.stream(w, r, req, chunks, tee, writeOpenAIError)
+
+	if tmp == nil {
+		return
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if _, err := s.cache.store(tmp.Name(), key, req.Format); err != nil {
+		slog.Warn("Unable to cache audio", "error", err)
+		_ = os.Remove(tmp.Name())
+	}
+}
+
+func (body openAISpeechRequest) speechRequest() (speechRequest, error) {
+	req := speechRequest{
+		Text:   body.Input,
+		Voice:  body.Voice,
+		Model:  body.Model,
+		Format: body.ResponseFormat,
+		Speed:  body.Speed.String(),
+
+		Instructions: body.Instructions,
+	}
+	if req.Speed != "" {
+		speed, err := strconv.ParseFloat(req.Speed, 64)
+		if err != nil || speed < minSpeed || speed > maxSpeed {
+			return req, fmt.Errorf("speed must be between %.2f and %.1f", minSpeed, maxSpeed)
+		}
+	}
+	if err := req.normalize(Flags{}); err != nil {
+		return req, err
+	}
+	return req, nil
+}
+
+func cacheKey(req speechRequest) string {
+	return requestKey(TTSRequest{
+		Model:  req.Model,
+		Voice:  req.Voice,
+		Format: req.Format,
+		Speed:  req.Speed,
+		Input:  req.Text,
+
+		Instructions: req.Instructions,
+	})
+}
+
+// requestKey identifies the audio a request produces, so equal requests can
+// share a cached file.
+func requestKey(ttsRequest TTSRequest) string {
+	speed := ttsRequest.Speed
+	if parsed, err := strconv.ParseFloat(speed, 64); err == nil {
+		speed = strconv.FormatFloat(parsed, 'f', -1, 64)
+	}
+	fields := []string{ttsRequest.Model, ttsRequest.Voice, ttsRequest.Format, speed, ttsRequest.Input}
+	if ttsRequest.Instructions != "" {
+		fields = append(fields, ttsRequest.Instructions)
+	}
+	hash := sha256.New()
+	for _, field := range fields {
+		_, _ = fmt.Fprintf(hash, "%d:%s\n", len(field), field)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, message string) {
+	errorType := "server_error"
+	if status >= 400 && status < 500 {
+		errorType = "invalid_request_error"
+	}
+	writeJSON(w, status, map[string]any{
+		"error": map[string]any{
+			"message": message,
+			"type":    errorType,
+			"param":   nil,
+			"code":    nil,
+		},
+	})
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "tts-cache")
+	}
+	return filepath.Join(dir, "tts")
+}
+
+func newAudioCache(dir string, ttl time.Duration) (*audioCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create cache directory: %w", err)
+	}
+	return &audioCache{dir: dir, ttl: ttl}, nil
+}
+
+func (c *audioCache) path(key, format string) string {
+	return filepath.Join(c.dir, key+"."+format)
+}
+
+func (c *audioCache) lookup(key, format string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	path := c.path(key, format)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return path, true
+}
+
+func (c *audioCache) create(key string) (*os.File, error) {
+	if c == nil {
+		return nil, nil
+	}
+	file, err := os.CreateTemp(c.dir, key+"-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create cache file: %w", err)
+	}
+	return file, nil
+}
+
+func (c *audioCache) store(source, key, format string) (string, error) {
+	if c == nil {
+		return "", fmt.Errorf("cache is disabled")
+	}
+	path := c.path(key, format)
+	if err := os.Rename(source, path); err != nil {
+		return "", fmt.Errorf("unable to store cache file: %w", err)
+	}
+	return path, nil
+}
+
+func (c *audioCache) expire(now time.Time) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		slog.Warn("Unable to read cache directory", "error", err)
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || now.Sub(info.ModTime()) < c.ttl {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.dir, entry.Name())); err != nil {
+			slog.Warn("Unable to remove cached audio", "file", entry.Name(), "error", err)
+		}
+	}
+}
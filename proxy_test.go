@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudioSpeechProxy(t *testing.T) {
+	var inputs []openAISpeechRequest
+	s := newTestServer(t, func(req *http.Request) (*http.Response, error) {
+		var body openAISpeechRequest
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		inputs = append(inputs, body)
+		return mockAudio(req)
+	})
+	cache, err := newAudioCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	s.cache = cache
+	handler := s.routes()
+
+	long := strings.Repeat("word ", 1000)
+	body := `{"model": "tts-1", "voice": "alloy", "input": "` + long + `", "speed": 1.25}`
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/audio/speech", strings.NewReader(body)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("Expected input over %d characters to be sent as 2 requests, got %d", api_max_chars, len(inputs))
+	}
+	if inputs[0].Speed.String() != "1.25" || inputs[0].Voice != "alloy" {
+		t.Errorf("Expected request options to be forwarded, got %+v", inputs[0])
+	}
+	if rec.Body.String() != "Mock audio dataMock audio data" || rec.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("Expected combined audio on cache miss, got '%s' (%s)", rec.Body.String(), rec.Header().Get("X-Cache"))
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/audio/speech", strings.NewReader(body)))
+	if rec.Header().Get("X-Cache") != "HIT" || len(inputs) != 2 {
+		t.Errorf("Expected second request to be served from cache, got %s after %d requests", rec.Header().Get("X-Cache"), len(inputs))
+	}
+	if rec.Body.String() != "Mock audio dataMock audio data" {
+		t.Errorf("Expected cached audio, got '%s'", rec.Body.String())
+	}
+}
+
+func TestAudioSpeechProxyInstructions(t *testing.T) {
+	var inputs []openAISpeechRequest
+	s := newTestServer(t, func(req *http.Request) (*http.Response, error) {
+		var body openAISpeechRequest
+		_ = json.NewDecoder(req.Body).Decode(&body)
+		inputs = append(inputs, body)
+		return mockAudio(req)
+	})
+	cache, err := newAudioCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	s.cache = cache
+	handler := s.routes()
+
+	bodies := []string{
+		`{"model": "gpt-4o-mini-tts", "voice": "alloy", "input": "Hello", "instructions": "Speak calmly."}`,
+		`{"model": "gpt-4o-mini-tts", "voice": "alloy", "input": "Hello", "instructions": "Speak cheerfully."}`,
+	}
+	for i, body := range bodies {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/audio/speech", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec.Header().Get("X-Cache") != "MISS" {
+			t.Errorf("Expected request %d with its own instructions to miss the cache, got %s", i+1, rec.Header().Get("X-Cache"))
+		}
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("Expected 2 upstream requests, got %d", len(inputs))
+	}
+	if inputs[0].Instructions != "Speak calmly." || inputs[1].Instructions != "Speak cheerfully." {
+		t.Errorf("Expected instructions to be forwarded, got %q and %q", inputs[0].Instructions, inputs[1].Instructions)
+	}
+}
+
+func TestAudioSpeechProxyErrors(t *testing.T) {
+	s := newTestServer(t, func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader("Bad voice")),
+		}, nil
+	})
+	cacheDir := t.TempDir()
+	cache, err := newAudioCache(cacheDir, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	s.cache = cache
+
+	tests := map[string]int{
+		`{"input": "Hello", "speed": 9}`:      http.StatusBadRequest,
+		`{"input": "Hello", "speed": "fast"}`: http.StatusBadRequest,
+		`{"input": ""}`:                       http.StatusBadRequest,
+		`{"input": "Hello", "voice": "nope"}`: http.StatusBadRequest,
+		`{"input": "Hello", "speed": "1.5"}`:  http.StatusBadRequest,
+	}
+	for body, expected := range tests {
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/audio/speech", strings.NewReader(body)))
+		if rec.Code != expected {
+			t.Errorf("Expected status %d for %s, got %d", expected, body, rec.Code)
+		}
+		var response struct {
+			Error struct {
+				Message string `json:"message"`
+				Type    string `json:"type"`
+			} `json:"error"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Error.Type != "invalid_request_error" {
+			t.Errorf("Expected OpenAI error body for %s, got '%s'", body, rec.Body.String())
+		}
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatalf("Failed to read cache directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Expected failed responses not to be cached, found %d files", len(entries))
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	a := cacheKey(speechRequest{Text: "hi", Voice: "nova", Model: "tts-1", Format: "mp3", Speed: "1"})
+	b := cacheKey(speechRequest{Text: "hi", Voice: "nova", Model: "tts-1", Format: "mp3", Speed: "1.0"})
+	c := cacheKey(speechRequest{Text: "hi", Voice: "onyx", Model: "tts-1", Format: "mp3", Speed: "1.0"})
+	if a != b {
+		t.Errorf("Expected equivalent speeds to share a cache key")
+	}
+	if a == c {
+		t.Errorf("Expected different voices to have different cache keys")
+	}
+}
+
+func TestAudioCacheExpire(t *testing.T) {
+	cache, err := newAudioCache(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	path := cache.path("key", "mp3")
+	if err := os.WriteFile(path, []byte("audio"), 0o644); err != nil {
+		t.Fatalf("Failed to write cache file: %v", err)
+	}
+
+	cache.expire(time.Now())
+	if _, ok := cache.lookup("key", "mp3"); !ok {
+		t.Errorf("Expected fresh cache entry to be kept")
+	}
+	cache.expire(time.Now().Add(2 * time.Hour))
+	if _, ok := cache.lookup("key", "mp3"); ok {
+		t.Errorf("Expected stale cache entry to be removed")
+	}
+}
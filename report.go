@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+type runReport struct {
+	mu               sync.Mutex
+	Tool             string        `json:"tool"`
+	Version          string        `json:"version"`
+	InputFile        string        `json:"input_file"`
+	Model            string        `json:"model"`
+	Voice            string        `json:"voice"`
+	Format           string        `json:"format"`
+	Speed            string        `json:"speed"`
+	StartedAt        time.Time     `json:"started_at"`
+	FinishedAt       time.Time     `json:"finished_at"`
+	Chunks           []chunkReport `json:"chunks"`
+	Combined         *fileReport   `json:"combined,omitempty"`
+	Parts            []fileReport  `json:"parts,omitempty"`
+	BilledCharacters int           `json:"billed_characters"`
+	Error            string        `json:"error,omitempty"`
+}
+
+type chunkReport struct {
+	Index           int     `json:"index"`
+	Characters      int     `json:"characters"`
+	SHA256          string  `json:"sha256"`
+	Output          string  `json:"output"`
+	Bytes           int64   `json:"bytes"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	LatencyMS       int64   `json:"latency_ms"`
+	Retries         int     `json:"retries"`
+	RequestID       string  `json:"request_id,omitempty"`
+	Reused          bool    `json:"reused,omitempty"`
+}
+
+type fileReport struct {
+	Title           string  `json:"title,omitempty"`
+	Output          string  `json:"output"`
+	Bytes           int64   `json:"bytes"`
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+}
+
+func newRunReport(flags Flags) *runReport {
+	return &runReport{
+		Tool:      tool,
+		Version:   version,
+		InputFile: flags.InputFile,
+		Model:     flags.ModelOption,
+		Voice:     flags.VoiceOption,
+		Format:    flags.FormatOption,
+		Speed:     flags.SpeedOption,
+		StartedAt: time.Now().UTC(),
+		Chunks:    []chunkReport{},
+	}
+}
+
+func (r *runReport) addChunk(index int, ttsRequest TTSRequest, outputFileName string, response synth.Result) {
+	if r == nil {
+		return
+	}
+	characters := utf8.RuneCountInString(ttsRequest.Input)
+	hash := sha256.Sum256([]byte(ttsRequest.Input))
+
+	chunk := chunkReport{
+		Index:      index,
+		Characters: characters,
+		SHA256:     hex.EncodeToString(hash[:]),
+		Output:     outputFileName,
+		Bytes:      response.Bytes,
+		LatencyMS:  response.Latency.Milliseconds(),
+		Retries:    response.Retries,
+		RequestID:  response.RequestID,
+	}
+	if duration, err := audioDuration(outputFileName, ttsRequest.Format); err == nil {
+		chunk.DurationSeconds = duration.Seconds()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Chunks = append(r.Chunks, chunk)
+	r.BilledCharacters += characters
+}
+
+// addReusedChunk records a chunk an incremental build kept from the previous
+// run. It was not sent to the API, so it is not billed.
+func (r *runReport) addReusedChunk(index int, ttsRequest TTSRequest, outputFileName string) {
+	if r == nil {
+		return
+	}
+	hash := sha256.Sum256([]byte(ttsRequest.Input))
+	chunk := chunkReport{
+		Index:      index,
+		Characters: utf8.RuneCountInString(ttsRequest.Input),
+		SHA256:     hex.EncodeToString(hash[:]),
+		Output:     outputFileName,
+		Reused:     true,
+	}
+	if info, err := os.Stat(outputFileName); err == nil {
+		chunk.Bytes = info.Size()
+	}
+	if duration, err := audioDuration(outputFileName, ttsRequest.Format); err == nil {
+		chunk.DurationSeconds = duration.Seconds()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Chunks = append(r.Chunks, chunk)
+}
+
+func (r *runReport) setCombined(outputFileName, format string) {
+	if r == nil {
+		return
+	}
+	combined := &fileReport{Output: outputFileName}
+	if info, err := os.Stat(outputFileName); err == nil {
+		combined.Bytes = info.Size()
+	}
+	if duration, err := audioDuration(outputFileName, format); err == nil {
+		combined.DurationSeconds = duration.Seconds()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Combined = combined
+}
+
+// addPart records the output of one part of a run with several output files,
+// such as an EPUB chapter, in place of the combined file.
+func (r *runReport) addPart(title, outputFileName, format string) {
+	if r == nil {
+		return
+	}
+	part := fileReport{Title: title, Output: outputFileName}
+	if info, err := os.Stat(outputFileName); err == nil {
+		part.Bytes = info.Size()
+	}
+	if duration, err := audioDuration(outputFileName, format); err == nil {
+		part.DurationSeconds = duration.Seconds()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Parts = append(r.Parts, part)
+	r.Combined = nil
+}
+
+func (r *runReport) finish(runErr error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.FinishedAt = time.Now().UTC()
+	if runErr != nil {
+		r.Error = runErr.Error()
+	}
+}
+
+func (r *runReport) write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("unable to encode report: %w", err)
+	}
+	return nil
+}
+
+func writeReport(report *runReport, flags Flags) error {
+	if flags.JSONReport {
+		if err := report.write(os.Stdout); err != nil {
+			return err
+		}
+	}
+
+	if flags.ReportFile == "" {
+		return nil
+	}
+
+	file, err := os.Create(flags.ReportFile)
+	if err != nil {
+		return fmt.Errorf("unable to create report file: %w", err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	return report.write(file)
+}
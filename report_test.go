@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+func TestRunReport(t *testing.T) {
+	dir := t.TempDir()
+	chunkFile := filepath.Join(dir, "out_1.wav")
+	if err := os.WriteFile(chunkFile, makeWAV(24000, 1, 16, 24000, 48000), 0o644); err != nil {
+		t.Fatalf("Failed to write chunk file: %v", err)
+	}
+
+	flags := Flags{InputFile: "in.md", ModelOption: "tts-1", VoiceOption: "nova", FormatOption: "wav", SpeedOption: "1.0"}
+	report := newRunReport(flags)
+	ttsRequest := TTSRequest{Input: "héllo", Format: "wav"}
+	response := synth.Result{RequestID: "req_123", Latency: 1500 * time.Millisecond, Bytes: 48044}
+	report.addChunk(1, ttsRequest, chunkFile, response)
+	report.setCombined(chunkFile, "wav")
+	report.finish(errors.New("boom"))
+
+	var buf bytes.Buffer
+	if err := report.write(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var decoded runReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if len(decoded.Chunks) != 1 {
+		t.Fatalf("Expected 1 chunk, got %d", len(decoded.Chunks))
+	}
+	chunk := decoded.Chunks[0]
+	if chunk.Characters != 5 || decoded.BilledCharacters != 5 {
+		t.Errorf("Expected 5 characters, got %d (billed %d)", chunk.Characters, decoded.BilledCharacters)
+	}
+	hash := sha256.Sum256([]byte("héllo"))
+	if chunk.SHA256 != hex.EncodeToString(hash[:]) {
+		t.Errorf("Expected SHA-256 %x, got '%s'", hash, chunk.SHA256)
+	}
+	if chunk.RequestID != "req_123" || chunk.LatencyMS != 1500 || chunk.Bytes != 48044 {
+		t.Errorf("Unexpected chunk report: %+v", chunk)
+	}
+	if chunk.DurationSeconds != 1 {
+		t.Errorf("Expected duration 1s, got %v", chunk.DurationSeconds)
+	}
+	if decoded.Combined == nil || decoded.Combined.Output != chunkFile {
+		t.Errorf("Expected combined output %s, got %+v", chunkFile, decoded.Combined)
+	}
+	if decoded.Error != "boom" {
+		t.Errorf("Expected error 'boom', got '%s'", decoded.Error)
+	}
+}
+
+func TestWriteReportFile(t *testing.T) {
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+	report := newRunReport(Flags{})
+	report.finish(nil)
+	if err := writeReport(report, Flags{ReportFile: reportFile}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	data, err := os.ReadFile(reportFile)
+	if err != nil {
+		t.Fatalf("Failed to read report file: %v", err)
+	}
+	if !json.Valid(data) {
+		t.Errorf("Expected valid JSON report, got %s", data)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+// voices is the order in which voices are handed out to speakers that have no
+// voice configured.
+var voices = []string{"alloy", "echo", "fable", "onyx", "nova", "shimmer"}
+
+var speakerLabel = regexp.MustCompile(`^([\p{L}\p{N}][\p{L}\p{N} _.'-]{0,31}):(?:\s+(.*))?$`)
+
+// segment is a piece of text synthesized with its own request parameters.
+// Empty fields fall back to the run's flags.
+type segment struct {
+	Text         string
+	Speaker      string
+	Voice        string
+	Speed        string
+	Instructions string
+	// Source is the chunk as written when the lexicon or normalization
+	// rewrote it, and empty otherwise.
+	Source string
+	// Pause makes this a silent segment of the given length instead of speech.
+	Pause time.Duration
+}
+
+type speaker struct {
+	Voice        string
+	Speed        string
+	Instructions string
+}
+
+type scriptTurn struct {
+	Speaker string
+	Text    string
+}
+
+type dialogueScript struct {
+	Speakers map[string]speaker
+	Turns    []scriptTurn
+}
+
+func textSegments(chunks []string) []segment {
+	segments := make([]segment, len(chunks))
+	for i, chunk := range chunks {
+		segments[i] = segment{Text: chunk}
+	}
+	return segments
+}
+
+func segmentTexts(segments []segment) []string {
+	texts := make([]string, len(segments))
+	for i, segment := range segments {
+		texts[i] = segment.Text
+	}
+	return texts
+}
+
+func (s segment) request(flags Flags) TTSRequest {
+	ttsRequest := TTSRequest{
+		Model:        flags.ModelOption,
+		Voice:        flags.VoiceOption,
+		Format:       flags.FormatOption,
+		Input:        s.Text,
+		Speed:        flags.SpeedOption,
+		Instructions: s.Instructions,
+	}
+	if s.Voice != "" {
+		ttsRequest.Voice = s.Voice
+	}
+	if s.Speed != "" {
+		ttsRequest.Speed = s.Speed
+	}
+	return ttsRequest
+}
+
+// readSegments reads the input file as plain text or, with --script, as a
+// dialogue script, and applies inline markup when --markup is set. YAML
+// front matter at the start of plain text is not spoken.
+func readSegments(flags Flags) ([]segment, error) {
+	chunk, err := loadChunkFunc(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	if !flags.Script && !flags.Markup {
+		var segments []segment
+		chunker := synth.ChunkerFunc(func(text string) []string {
+			_, text = splitFrontMatter(text)
+			segments = chunk(text, segment{})
+			return segmentTexts(segments)
+		})
+		if _, err := readInputFile(flags.InputFile, chunker); err != nil {
+			return nil, err
+		}
+		return applyGaps(segments, flags), nil
+	}
+
+	data, err := os.ReadFile(flags.InputFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open input file: %w", err)
+	}
+
+	var segments []segment
+	if flags.Script {
+		script, err := parseScript(flags.InputFile, data)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse script %s: %w", flags.InputFile, err)
+		}
+		segments, err = script.segments(flags, chunk)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		_, text := splitFrontMatter(string(data))
+		segments, err = splitSegments(text, segment{}, flags, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse markup in %s: %w", flags.InputFile, err)
+		}
+	}
+	return prepareSegments(segments, flags), nil
+}
+
+// loadChunkFunc loads the lexicon and normalization selected by flags.
+func loadChunkFunc(flags Flags) (chunkFunc, error) {
+	lex, err := loadLexicon(flags)
+	if err != nil {
+		return nil, err
+	}
+	norm, err := newNormalizer(flags)
+	if err != nil {
+		return nil, err
+	}
+	return segmentChunker(flags, lex, norm), nil
+}
+
+// prepareSegments drops pauses when files are not combined and inserts gaps
+// when they are.
+func prepareSegments(segments []segment, flags Flags) []segment {
+	if !flags.CombineFiles && !flags.Incremental {
+		if kept := dropPauses(segments); len(kept) != len(segments) {
+			slog.Warn("Pauses are only inserted when combining files with -c")
+			segments = kept
+		}
+	}
+	return applyGaps(segments, flags)
+}
+
+// chunkFunc splits the text of a segment into request sized segments that
+// inherit s. It is given the segment so rewriting can depend on its voice.
+type chunkFunc func(text string, s segment) []segment
+
+// segmentChunker chunks the text as written, then applies the lexicon for the
+// segment's voice and model and normalization to each chunk. Lexicon rules run
+// first so they can override how a number or symbol is spelled out. A chunk
+// that outgrows a request when rewritten is split again. Segments of a
+// rewritten chunk keep the text as written in Source. With --paragraph-gap each
+// paragraph is chunked on its own, so the gap can be inserted between them.
+func segmentChunker(flags Flags, lex *lexicon, norm *normalizer) chunkFunc {
+	chunker := newChunker(flags)
+	buffer := bufferFor(flags)
+	limit := chunkLimit(flags)
+	return func(text string, s segment) []segment {
+		ttsRequest := s.request(flags)
+		var segments []segment
+		for _, paragraph := range gapParagraphs(text, flags) {
+			for _, source := range chunker.Chunk(paragraph) {
+				rewritten := norm.apply(lex.apply(source, ttsRequest.Voice, ttsRequest.Model))
+				s.Source = ""
+				if rewritten != source {
+					s.Source = source
+				}
+				chunks := synth.SplitIntoChunks(rewritten, limit)
+				if flags.BufferTextFlag {
+					chunks = buffer.Wrap(chunks)
+				}
+				for _, chunk := range chunks {
+					s.Text = chunk
+					segments = append(segments, s)
+				}
+			}
+		}
+		return segments
+	}
+}
+
+// splitSegments chunks text into segments that inherit base, applying inline
+// markup when it is enabled.
+func splitSegments(text string, base segment, flags Flags, chunk chunkFunc) ([]segment, error) {
+	if !flags.Markup {
+		return chunk(text, base), nil
+	}
+	return markupSegments(text, base, chunk)
+}
+
+// parseScript parses a YAML script when the file has a .yaml or .yml
+// extension and a labeled script otherwise.
+func parseScript(name string, data []byte) (dialogueScript, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		return parseYAMLScript(data)
+	}
+	return parseLabeledScript(string(data))
+}
+
+// parseLabeledScript parses lines such as "ALICE: Hello." Lines without a
+// label continue the previous turn and lines starting with # are comments.
+func parseLabeledScript(text string) (dialogueScript, error) {
+	script := dialogueScript{Speakers: make(map[string]speaker)}
+	var current *scriptTurn
+
+	for i, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if match := speakerLabel.FindStringSubmatch(trimmed); match != nil {
+			script.Turns = append(script.Turns, scriptTurn{Speaker: strings.TrimSpace(match[1]), Text: match[2]})
+			current = &script.Turns[len(script.Turns)-1]
+			continue
+		}
+		if trimmed == "" {
+			if current != nil && current.Text != "" {
+				current.Text += "\n"
+			}
+			continue
+		}
+		if current == nil {
+			return script, fmt.Errorf("line %d: text before the first speaker label", i+1)
+		}
+		if current.Text != "" && !strings.HasSuffix(current.Text, "\n") {
+			current.Text += " "
+		}
+		current.Text += trimmed
+	}
+
+	for i := range script.Turns {
+		script.Turns[i].Text = strings.TrimSpace(script.Turns[i].Text)
+	}
+	return script, script.validate()
+}
+
+// parseYAMLScript parses a script of the form
+//
+//	speakers:
+//	  ALICE: {voice, speed, instructions}
+//	script:
+//	  - ALICE: Hello.
+//	  - speaker: BOB
+//	    text: Hi.
+func parseYAMLScript(data []byte) (dialogueScript, error) {
+	script := dialogueScript{Speakers: make(map[string]speaker)}
+
+	document, err := parseYAML(data)
+	if err != nil {
+		return script, err
+	}
+	root, ok := document.(map[string]any)
+	if !ok {
+		return script, fmt.Errorf("expected a mapping with speakers and script")
+	}
+
+	speakers, ok := root["speakers"].(map[string]any)
+	if !ok && root["speakers"] != nil && root["speakers"] != "" {
+		return script, fmt.Errorf("speakers must be a mapping")
+	}
+	for name, value := range speakers {
+		fields, ok := value.(map[string]any)
+		if !ok {
+			// "ALICE: nova" is shorthand for a voice.
+			voice, _ := value.(string)
+			fields = map[string]any{"voice": voice}
+		}
+		s := speaker{}
+		for key, field := range fields {
+			text, ok := field.(string)
+			if !ok {
+				return script, fmt.Errorf("speaker %s: %s must be a string", name, key)
+			}
+			switch key {
+			case "voice":
+				s.Voice = text
+			case "speed":
+				s.Speed = text
+			case "instructions":
+				s.Instructions = text
+			default:
+				return script, fmt.Errorf("speaker %s: unknown field %q", name, key)
+			}
+		}
+		script.Speakers[speakerKey(name)] = s
+	}
+
+	turns, ok := root["script"].([]any)
+	if !ok {
+		return script, fmt.Errorf("script must be a list of turns")
+	}
+	for i, item := range turns {
+		fields, ok := item.(map[string]any)
+		if !ok {
+			return script, fmt.Errorf("script item %d: expected \"SPEAKER: text\"", i+1)
+		}
+		turn, err := yamlTurn(fields)
+		if err != nil {
+			return script, fmt.Errorf("script item %d: %w", i+1, err)
+		}
+		script.Turns = append(script.Turns, turn)
+	}
+
+	return script, script.validate()
+}
+
+func yamlTurn(fields map[string]any) (scriptTurn, error) {
+	if name, ok := fields["speaker"]; ok {
+		speakerName, _ := name.(string)
+		text, _ := fields["text"].(string)
+		if len(fields) != 2 {
+			return scriptTurn{}, fmt.Errorf("expected only speaker and text")
+		}
+		return scriptTurn{Speaker: speakerName, Text: text}, nil
+	}
+	if len(fields) != 1 {
+		return scriptTurn{}, fmt.Errorf("expected a single \"SPEAKER: text\" entry")
+	}
+	for name, value := range fields {
+		text, ok := value.(string)
+		if !ok {
+			return scriptTurn{}, fmt.Errorf("text for %s must be a string", name)
+		}
+		return scriptTurn{Speaker: name, Text: text}, nil
+	}
+	return scriptTurn{}, nil
+}
+
+func (s dialogueScript) validate() error {
+	if len(s.Turns) == 0 {
+		return fmt.Errorf("script has no turns")
+	}
+	for i, turn := range s.Turns {
+		if strings.TrimSpace(turn.Speaker) == "" {
+			return fmt.Errorf("turn %d has no speaker", i+1)
+		}
+	}
+	return nil
+}
+
+// segments resolves each turn's speaker and splits long turns with chunk.
+func (s dialogueScript) segments(flags Flags, chunk chunkFunc) ([]segment, error) {
+	cast, err := s.cast(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []segment
+	for i, turn := range s.Turns {
+		text := strings.TrimSpace(turn.Text)
+		if text == "" {
+			continue
+		}
+		speaker := cast[speakerKey(turn.Speaker)]
+		turnSegments, err := splitSegments(text, segment{
+			Speaker:      turn.Speaker,
+			Voice:        speaker.Voice,
+			Speed:        speaker.Speed,
+			Instructions: speaker.Instructions,
+		}, flags, chunk)
+		if err != nil {
+			return nil, fmt.Errorf("turn %d: %w", i+1, err)
+		}
+		segments = append(segments, turnSegments...)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("script has no text to synthesize")
+	}
+	return segments, nil
+}
+
+// cast merges speakers defined in the script with --speaker flags and hands
+// out unused voices to the remaining speakers in order of appearance.
+func (s dialogueScript) cast(flags Flags) (map[string]speaker, error) {
+	cast := make(map[string]speaker, len(s.Speakers))
+	for name, speaker := range s.Speakers {
+		cast[name] = speaker
+	}
+	for _, value := range flags.Speakers {
+		name, speaker, err := parseSpeakerFlag(value)
+		if err != nil {
+			return nil, err
+		}
+		cast[speakerKey(name)] = speaker
+	}
+
+	used := make(map[string]bool)
+	for _, speaker := range cast {
+		if speaker.Voice != "" {
+			used[speaker.Voice] = true
+		}
+	}
+	next, reuse := 0, 0
+	for _, turn := range s.Turns {
+		key := speakerKey(turn.Speaker)
+		speaker := cast[key]
+		if speaker.Voice != "" {
+			continue
+		}
+		for next < len(voices) && used[voices[next]] {
+			next++
+		}
+		if next < len(voices) {
+			speaker.Voice = voices[next]
+		} else {
+			speaker.Voice = voices[reuse%len(voices)]
+			reuse++
+		}
+		used[speaker.Voice] = true
+		cast[key] = speaker
+		slog.Info("Assigned voice", "speaker", turn.Speaker, "voice", speaker.Voice)
+	}
+
+	for name, speaker := range cast {
+		if speaker.Speed == "" {
+			continue
+		}
+		speed, err := strconv.ParseFloat(speaker.Speed, 64)
+		if err != nil || speed < minSpeed || speed > maxSpeed {
+			return nil, fmt.Errorf("speaker %s: speed must be between %.2f and %.1f", name, minSpeed, maxSpeed)
+		}
+	}
+	return cast, nil
+}
+
+// parseSpeakerFlag parses NAME=VOICE[,speed=S][,instructions=TEXT]. Everything
+// after instructions= belongs to the instructions, so they may contain commas.
+func parseSpeakerFlag(value string) (string, speaker, error) {
+	name, rest, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return "", speaker{}, fmt.Errorf("invalid speaker %q. Usage: --speaker NAME=VOICE[,speed=S][,instructions=TEXT]", value)
+	}
+
+	var s speaker
+	for i, field := range strings.Split(rest, ",") {
+		key, fieldValue, hasKey := strings.Cut(field, "=")
+		switch {
+		case i == 0 && !hasKey:
+			s.Voice = strings.TrimSpace(field)
+		case strings.TrimSpace(key) == "voice":
+			s.Voice = strings.TrimSpace(fieldValue)
+		case strings.TrimSpace(key) == "speed":
+			s.Speed = strings.TrimSpace(fieldValue)
+		case strings.TrimSpace(key) == "instructions":
+			_, s.Instructions, _ = strings.Cut(rest, "instructions=")
+			return name, s, nil
+		default:
+			return "", speaker{}, fmt.Errorf("invalid speaker option %q in %q", field, value)
+		}
+	}
+	return name, s, nil
+}
+
+func speakerKey(name string) string {
+	return strings.ToUpper(strings.TrimSpace(name))
+}
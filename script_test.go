@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLabeledScript(t *testing.T) {
+	text := `# Onboarding dialogue
+ALICE: Welcome to the team.
+BOB: Thanks. I have a question
+about the schedule.
+
+Is it flexible?
+Alice: It is.
+`
+	script, err := parseLabeledScript(text)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []scriptTurn{
+		{Speaker: "ALICE", Text: "Welcome to the team."},
+		{Speaker: "BOB", Text: "Thanks. I have a question about the schedule.\nIs it flexible?"},
+		{Speaker: "Alice", Text: "It is."},
+	}
+	if !reflect.DeepEqual(script.Turns, expected) {
+		t.Errorf("Expected turns %q, got %q", expected, script.Turns)
+	}
+}
+
+func TestParseLabeledScriptRequiresLabel(t *testing.T) {
+	if _, err := parseLabeledScript("Hello there.\nALICE: Hi."); err == nil {
+		t.Error("Expected an error for text before the first label")
+	}
+}
+
+func TestParseYAMLScript(t *testing.T) {
+	data := `speakers:
+  ALICE:
+    voice: nova
+    speed: 1.1
+    instructions: "Warm and upbeat"
+  BOB: onyx  # shorthand for a voice
+script:
+  - ALICE: Hello Bob.
+  - speaker: BOB
+    text: |
+      Hi Alice.
+      How are you?
+  - ALICE: 'I''m well.'
+`
+	script, err := parseYAMLScript([]byte(data))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expectedSpeakers := map[string]speaker{
+		"ALICE": {Voice: "nova", Speed: "1.1", Instructions: "Warm and upbeat"},
+		"BOB":   {Voice: "onyx"},
+	}
+	if !reflect.DeepEqual(script.Speakers, expectedSpeakers) {
+		t.Errorf("Expected speakers %v, got %v", expectedSpeakers, script.Speakers)
+	}
+	expectedTurns := []scriptTurn{
+		{Speaker: "ALICE", Text: "Hello Bob."},
+		{Speaker: "BOB", Text: "Hi Alice.\nHow are you?"},
+		{Speaker: "ALICE", Text: "I'm well."},
+	}
+	if !reflect.DeepEqual(script.Turns, expectedTurns) {
+		t.Errorf("Expected turns %q, got %q", expectedTurns, script.Turns)
+	}
+}
+
+func TestScriptCast(t *testing.T) {
+	script := dialogueScript{
+		Speakers: map[string]speaker{"ALICE": {Voice: "alloy"}},
+		Turns: []scriptTurn{
+			{Speaker: "Alice", Text: "One."},
+			{Speaker: "BOB", Text: "Two."},
+			{Speaker: "CAROL", Text: "Three."},
+		},
+	}
+	flags := Flags{Speakers: []string{"carol=shimmer,speed=0.9,instructions=Calm, slow and clear"}}
+
+	segments, err := script.segments(flags, segmentChunker(flags, nil, nil))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []segment{
+		{Text: "One.", Speaker: "Alice", Voice: "alloy"},
+		{Text: "Two.", Speaker: "BOB", Voice: "echo"},
+		{Text: "Three.", Speaker: "CAROL", Voice: "shimmer", Speed: "0.9", Instructions: "Calm, slow and clear"},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Errorf("Expected segments %+v, got %+v", expected, segments)
+	}
+}
+
+func TestParseSpeakerFlagErrors(t *testing.T) {
+	for _, value := range []string{"ALICE", "=nova", "ALICE=nova,pitch=2"} {
+		if _, _, err := parseSpeakerFlag(value); err == nil {
+			t.Errorf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestScriptSpeedValidation(t *testing.T) {
+	script := dialogueScript{Turns: []scriptTurn{{Speaker: "ALICE", Text: "Hi."}}}
+	if _, err := script.segments(Flags{Speakers: []string{"ALICE=nova,speed=9"}}, segmentChunker(Flags{}, nil, nil)); err == nil {
+		t.Error("Expected an error for an out of range speed")
+	}
+}
+
+func TestProcessChunksUsesSegmentParameters(t *testing.T) {
+	var requests []TTSRequest
+	config := Config{
+		OpenAIAPIKey: "test-api-key",
+		httpClient: &MockHTTPClient{DoFunc: func(req *http.Request) (*http.Response, error) {
+			var body TTSRequest
+			if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode request: %v", err)
+			}
+			requests = append(requests, body)
+			return mockAudio(req)
+		}},
+	}
+	flags := Flags{
+		OutputFile:   filepath.Join(t.TempDir(), "dialogue.mp3"),
+		FormatOption: "mp3",
+		ModelOption:  "gpt-4o-mini-tts",
+		VoiceOption:  "nova",
+		SpeedOption:  "1.0",
+	}
+	segments := []segment{
+		{Text: "Hello.", Voice: "alloy", Instructions: "Cheerful"},
+		{Text: "Hi.", Voice: "onyx", Speed: "1.2"},
+	}
+
+	var createdFiles []string
+	if err := processChunks(context.Background(), segments, flags, config, &createdFiles); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := []TTSRequest{
+		{Model: "gpt-4o-mini-tts", Input: "Hello.", Voice: "alloy", Format: "mp3", Speed: "1.0", Instructions: "Cheerful"},
+		{Model: "gpt-4o-mini-tts", Input: "Hi.", Voice: "onyx", Format: "mp3", Speed: "1.2"},
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %+v, got %+v", expected, requests)
+	}
+}
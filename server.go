@@ -0,0 +1,595 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+const (
+	defaultListen         = ":8080"
+	defaultWorkers        = 2
+	defaultQueueSize      = 16
+	defaultJobTTL         = time.Hour
+	shutdownTimeout       = 30 * time.Second
+	maxRequestBytes       = 1 << 20
+	queueFullRetrySeconds = "5"
+)
+
+type serveOptions struct {
+	Listen    string
+	Workers   int
+	QueueSize int
+	JobDir    string
+	JobTTL    time.Duration
+	CacheDir  string
+	CacheTTL  time.Duration
+	NoCache   bool
+}
+
+type speechRequest struct {
+	Text   string `json:"text"`
+	Voice  string `json:"voice"`
+	Model  string `json:"model"`
+	Format string `json:"format"`
+	Speed  string `json:"speed"`
+	Buffer bool   `json:"buffer"`
+	Async  bool   `json:"async"`
+
+	Instructions string `json:"instructions"`
+}
+
+type job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	Chunks     int        `json:"chunks"`
+	Format     string     `json:"format"`
+	AudioURL   string     `json:"audio_url,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	request    speechRequest
+	output     string
+}
+
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+type server struct {
+	flags    Flags
+	config   Config
+	options  serveOptions
+	mu       sync.Mutex
+	jobs     map[string]*job
+	queue    chan *job
+	closed   bool
+	workers  sync.WaitGroup
+	workCtx  context.Context
+	cancel   context.CancelFunc
+	tempDir  bool
+	sweeping chan struct{}
+	cache    *audioCache
+}
+
+func runServe(args []string) error {
+	flags, options, err := parseServeFlags(args)
+	if err != nil {
+		return err
+	}
+
+	if err := setupLogger(flags, os.Stderr); err != nil {
+		return err
+	}
+
+	var config Config
+	if err := config.configure(flags.RateLimit); err != nil {
+		return fmt.Errorf("unable to configure: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s, err := newServer(flags, config, options)
+	if err != nil {
+		return err
+	}
+	return s.serve(ctx)
+}
+
+func parseServeFlags(args []string) (Flags, serveOptions, error) {
+	flags := Flags{}
+	options := serveOptions{}
+
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.StringVar(&options.Listen, "listen", defaultListen, "Address to listen on")
+	fs.IntVar(&options.Workers, "workers", defaultWorkers, "Number of jobs processed concurrently")
+	fs.IntVar(&options.QueueSize, "queue", defaultQueueSize, "Maximum number of queued jobs")
+	fs.StringVar(&options.JobDir, "dir", "", "Directory for job audio (default: a temporary directory)")
+	fs.DurationVar(&options.JobTTL, "job-ttl", defaultJobTTL, "How long finished jobs are kept")
+	fs.StringVar(&options.CacheDir, "cache-dir", defaultCacheDir(), "Directory for cached /v1/audio/speech responses")
+	fs.DurationVar(&options.CacheTTL, "cache-ttl", defaultCacheTTL, "How long unused cached audio is kept")
+	fs.BoolVar(&options.NoCache, "no-cache", false, "Disable the /v1/audio/speech response cache")
+	registerSynthesisFlags(fs, &flags)
+	registerServiceFlags(fs, &flags)
+
+	if err := fs.Parse(args); err != nil {
+		return flags, options, fmt.Errorf("unable to parse serve flags: %w", err)
+	}
+	if options.Workers < 1 || options.QueueSize < 1 {
+		return flags, options, fmt.Errorf("workers and queue must be at least 1")
+	}
+	return flags, options, nil
+}
+
+func newServer(flags Flags, config Config, options serveOptions) (*server, error) {
+	s := &server{
+		flags:    flags,
+		config:   config,
+		options:  options,
+		jobs:     make(map[string]*job),
+		queue:    make(chan *job, options.QueueSize),
+		sweeping: make(chan struct{}),
+	}
+
+	if s.options.JobDir == "" {
+		dir, err := os.MkdirTemp("", "tts-serve-")
+		if err != nil {
+			return nil, fmt.Errorf("unable to create job directory: %w", err)
+		}
+		s.options.JobDir = dir
+		s.tempDir = true
+	} else if err := os.MkdirAll(s.options.JobDir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create job directory: %w", err)
+	}
+
+	if !options.NoCache && options.CacheDir != "" {
+		cache, err := newAudioCache(options.CacheDir, options.CacheTTL)
+		if err != nil {
+			return nil, err
+		}
+		s.cache = cache
+	}
+
+	s.workCtx, s.cancel = context.WithCancel(context.Background())
+	for range options.Workers {
+		s.workers.Add(1)
+		go s.worker()
+	}
+	go s.sweep()
+
+	return s, nil
+}
+
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/speech", s.handleSpeech)
+	mux.HandleFunc("GET /v1/jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /v1/jobs/{id}/audio", s.handleJobAudio)
+	mux.HandleFunc("POST /v1/audio/speech", s.handleAudioSpeech)
+	return mux
+}
+
+func (s *server) serve(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:              s.options.Listen,
+		Handler:           s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		slog.Info("Listening", "address", s.options.Listen)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		s.close()
+		return fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("HTTP shutdown did not complete", "error", err)
+	}
+	s.drain(shutdownCtx)
+	return nil
+}
+
+func (s *server) drain(ctx context.Context) {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+		close(s.sweeping)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Cancelling unfinished jobs")
+		s.cancel()
+		<-done
+	}
+	s.cancel()
+
+	if s.tempDir {
+		if err := os.RemoveAll(s.options.JobDir); err != nil {
+			slog.Warn("Unable to remove job directory", "error", err)
+		}
+	}
+}
+
+func (s *server) close() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.drain(ctx)
+}
+
+func (s *server) handleSpeech(w http.ResponseWriter, r *http.Request) {
+	var req speechRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBytes)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if err := req.normalize(s.flags); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.Async {
+		s.enqueue(w, req)
+		return
+	}
+
+	chunks := s.chunk(req)
+	if s.streams(req, chunks) {
+		_ = s.stream(w, r, req, chunks, io.Discard, writeJSONError)
+		return
+	}
+
+	dir, err := os.MkdirTemp(s.options.JobDir, "sync-")
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	output := filepath.Join(dir, "speech."+req.Format)
+	if err := s.render(r.Context(), req, chunks, output); err != nil {
+		writeJSONError(w, statusForError(err), err.Error())
+		return
+	}
+	serveAudio(w, r, output, req.Format)
+}
+
+// normalize fills in the options a request leaves out from the -v, -m, -fmt
+// and -s the server was started with.
+func (req *speechRequest) normalize(flags Flags) error {
+	if strings.TrimSpace(req.Text) == "" {
+		return fmt.Errorf("text must not be empty")
+	}
+	req.Voice = cmp.Or(req.Voice, flags.VoiceOption, default_voice)
+	req.Model = cmp.Or(req.Model, flags.ModelOption, default_model)
+	req.Format = cmp.Or(req.Format, flags.FormatOption, default_format)
+	req.Speed = cmp.Or(req.Speed, flags.SpeedOption, default_speed)
+	if _, ok := audioMIMETypes[req.Format]; !ok {
+		return fmt.Errorf("unsupported format: %s", req.Format)
+	}
+	return nil
+}
+
+// chunk splits the text of a request, wrapping each chunk in the buffer
+// phrases when it asks for them.
+func (s *server) chunk(req speechRequest) []string {
+	return synth.SizeChunker{BufferText: req.Buffer, Buffer: bufferFor(s.flags)}.Chunk(req.Text)
+}
+
+// streams reports whether a request is streamed chunk by chunk. The buffer
+// phrases are trimmed from whole chunk files, so buffered requests are
+// rendered first unless --keep-buffer is set.
+func (s *server) streams(req speechRequest, chunks []string) bool {
+	if req.Buffer && !s.flags.KeepBuffer {
+		return false
+	}
+	return len(chunks) == 1 || streamable(req.Format)
+}
+
+func streamable(format string) bool {
+	switch format {
+	case "mp3", "aac", "pcm":
+		return true
+	}
+	return false
+}
+
+func (s *server) requestFlags(req speechRequest, outputFile string) Flags {
+	flags := s.flags
+	flags.OutputFile = outputFile
+	flags.VoiceOption = req.Voice
+	flags.ModelOption = req.Model
+	flags.FormatOption = req.Format
+	flags.SpeedOption = req.Speed
+	flags.BufferTextFlag = req.Buffer
+	flags.CombineFiles = true
+	return flags
+}
+
+type errorResponder func(w http.ResponseWriter, status int, message string)
+
+func (s *server) stream(w http.ResponseWriter, r *http.Request, req speechRequest, chunks []string, tee io.Writer, fail errorResponder) error {
+	var client HTTPClient = &http.Client{Timeout: 90 * time.Second}
+	if s.config.httpClient != nil {
+		client = s.config.httpClient
+	}
+	synthesizer := newSynthesizer(s.flags, s.config, newHTTPClient(client, s.flags))
+	output := &flushWriter{w: w}
+	destination := io.MultiWriter(output, tee)
+
+	for i, chunk := range chunks {
+		ttsRequest := TTSRequest{
+			Model:  req.Model,
+			Voice:  req.Voice,
+			Format: req.Format,
+			Input:  chunk,
+			Speed:  req.Speed,
+
+			Instructions: req.Instructions,
+		}
+		if i == 0 {
+			w.Header().Set("Content-Type", audioMIMETypes[req.Format])
+		}
+
+		if _, err := synthesizer.SynthesizeRequest(r.Context(), ttsRequest, destination); err != nil {
+			if !output.written {
+				fail(w, statusForError(err), err.Error())
+				return err
+			}
+			slog.Error("Streaming failed", "chunk", i+1, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) render(ctx context.Context, req speechRequest, chunks []string, output string) error {
+	if len(chunks) > 1 && !isCommandAvailable("ffmpeg") {
+		return errFFmpegRequired
+	}
+	segments := textSegments(chunks)
+	for i := range segments {
+		segments[i].Instructions = req.Instructions
+	}
+	return synthesizeFile(ctx, segments, s.requestFlags(req, output), s.config)
+}
+
+func serveAudio(w http.ResponseWriter, r *http.Request, path, format string) {
+	w.Header().Set("Content-Type", audioMIMETypes[format])
+	http.ServeFile(w, r, path)
+}
+
+func (s *server) enqueue(w http.ResponseWriter, req speechRequest) {
+	id, err := newJobID()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	j := &job{
+		ID:        id,
+		Status:    jobQueued,
+		Format:    req.Format,
+		CreatedAt: time.Now().UTC(),
+		request:   req,
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		writeJSONError(w, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	select {
+	case s.queue <- j:
+		s.jobs[id] = j
+	default:
+		s.mu.Unlock()
+		w.Header().Set("Retry-After", queueFullRetrySeconds)
+		writeJSONError(w, http.StatusServiceUnavailable, "job queue is full")
+		return
+	}
+	status := *j
+	s.mu.Unlock()
+
+	w.Header().Set("Location", "/v1/jobs/"+id)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+func (s *server) worker() {
+	defer s.workers.Done()
+	for j := range s.queue {
+		s.run(j)
+	}
+}
+
+func (s *server) run(j *job) {
+	s.mu.Lock()
+	j.Status = jobRunning
+	req := j.request
+	s.mu.Unlock()
+
+	dir := filepath.Join(s.options.JobDir, j.ID)
+	output := filepath.Join(dir, "speech."+req.Format)
+	chunks := s.chunk(req)
+
+	err := os.MkdirAll(dir, 0o755)
+	if err == nil {
+		err = s.render(s.workCtx, req, chunks, output)
+	}
+
+	finished := time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j.Chunks = len(chunks)
+	j.FinishedAt = &finished
+	if err != nil {
+		j.Status = jobFailed
+		j.Error = err.Error()
+		slog.Error("Job failed", "job", j.ID, "error", err)
+		return
+	}
+	j.Status = jobDone
+	j.output = output
+	j.AudioURL = "/v1/jobs/" + j.ID + "/audio"
+	slog.Info("Job finished", "job", j.ID, "chunks", j.Chunks)
+}
+
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	var status job
+	if ok {
+		status = *j
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *server) handleJobAudio(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	j, ok := s.jobs[r.PathValue("id")]
+	var status job
+	if ok {
+		status = *j
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeJSONError(w, http.StatusNotFound, "job not found")
+	case status.Status != jobDone:
+		writeJSONError(w, http.StatusConflict, fmt.Sprintf("job is %s", status.Status))
+	default:
+		serveAudio(w, r, status.output, status.Format)
+	}
+}
+
+func (s *server) sweep() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.sweeping:
+			return
+		case now := <-ticker.C:
+			s.expire(now)
+			s.cache.expire(now)
+		}
+	}
+}
+
+func (s *server) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, j := range s.jobs {
+		if j.FinishedAt == nil || now.Sub(*j.FinishedAt) < s.options.JobTTL {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.options.JobDir, id)); err != nil {
+			slog.Warn("Unable to remove expired job", "job", id, "error", err)
+			continue
+		}
+		delete(s.jobs, id)
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("unable to generate job ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func statusForError(err error) int {
+	var apiErr *synth.APIError
+	switch {
+	case errors.As(err, &apiErr):
+		switch apiErr.StatusCode {
+		case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+			return apiErr.StatusCode
+		}
+		return http.StatusBadGateway
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errFFmpegRequired):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Unable to write response", "error", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+type flushWriter struct {
+	w       http.ResponseWriter
+	written bool
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	f.written = true
+	n, err := f.w.Write(b)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
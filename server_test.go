@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, do func(req *http.Request) (*http.Response, error)) *server {
+	t.Helper()
+	config := Config{
+		OpenAIAPIKey: "test-api-key",
+		httpClient:   &MockHTTPClient{DoFunc: do},
+	}
+	options := serveOptions{Workers: 1, QueueSize: 1, JobDir: t.TempDir(), JobTTL: time.Hour}
+	s, err := newServer(Flags{}, config, options)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(s.close)
+	return s
+}
+
+func mockAudio(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader("Mock audio data")),
+	}, nil
+}
+
+func TestServerSpeechStream(t *testing.T) {
+	s := newTestServer(t, mockAudio)
+
+	body := strings.NewReader(`{"text": "Hello there", "voice": "onyx"}`)
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/speech", body))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if contentType := rec.Header().Get("Content-Type"); contentType != "audio/mpeg" {
+		t.Errorf("Expected Content-Type audio/mpeg, got '%s'", contentType)
+	}
+	if rec.Body.String() != "Mock audio data" {
+		t.Errorf("Expected audio body, got '%s'", rec.Body.String())
+	}
+}
+
+func TestServerSpeechUpstreamError(t *testing.T) {
+	s := newTestServer(t, func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader("Bad voice")),
+		}, nil
+	})
+
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/speech", strings.NewReader(`{"text": "Hello"}`)))
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Bad voice") {
+		t.Errorf("Expected upstream error in body, got '%s'", rec.Body.String())
+	}
+}
+
+func TestServerSpeechValidation(t *testing.T) {
+	s := newTestServer(t, mockAudio)
+
+	tests := []string{
+		`not json`,
+		`{"text": "  "}`,
+		`{"text": "Hello", "format": "ogg"}`,
+	}
+	for _, body := range tests {
+		rec := httptest.NewRecorder()
+		s.routes().ServeHTTP(rec, httptest.NewRequest("POST", "/v1/speech", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", body, rec.Code)
+		}
+	}
+}
+
+func TestServerJob(t *testing.T) {
+	s := newTestServer(t, mockAudio)
+	handler := s.routes()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/speech", strings.NewReader(`{"text": "Hello", "async": true}`)))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var created job
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if rec.Header().Get("Location") != "/v1/jobs/"+created.ID {
+		t.Errorf("Expected Location header for job %s, got '%s'", created.ID, rec.Header().Get("Location"))
+	}
+
+	var status job
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/jobs/"+created.ID, nil))
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			t.Fatalf("Failed to decode job status: %v", err)
+		}
+		if status.Status == jobDone || status.Status == jobFailed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status.Status != jobDone {
+		t.Fatalf("Expected job to finish, got status '%s' (%s)", status.Status, status.Error)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", status.AudioURL, nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "Mock audio data" {
+		t.Errorf("Expected job audio, got %d '%s'", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v1/jobs/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown job, got %d", rec.Code)
+	}
+}
+
+func TestServerQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	s := newTestServer(t, func(req *http.Request) (*http.Response, error) {
+		<-release
+		return mockAudio(req)
+	})
+	defer close(release)
+	handler := s.routes()
+
+	var codes []int
+	for range 3 {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/v1/speech", strings.NewReader(`{"text": "Hello", "async": true}`)))
+		codes = append(codes, rec.Code)
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if codes[len(codes)-1] != http.StatusServiceUnavailable {
+		t.Errorf("Expected the queue to fill up and return 503, got %v", codes)
+	}
+}
+
+func TestServerExpire(t *testing.T) {
+	s := newTestServer(t, mockAudio)
+	finished := time.Now().Add(-2 * time.Hour)
+	s.jobs["old"] = &job{ID: "old", Status: jobDone, FinishedAt: &finished}
+	s.jobs["running"] = &job{ID: "running", Status: jobRunning}
+
+	s.expire(time.Now())
+	if _, ok := s.jobs["old"]; ok {
+		t.Errorf("Expected expired job to be removed")
+	}
+	if _, ok := s.jobs["running"]; !ok {
+		t.Errorf("Expected unfinished job to be kept")
+	}
+}
+
+func TestParseServeFlags(t *testing.T) {
+	flags, options, err := parseServeFlags([]string{"--listen", ":9090", "--workers", "4", "-r", "30"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if options.Listen != ":9090" || options.Workers != 4 || flags.RateLimit != 30 {
+		t.Errorf("Unexpected options %+v, flags %+v", options, flags)
+	}
+	if _, _, err := parseServeFlags([]string{"--workers", "0"}); err == nil {
+		t.Errorf("Expected error for zero workers, got nil")
+	}
+
+	flags, _, err = parseServeFlags([]string{"-v", "nova", "--buffer-start", "Begin.", "--keep-buffer"})
+	if err != nil {
+		t.Fatalf("Expected synthesis flags to be accepted, got %v", err)
+	}
+	if flags.VoiceOption != "nova" || flags.BufferStart != "Begin." || !flags.KeepBuffer {
+		t.Errorf("Unexpected synthesis flags %+v", flags)
+	}
+	req := speechRequest{Text: "Hello"}
+	if err := req.normalize(flags); err != nil || req.Voice != "nova" {
+		t.Errorf("Expected default voice nova from the flags, got '%s' (%v)", req.Voice, err)
+	}
+}
+
+func TestServerStreamsBufferedRequests(t *testing.T) {
+	s := newTestServer(t, mockAudio)
+	req := speechRequest{Text: "Hello there", Format: "mp3", Buffer: true}
+	chunks := s.chunk(req)
+	if len(chunks) != 1 || !strings.Contains(chunks[0], "Hello there") {
+		t.Fatalf("Unexpected chunks %q", chunks)
+	}
+	if s.streams(req, chunks) {
+		t.Errorf("Expected buffered request to be rendered and trimmed, not streamed")
+	}
+	s.flags.KeepBuffer = true
+	if !s.streams(req, chunks) {
+		t.Errorf("Expected buffered request to stream with --keep-buffer")
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	s := newTestServer(t, mockAudio)
+	s.options.Listen = "127.0.0.1:0"
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.serve(ctx); err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// writeSilence writes d of silence in format, matching the 24kHz mono audio
+// the API returns so it can be concatenated with synthesized chunks. PCM and
+// WAV are written directly and other formats are encoded with ffmpeg.
+func writeSilence(path, format string, d time.Duration) error {
+	samples := int(d.Seconds()*pcmSampleRate + 0.5)
+	data := make([]byte, samples*pcmBytes)
+
+	switch format {
+	case "pcm":
+		return os.WriteFile(path, data, 0o644)
+	case "wav":
+		return os.WriteFile(path, encodeWAV(data), 0o644)
+	}
+
+	if !isCommandAvailable("ffmpeg") {
+		return errFFmpegRequired
+	}
+	seconds := strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+	args := []string{"-y", "-f", "lavfi", "-i", fmt.Sprintf("anullsrc=r=%d:cl=mono", pcmSampleRate), "-t", seconds}
+	args = append(args, ffmpegOutputArgs(format)...)
+	cmd := exec.Command("ffmpeg", append(args, path)...)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("unable to generate silence: %w, stdErr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// ffmpegOutputArgs selects the muxer and encoder ffmpeg needs to write format.
+func ffmpegOutputArgs(format string) []string {
+	switch format {
+	case "opus":
+		return []string{"-c:a", "libopus", "-f", "ogg"}
+	case "aac":
+		return []string{"-c:a", "aac", "-f", "adts"}
+	case "pcm":
+		return []string{"-c:a", "pcm_s16le", "-f", "s16le"}
+	case "wav":
+		return []string{"-c:a", "pcm_s16le", "-f", "wav"}
+	}
+	return []string{"-f", format}
+}
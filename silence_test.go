@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSilence(t *testing.T) {
+	for _, format := range []string{"wav", "pcm"} {
+		path := filepath.Join(t.TempDir(), "silence."+format)
+		if err := writeSilence(path, format, 750*time.Millisecond); err != nil {
+			t.Fatalf("Expected no error for %s, got %v", format, err)
+		}
+		duration, err := audioDuration(path, format)
+		if err != nil {
+			t.Fatalf("Expected no error measuring %s, got %v", format, err)
+		}
+		if duration != 750*time.Millisecond {
+			t.Errorf("Expected 750ms of %s silence, got %v", format, duration)
+		}
+	}
+}
+
+func TestWriteSilenceRequiresFFmpeg(t *testing.T) {
+	original := isCommandAvailable
+	isCommandAvailable = func(string) bool { return false }
+	defer func() {
+		isCommandAvailable = original
+	}()
+
+	path := filepath.Join(t.TempDir(), "silence.mp3")
+	if err := writeSilence(path, "mp3", time.Second); err != errFFmpegRequired {
+		t.Errorf("Expected errFFmpegRequired, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written")
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// span is the chunks from Start up to but not including End.
+type span struct {
+	Start int
+	End   int
+}
+
+// splitParts groups consecutive chunks into parts no longer than
+// --max-part-duration. A part that runs over ends at its last heading instead,
+// as long as that leaves it at least half full. A chunk longer than the limit
+// is a part of its own. splitParts returns nil when the output is not split.
+func splitParts(segments []segment, durations []time.Duration, flags Flags) []span {
+	limit := flags.MaxPartDuration
+	if limit <= 0 || len(durations) != len(segments) {
+		return nil
+	}
+
+	var parts []span
+	start := 0
+	for i := range segments {
+		if i == start || spanDuration(durations[start:i+1], flags) <= limit {
+			continue
+		}
+		end := i
+		for j := i; j > start && spanDuration(durations[start:j], flags) >= limit/2; j-- {
+			if startsWithHeading(segments[j]) {
+				end = j
+				break
+			}
+		}
+		parts = append(parts, span{Start: start, End: end})
+		start = end
+	}
+	return append(parts, span{Start: start, End: len(segments)})
+}
+
+// spanDuration is the length of the chunks joined with the crossfade.
+func spanDuration(durations []time.Duration, flags Flags) time.Duration {
+	var total time.Duration
+	for i, duration := range durations {
+		if i > 0 {
+			total -= flags.Crossfade
+		}
+		total += duration
+	}
+	return total
+}
+
+func startsWithHeading(segment segment) bool {
+	line, _, _ := strings.Cut(strings.TrimLeft(segment.Text, " \t\r\n"), "\n")
+	return segment.Pause == 0 && atxHeading.MatchString(strings.TrimRight(line, "\r"))
+}
+
+// splitOutput names a part of split output, such as book_part01.mp3.
+func splitOutput(output string, number, total int) string {
+	ext := filepath.Ext(output)
+	width := max(len(strconv.Itoa(total)), 2)
+	return fmt.Sprintf("%s_part%0*d%s", strings.TrimSuffix(output, ext), width, number, ext)
+}
+
+// splitOutputs returns the parts of split output found next to it, in order.
+func splitOutputs(output string) []string {
+	ext := filepath.Ext(output)
+	pattern := regexp.MustCompile("^" + regexp.QuoteMeta(filepath.Base(strings.TrimSuffix(output, ext))) + `_part(\d+)` + regexp.QuoteMeta(ext) + "$")
+	entries, err := os.ReadDir(filepath.Dir(output))
+	if err != nil {
+		return nil
+	}
+	var files []string
+	numbers := make(map[string]int)
+	for _, entry := range entries {
+		if m := pattern.FindStringSubmatch(entry.Name()); m != nil && !entry.IsDir() {
+			file := filepath.Join(filepath.Dir(output), entry.Name())
+			numbers[file], _ = strconv.Atoi(m[1])
+			files = append(files, file)
+		}
+	}
+	sort.SliceStable(files, func(i, j int) bool { return numbers[files[i]] < numbers[files[j]] })
+	return files
+}
+
+// removeStaleOutputs removes what an earlier run left next to the output that
+// this run does not write: the parts when the output fits in one file, or the
+// single file and any parts past the last when it is split.
+func removeStaleOutputs(output string, written []string) {
+	var stale []string
+	if len(written) > 0 {
+		stale = append(stale, output)
+	}
+	for _, file := range splitOutputs(output) {
+		if !slices.Contains(written, file) {
+			stale = append(stale, file)
+		}
+	}
+	for _, file := range stale {
+		if err := os.Remove(file); err == nil {
+			slog.Info("Removed output of an earlier run", "file", file)
+		} else if !os.IsNotExist(err) {
+			slog.Warn("Unable to remove output of an earlier run", "file", file, "error", err)
+		}
+	}
+}
+
+// combineParts joins the chunk files of each part into its own output file,
+// tagged as a track with the chapters that fall inside it.
+func combineParts(segments []segment, files []string, durations []time.Duration, parts []span, flags Flags, config Config) error {
+	// Chapters are marked across all the chunks so one that continues into
+	// the next part keeps its title there.
+	marks := chunkChapters(segments, durations, flags)
+	outputs := make([]string, len(parts))
+	for k := range parts {
+		outputs[k] = splitOutput(flags.OutputFile, k+1, len(parts))
+	}
+	removeStaleOutputs(flags.OutputFile, outputs)
+
+	var offset time.Duration
+	for k, p := range parts {
+		partFlags := flags
+		partFlags.OutputFile = outputs[k]
+		textFileName := fmt.Sprintf("%s.txt", strings.TrimSuffix(partFlags.OutputFile, filepath.Ext(partFlags.OutputFile)))
+		_ = os.Remove(textFileName)
+		for _, file := range files[p.Start:p.End] {
+			absFile, err := filepath.Abs(file)
+			if err != nil {
+				return fmt.Errorf("unable to get absolute path for chunk file: %w", err)
+			}
+			if err := appendToTextFile(textFileName, absFile); err != nil {
+				return err
+			}
+		}
+		if err := combineFiles(partFlags, []string{textFileName}); err != nil {
+			return err
+		}
+
+		length := spanDuration(durations[p.Start:p.End], flags)
+		if length > flags.MaxPartDuration {
+			slog.Warn("Part is longer than --max-part-duration, as one of its chunks runs over it", "part", k+1, "duration", length.Round(time.Second), "limit", flags.MaxPartDuration)
+		}
+		tags := newAudioTags(partFlags, segments[p.Start:p.End])
+		tags.Title = fmt.Sprintf("Part %d", k+1)
+		if flags.Title != "" {
+			tags.Title = fmt.Sprintf("%s (Part %d)", flags.Title, k+1)
+		}
+		tags.Album = cmp.Or(flags.Album, flags.Title)
+		tags.Track = fmt.Sprintf("%d/%d", k+1, len(parts))
+		tags.Chapters = clipChapters(marks, offset, offset+length)
+		tagAudio(partFlags.OutputFile, flags.FormatOption, tags)
+		config.report.addPart(tags.Title, partFlags.OutputFile, flags.FormatOption)
+		slog.Info("Wrote part", "part", k+1, "of", len(parts), "duration", length.Round(time.Second), "output", partFlags.OutputFile)
+
+		// The next part starts where this one's last chunk began to fade out.
+		offset += length - flags.Crossfade
+	}
+	return nil
+}
+
+// clipChapters returns the chapters that overlap from up to to, cut to that
+// range and timed from its start.
+func clipChapters(marks []chapterMark, from, to time.Duration) []chapterMark {
+	var clipped []chapterMark
+	for _, mark := range marks {
+		if mark.End <= from || mark.Start >= to {
+			continue
+		}
+		clipped = append(clipped, chapterMark{
+			Title: mark.Title,
+			Start: max(mark.Start, from) - from,
+			End:   min(mark.End, to) - from,
+		})
+	}
+	return clipped
+}
+
+// splitChunks combines the chunk files into parts when the output is longer
+// than --max-part-duration. It reports false when the output fits in one file
+// and is combined as usual.
+func splitChunks(segments []segment, files []string, flags Flags, config Config) (bool, error) {
+	if flags.MaxPartDuration <= 0 {
+		return false, nil
+	}
+	durations := measureChunks(files, flags, config)
+	if durations == nil {
+		return false, fmt.Errorf("unable to measure the chunks to split the output into parts")
+	}
+	parts := splitParts(segments, durations, flags)
+	if len(parts) < 2 {
+		removeStaleOutputs(flags.OutputFile, nil)
+		return false, nil
+	}
+	return true, combineParts(segments, files, durations, parts, flags, config)
+}
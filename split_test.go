@@ -0,0 +1,112 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSplitParts(t *testing.T) {
+	segments := []segment{
+		{Text: "# One\nOpening."},
+		{Text: "More."},
+		{Text: "# Two\nMiddle."},
+		{Text: "More."},
+		{Text: "Still more."},
+		{Text: "Later."},
+		{Text: "# Three\nEnd."},
+	}
+	minute := time.Minute
+	durations := []time.Duration{4 * minute, 4 * minute, 2 * minute, 4 * minute, 4 * minute, 30 * minute, 2 * minute}
+	flags := Flags{MaxPartDuration: 10 * minute}
+
+	// The first part breaks at the heading of Two, the second runs out of
+	// room with no heading past its halfway mark, and the long chunk stands
+	// alone.
+	expected := []span{{0, 2}, {2, 5}, {5, 6}, {6, 7}}
+	if parts := splitParts(segments, durations, flags); !reflect.DeepEqual(parts, expected) {
+		t.Errorf("Expected parts %v, got %v", expected, parts)
+	}
+
+	flags.MaxPartDuration = time.Hour
+	if parts := splitParts(segments, durations, flags); !reflect.DeepEqual(parts, []span{{0, 7}}) {
+		t.Errorf("Expected one part when everything fits, got %v", parts)
+	}
+	if parts := splitParts(segments, durations[:3], flags); parts != nil {
+		t.Errorf("Expected no parts without a duration for every chunk, got %v", parts)
+	}
+}
+
+func TestSplitPartsCrossfade(t *testing.T) {
+	segments := []segment{{Text: "One."}, {Text: "Two."}, {Text: "Three."}}
+	durations := []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}
+	flags := Flags{MaxPartDuration: 14 * time.Second, Crossfade: time.Second}
+	if parts := splitParts(segments, durations, flags); len(parts) != 1 {
+		t.Errorf("Expected the crossfades to make the chunks fit one part, got %v", parts)
+	}
+}
+
+func TestSplitOutput(t *testing.T) {
+	for _, test := range []struct {
+		number, total int
+		expected      string
+	}{
+		{1, 3, "out/book_part01.mp3"},
+		{12, 120, "out/book_part012.mp3"},
+	} {
+		if name := splitOutput("out/book.mp3", test.number, test.total); name != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, name)
+		}
+	}
+}
+
+func TestRemoveStaleOutputs(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "book.mp3")
+	create := func(names ...string) {
+		for _, name := range names {
+			if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	// An earlier run wrote four parts and this one writes two.
+	create("book.mp3", "book_part01.mp3", "book_part02.mp3", "book_part03.mp3", "book_part04.mp3", "book_party.mp3")
+	written := []string{splitOutput(output, 1, 2), splitOutput(output, 2, 2)}
+	removeStaleOutputs(output, written)
+	if expected := written; !reflect.DeepEqual(partFiles(output), expected) {
+		t.Errorf("Expected only this run's parts %q, got %q", expected, partFiles(output))
+	}
+	if !exists("book_party.mp3") {
+		t.Errorf("Expected files that are not parts to be kept")
+	}
+
+	// The output now fits in one file.
+	create("book.mp3")
+	removeStaleOutputs(output, nil)
+	if exists("book_part01.mp3") || !exists("book.mp3") {
+		t.Errorf("Expected the parts to be removed and the output kept")
+	}
+}
+
+func TestClipChapters(t *testing.T) {
+	marks := []chapterMark{
+		{Title: "One", Start: 0, End: 5 * time.Minute},
+		{Title: "Two", Start: 5 * time.Minute, End: 25 * time.Minute},
+		{Title: "Three", Start: 25 * time.Minute, End: 30 * time.Minute},
+	}
+	expected := []chapterMark{
+		{Title: "Two", Start: 0, End: 15 * time.Minute},
+		{Title: "Three", Start: 15 * time.Minute, End: 20 * time.Minute},
+	}
+	if clipped := clipChapters(marks, 10*time.Minute, 30*time.Minute); !reflect.DeepEqual(clipped, expected) {
+		t.Errorf("Expected %v, got %v", expected, clipped)
+	}
+}
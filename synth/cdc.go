@@ -0,0 +1,97 @@
+package synth
+
+import (
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const defaultBoundary = 4
+
+// ContentChunker splits text on paragraph boundaries chosen from the content
+// itself, so editing one paragraph only changes the chunks around it. Use it
+// when chunks are cached between runs.
+type ContentChunker struct {
+	// Size is the largest chunk in runes. Zero means ChunkSize(BufferText).
+	Size int
+	// Boundary makes roughly one in Boundary paragraphs end a chunk. Zero means 4.
+	Boundary   int
+	BufferText bool
+	Buffer     Buffer
+}
+
+func (c ContentChunker) Chunk(text string) []string {
+	size := c.Size
+	if size <= 0 {
+		size = chunkSize(c.BufferText, c.Buffer)
+	}
+	boundary := c.Boundary
+	if boundary <= 0 {
+		boundary = defaultBoundary
+	}
+	minSize := size / 4
+
+	var chunks []string
+	var current strings.Builder
+	currentSize := 0
+
+	flush := func() {
+		if currentSize > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentSize = 0
+		}
+	}
+
+	for _, unit := range splitParagraphs(text, size) {
+		unitSize := utf8.RuneCountInString(unit)
+		if currentSize+unitSize > size {
+			flush()
+		}
+		current.WriteString(unit)
+		currentSize += unitSize
+
+		if currentSize >= minSize && isBoundary(unit, boundary) {
+			flush()
+		}
+	}
+	flush()
+
+	if c.BufferText {
+		chunks = c.Buffer.Wrap(chunks)
+	}
+	return chunks
+}
+
+// blankLines matches a line break followed by one or more blank lines, with
+// Unix or Windows line endings.
+var blankLines = regexp.MustCompile(`\r?\n(?:[ \t]*\r?\n)+`)
+
+// splitParagraphs splits text after each run of blank lines, keeping the
+// separators so the pieces join back into the original text. Paragraphs
+// longer than size are split further with SplitIntoChunks.
+func splitParagraphs(text string, size int) []string {
+	var units []string
+	for len(text) > 0 {
+		end := len(text)
+		if loc := blankLines.FindStringIndex(text); loc != nil {
+			end = loc[1]
+		}
+		paragraph := text[:end]
+		text = text[end:]
+
+		if utf8.RuneCountInString(paragraph) > size {
+			units = append(units, SplitIntoChunks(paragraph, size)...)
+			continue
+		}
+		units = append(units, paragraph)
+	}
+	return units
+}
+
+func isBoundary(unit string, boundary int) bool {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(strings.TrimSpace(unit)))
+	return hash.Sum64()%uint64(boundary) == 0
+}
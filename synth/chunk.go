@@ -0,0 +1,126 @@
+package synth
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// MaxChars is the largest input the OpenAI speech endpoint accepts in one request.
+	MaxChars = 4096
+
+	BufferStart = "Begin Text\n"
+	BufferEnd   = "\nEnd Text"
+)
+
+// Chunker splits text into pieces that each fit into a single request.
+type Chunker interface {
+	Chunk(text string) []string
+}
+
+// ChunkerFunc adapts a plain function to the Chunker interface.
+type ChunkerFunc func(text string) []string
+
+func (f ChunkerFunc) Chunk(text string) []string {
+	return f(text)
+}
+
+// Buffer is the pair of phrases wrapped around each chunk when buffer text is
+// enabled. The zero value uses BufferStart and BufferEnd.
+type Buffer struct {
+	Start string
+	End   string
+}
+
+func (b Buffer) phrases() (string, string) {
+	if b == (Buffer{}) {
+		return BufferStart, BufferEnd
+	}
+	return b.Start, b.End
+}
+
+// Wrap wraps every chunk in the buffer phrases, modifying chunks in place.
+func (b Buffer) Wrap(chunks []string) []string {
+	start, end := b.phrases()
+	for i, chunk := range chunks {
+		chunks[i] = start + chunk + end
+	}
+	return chunks
+}
+
+// Strip removes the buffer phrases from a wrapped chunk.
+func (b Buffer) Strip(text string) string {
+	start, end := b.phrases()
+	return strings.TrimSuffix(strings.TrimPrefix(text, start), end)
+}
+
+// Size returns the number of runes the phrases add to each chunk.
+func (b Buffer) Size() int {
+	start, end := b.phrases()
+	return utf8.RuneCountInString(start) + utf8.RuneCountInString(end)
+}
+
+// SizeChunker splits text on whitespace into chunks of at most Size runes,
+// optionally wrapping each chunk in buffer words to soften abrupt starts and ends.
+type SizeChunker struct {
+	Size       int
+	BufferText bool
+	Buffer     Buffer
+}
+
+func (c SizeChunker) Chunk(text string) []string {
+	size := c.Size
+	if size <= 0 {
+		size = chunkSize(c.BufferText, c.Buffer)
+	}
+	chunks := SplitIntoChunks(text, size)
+	if c.BufferText {
+		chunks = c.Buffer.Wrap(chunks)
+	}
+	return chunks
+}
+
+// ChunkSize returns the number of runes available for text in each request.
+func ChunkSize(bufferText bool) int {
+	return chunkSize(bufferText, Buffer{})
+}
+
+func chunkSize(bufferText bool, buffer Buffer) int {
+	if bufferText {
+		return MaxChars - buffer.Size()
+	}
+	return MaxChars
+}
+
+// SplitIntoChunks splits text into chunks of at most chunkSize runes, breaking
+// on whitespace where possible.
+func SplitIntoChunks(text string, chunkSize int) []string {
+	var chunks []string
+	inputRunes := []rune(text)
+
+	for len(inputRunes) > 0 {
+		if len(inputRunes) <= chunkSize {
+			chunks = append(chunks, string(inputRunes))
+			break
+		}
+
+		splitIndex := chunkSize
+		for splitIndex > 0 && !unicode.IsSpace(inputRunes[splitIndex]) {
+			splitIndex--
+		}
+		if splitIndex == 0 {
+			splitIndex = chunkSize // If no space found, force split
+		}
+
+		chunks = append(chunks, string(inputRunes[:splitIndex]))
+		inputRunes = inputRunes[splitIndex:]
+	}
+
+	return chunks
+}
+
+// AddBufferText wraps every chunk in the buffer words, modifying chunks in place.
+func AddBufferText(chunks []string) []string {
+	return Buffer{}.Wrap(chunks)
+}
@@ -0,0 +1,163 @@
+package synth
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestChunkSize(t *testing.T) {
+	chunkSize := ChunkSize(false)
+	expectedSize := MaxChars
+	if chunkSize != expectedSize {
+		t.Errorf("Expected chunk size %d, got %d", expectedSize, chunkSize)
+	}
+	chunkSizeWithBuffer := ChunkSize(true)
+	startText := "Begin Text\n"
+	endText := "\nEnd Text"
+	startTextLen := utf8.RuneCountInString(startText)
+	endTextLen := utf8.RuneCountInString(endText)
+	expectedSizeWithBuffer := MaxChars - (startTextLen + endTextLen)
+	if chunkSizeWithBuffer != expectedSizeWithBuffer {
+		t.Errorf("Expected chunk size with buffer %d, got %d", expectedSizeWithBuffer, chunkSizeWithBuffer)
+	}
+}
+
+func TestSplitIntoChunks(t *testing.T) {
+	text := "This is a test. "
+	chunkSize := 10
+	chunks := SplitIntoChunks(text, chunkSize)
+	expectedChunks := []string{"This is a", " test. "}
+	if !reflect.DeepEqual(chunks, expectedChunks) {
+		t.Errorf("Expected chunks %v, got %v", expectedChunks, chunks)
+	}
+
+	text = "Short text"
+	chunks = SplitIntoChunks(text, chunkSize)
+	expectedChunks = []string{"Short text"}
+	if !reflect.DeepEqual(chunks, expectedChunks) {
+		t.Errorf("Expected chunks %v, got %v", expectedChunks, chunks)
+	}
+
+	text = "Thisisaverylongwordthathasnospacesandshouldbesplitatmaximumchunksize."
+	chunkSize = 20
+	expectedChunks = []string{
+		"Thisisaverylongwordt",
+		"hathasnospacesandsho",
+		"uldbesplitatmaximumc",
+		"hunksize.",
+	}
+	chunks = SplitIntoChunks(text, chunkSize)
+	if !reflect.DeepEqual(chunks, expectedChunks) {
+		t.Errorf("Expected chunks %v, got %v", expectedChunks, chunks)
+	}
+}
+
+func TestAddBufferText(t *testing.T) {
+	chunks := []string{"Chunk 1", "Chunk 2"}
+	bufferedChunks := AddBufferText(chunks)
+	expectedChunks := []string{"Begin Text\nChunk 1\nEnd Text", "Begin Text\nChunk 2\nEnd Text"}
+	if !reflect.DeepEqual(bufferedChunks, expectedChunks) {
+		t.Errorf("Expected buffered chunks %v, got %v", expectedChunks, bufferedChunks)
+	}
+}
+
+func TestSizeChunker(t *testing.T) {
+	chunks := SizeChunker{Size: 10, BufferText: true}.Chunk("This is a test. ")
+	expectedChunks := []string{"Begin Text\nThis is a\nEnd Text", "Begin Text\n test. \nEnd Text"}
+	if !reflect.DeepEqual(chunks, expectedChunks) {
+		t.Errorf("Expected chunks %v, got %v", expectedChunks, chunks)
+	}
+}
+
+func TestContentChunker(t *testing.T) {
+	var paragraphs []string
+	for i := range 40 {
+		paragraphs = append(paragraphs, fmt.Sprintf("Paragraph %d has a few words of text in it.\n\n", i))
+	}
+	text := strings.Join(paragraphs, "")
+	chunker := ContentChunker{Size: 600}
+
+	chunks := chunker.Chunk(text)
+	if len(chunks) < 4 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+	if strings.Join(chunks, "") != text {
+		t.Errorf("Expected chunks to join back into the original text")
+	}
+	for _, chunk := range chunks {
+		if utf8.RuneCountInString(chunk) > 600 {
+			t.Errorf("Expected chunks of at most 600 runes, got %d", utf8.RuneCountInString(chunk))
+		}
+	}
+
+	paragraphs[20] = "Paragraph 20 has a fixed typo in it.\n\n"
+	edited := chunker.Chunk(strings.Join(paragraphs, ""))
+
+	before := make(map[string]bool)
+	for _, chunk := range chunks {
+		before[chunk] = true
+	}
+	changed := 0
+	for _, chunk := range edited {
+		if !before[chunk] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 2 {
+		t.Errorf("Expected an edit to change one or two of %d chunks, got %d", len(edited), changed)
+	}
+}
+
+func TestContentChunkerCRLF(t *testing.T) {
+	var paragraphs []string
+	for i := range 40 {
+		paragraphs = append(paragraphs, fmt.Sprintf("Paragraph %d has a few words of text in it.\r\n \r\n", i))
+	}
+	text := strings.Join(paragraphs, "")
+	chunks := ContentChunker{Size: 600}.Chunk(text)
+	if strings.Join(chunks, "") != text {
+		t.Fatalf("Expected chunks to join back into the original text")
+	}
+	for _, chunk := range chunks {
+		if !strings.HasSuffix(chunk, "\r\n \r\n") {
+			t.Errorf("Expected chunks to end at a blank line, got %q", chunk[max(len(chunk)-20, 0):])
+		}
+	}
+
+	units := splitParagraphs("One.\r\n\r\n\r\nTwo.\nThree.\n\nFour.", 100)
+	if expected := []string{"One.\r\n\r\n\r\n", "Two.\nThree.\n\n", "Four."}; !reflect.DeepEqual(units, expected) {
+		t.Errorf("Expected paragraphs %q, got %q", expected, units)
+	}
+}
+
+func TestContentChunkerLongParagraph(t *testing.T) {
+	text := strings.Repeat("word ", 100)
+	chunks := ContentChunker{Size: 50}.Chunk(text)
+	if len(chunks) < 10 {
+		t.Errorf("Expected a long paragraph to be split, got %d chunks", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if utf8.RuneCountInString(chunk) > 50 {
+			t.Errorf("Expected chunks of at most 50 runes, got %d", utf8.RuneCountInString(chunk))
+		}
+	}
+}
+
+func TestBuffer(t *testing.T) {
+	buffer := Buffer{Start: "Okay\n", End: "\nDone"}
+	if buffer.Size() != 10 {
+		t.Errorf("Expected buffer size 10, got %d", buffer.Size())
+	}
+	if text := buffer.Strip("Okay\nHello\nDone"); text != "Hello" {
+		t.Errorf("Expected buffer phrases to be stripped, got %q", text)
+	}
+	if size := (SizeChunker{BufferText: true, Buffer: buffer}).Chunk(strings.Repeat("a ", 4000))[0]; utf8.RuneCountInString(size) > MaxChars {
+		t.Errorf("Expected wrapped chunks to fit in a request")
+	}
+	if (Buffer{}).Size() != ChunkSize(false)-ChunkSize(true) {
+		t.Errorf("Expected the zero buffer to use the default phrases")
+	}
+}
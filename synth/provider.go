@@ -0,0 +1,69 @@
+package synth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// DefaultURL is the OpenAI speech endpoint.
+const DefaultURL = "https://api.openai.com/v1/audio/speech"
+
+// Request is the body of a single speech request.
+type Request struct {
+	Model  string `json:"model"`
+	Input  string `json:"input"`
+	Voice  string `json:"voice"`
+	Format string `json:"response_format"`
+	Speed  string `json:"speed"`
+	// Instructions steer the tone of the voice. Only gpt-4o-mini-tts uses them.
+	Instructions string `json:"instructions,omitempty"`
+}
+
+// Provider turns a Request into an HTTP request for a speech API.
+type Provider interface {
+	NewRequest(ctx context.Context, req Request) (*http.Request, error)
+}
+
+// OpenAI is the Provider for the OpenAI speech API. An empty URL uses DefaultURL.
+type OpenAI struct {
+	APIKey string
+	URL    string
+}
+
+func (p OpenAI) NewRequest(ctx context.Context, ttsRequest Request) (*http.Request, error) {
+	requestBody, err := json.Marshal(ttsRequest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request payload: %w", err)
+	}
+
+	url := p.URL
+	if url == "" {
+		url = DefaultURL
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create HTTP request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+// APIError is returned when the speech API answers with a non-200 status.
+type APIError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("OpenAI API request failed with status code: %d, response body: %s", e.StatusCode, e.Body)
+}
+
+func (e *APIError) temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,34 @@
+package synth
+
+import (
+	"context"
+	"time"
+)
+
+// RateLimiter blocks until the next request may be sent.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+type tickerLimiter struct {
+	ticks <-chan time.Time
+}
+
+// PerMinute returns a RateLimiter allowing n requests per minute. It returns nil,
+// meaning unlimited, when n is not positive.
+func PerMinute(n int) RateLimiter {
+	if n <= 0 {
+		return nil
+	}
+	ticker := time.NewTicker(time.Minute / time.Duration(n))
+	return &tickerLimiter{ticks: ticker.C}
+}
+
+func (l *tickerLimiter) Wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.ticks:
+		return nil
+	}
+}
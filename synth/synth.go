@@ -0,0 +1,271 @@
+// Package synth converts text of any length to speech through a speech API,
+// handling chunking, rate limiting and retries.
+package synth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultVoice  = "nova"
+	DefaultModel  = "tts-1-hd"
+	DefaultFormat = "mp3"
+	DefaultSpeed  = "1.0"
+
+	defaultTimeout = 90 * time.Second
+	defaultBackoff = time.Second
+)
+
+// HTTPClient is the subset of *http.Client used by the Synthesizer.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// Result describes a completed speech request.
+type Result struct {
+	RequestID string
+	Latency   time.Duration
+	Bytes     int64
+	Retries   int
+}
+
+// Chunk is one synthesized piece of a longer text.
+type Chunk struct {
+	Index  int
+	Text   string
+	Audio  []byte
+	Result Result
+}
+
+// Synthesizer converts text to speech. Create one with New.
+type Synthesizer struct {
+	provider Provider
+	client   HTTPClient
+	limiter  RateLimiter
+	chunker  Chunker
+	retries  int
+	backoff  time.Duration
+	defaults Request
+}
+
+// Option configures a Synthesizer.
+type Option func(*Synthesizer)
+
+// WithProvider sets the speech API provider. The default is OpenAI using the
+// OPENAI_API_KEY environment variable.
+func WithProvider(provider Provider) Option {
+	return func(s *Synthesizer) {
+		s.provider = provider
+	}
+}
+
+// WithHTTPClient sets the client used to send requests.
+func WithHTTPClient(client HTTPClient) Option {
+	return func(s *Synthesizer) {
+		s.client = client
+	}
+}
+
+// WithRateLimiter sets a limiter that is waited on before every request.
+func WithRateLimiter(limiter RateLimiter) Option {
+	return func(s *Synthesizer) {
+		s.limiter = limiter
+	}
+}
+
+// WithChunker sets how text is split into requests. The default is a SizeChunker.
+func WithChunker(chunker Chunker) Option {
+	return func(s *Synthesizer) {
+		s.chunker = chunker
+	}
+}
+
+// WithRetries sets how many times a request is retried after a network error,
+// a 429 or a 5xx response.
+func WithRetries(retries int) Option {
+	return func(s *Synthesizer) {
+		s.retries = retries
+	}
+}
+
+// WithBackoff sets the initial delay between retries. It doubles after each attempt.
+func WithBackoff(backoff time.Duration) Option {
+	return func(s *Synthesizer) {
+		s.backoff = backoff
+	}
+}
+
+// WithDefaults sets the model, voice, format and speed used by Synthesize and
+// SynthesizeChunks. Empty fields keep their defaults.
+func WithDefaults(defaults Request) Option {
+	return func(s *Synthesizer) {
+		if defaults.Model != "" {
+			s.defaults.Model = defaults.Model
+		}
+		if defaults.Voice != "" {
+			s.defaults.Voice = defaults.Voice
+		}
+		if defaults.Format != "" {
+			s.defaults.Format = defaults.Format
+		}
+		if defaults.Speed != "" {
+			s.defaults.Speed = defaults.Speed
+		}
+	}
+}
+
+// New returns a Synthesizer configured with opts.
+func New(opts ...Option) *Synthesizer {
+	s := &Synthesizer{
+		provider: OpenAI{APIKey: os.Getenv("OPENAI_API_KEY")},
+		client:   &http.Client{Timeout: defaultTimeout},
+		chunker:  SizeChunker{},
+		backoff:  defaultBackoff,
+		defaults: Request{
+			Model:  DefaultModel,
+			Voice:  DefaultVoice,
+			Format: DefaultFormat,
+			Speed:  DefaultSpeed,
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Chunks splits text the way Synthesize will send it.
+func (s *Synthesizer) Chunks(text string) []string {
+	return s.chunker.Chunk(text)
+}
+
+// Synthesize converts text to speech and writes the audio of every chunk to w in order.
+func (s *Synthesizer) Synthesize(ctx context.Context, text string, w io.Writer) error {
+	for _, chunk := range s.Chunks(text) {
+		ttsRequest := s.defaults
+		ttsRequest.Input = chunk
+		if _, err := s.SynthesizeRequest(ctx, ttsRequest, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SynthesizeChunks converts text to speech one chunk at a time. Iteration stops
+// after the first error.
+func (s *Synthesizer) SynthesizeChunks(ctx context.Context, text string) iter.Seq2[Chunk, error] {
+	return func(yield func(Chunk, error) bool) {
+		for i, text := range s.Chunks(text) {
+			ttsRequest := s.defaults
+			ttsRequest.Input = text
+
+			var audio bytes.Buffer
+			result, err := s.SynthesizeRequest(ctx, ttsRequest, &audio)
+			chunk := Chunk{Index: i, Text: text, Audio: audio.Bytes(), Result: result}
+			if !yield(chunk, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// SynthesizeRequest sends a single request and streams the audio to w. Requests
+// are only retried before any audio has been written.
+func (s *Synthesizer) SynthesizeRequest(ctx context.Context, ttsRequest Request, w io.Writer) (Result, error) {
+	var result Result
+	backoff := s.backoff
+
+	for {
+		if s.limiter != nil {
+			if err := s.limiter.Wait(ctx); err != nil {
+				return result, err
+			}
+		}
+
+		retryAfter, err := s.send(ctx, ttsRequest, w, &result)
+		if err == nil || result.Retries >= s.retries || ctx.Err() != nil || !retryable(err) {
+			return result, err
+		}
+
+		delay := max(backoff, retryAfter)
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-time.After(delay):
+		}
+		backoff *= 2
+		result.Retries++
+	}
+}
+
+func (s *Synthesizer) send(ctx context.Context, ttsRequest Request, w io.Writer, result *Result) (time.Duration, error) {
+	req, err := s.provider.NewRequest(ctx, ttsRequest)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, &sendError{err: err}
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	result.Latency = time.Since(sent)
+	result.RequestID = resp.Header.Get("X-Request-Id")
+
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return parseRetryAfter(resp.Header.Get("Retry-After")), &APIError{StatusCode: resp.StatusCode, Body: responseBody}
+	}
+
+	result.Bytes, err = io.Copy(w, resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("unable to write to output: %w", err)
+	}
+	return 0, nil
+}
+
+type sendError struct {
+	err error
+}
+
+func (e *sendError) Error() string {
+	return fmt.Sprintf("unable to send request to OpenAI API: %v", e.err)
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.temporary()
+	}
+	var sendErr *sendError
+	return errors.As(err, &sendErr)
+}
+
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
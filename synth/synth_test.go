@@ -0,0 +1,231 @@
+package synth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MockHTTPClient struct {
+	DoFunc func(req *http.Request) (*http.Response, error)
+}
+
+func (m *MockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	return m.DoFunc(req)
+}
+
+func TestSynthesizeRequest(t *testing.T) {
+	ttsRequest := Request{
+		Model:  "test-model",
+		Voice:  "test-voice",
+		Format: "mp3",
+		Input:  "Test input text",
+		Speed:  "1.0",
+	}
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			if req.Method != "POST" {
+				t.Errorf("Expected POST method, got %s", req.Method)
+			}
+			if req.URL.String() != DefaultURL {
+				t.Errorf("Expected URL %s, got %s", DefaultURL, req.URL.String())
+			}
+			response := &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("Mock audio data")),
+			}
+			return response, nil
+		},
+	}
+	output := &bytes.Buffer{}
+	synthesizer := New(WithProvider(OpenAI{APIKey: "test-api-key"}), WithHTTPClient(mockClient))
+	_, err := synthesizer.SynthesizeRequest(context.Background(), ttsRequest, output)
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if output.String() != "Mock audio data" {
+		t.Errorf("Expected output 'Mock audio data', got '%s'", output.String())
+	}
+}
+
+func TestSynthesizeRequest_ErrorResponse(t *testing.T) {
+	ttsRequest := Request{
+		Model:  "test-model",
+		Voice:  "test-voice",
+		Format: "mp3",
+		Input:  "Test input text",
+		Speed:  "1.0",
+	}
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			response := &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       io.NopCloser(strings.NewReader("Bad request")),
+			}
+			return response, nil
+		},
+	}
+	output := &bytes.Buffer{}
+	synthesizer := New(WithProvider(OpenAI{APIKey: "test-api-key"}), WithHTTPClient(mockClient))
+	_, err := synthesizer.SynthesizeRequest(context.Background(), ttsRequest, output)
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	} else {
+		expectedError := "OpenAI API request failed with status code: 400, response body: Bad request"
+		if err.Error() != expectedError {
+			t.Errorf("Expected error '%s', got '%s'", expectedError, err.Error())
+		}
+	}
+}
+
+func TestSynthesizeRequest_RequestError(t *testing.T) {
+	ttsRequest := Request{
+		Model:  "test-model",
+		Voice:  "test-voice",
+		Format: "mp3",
+		Input:  "Test input text",
+		Speed:  "1.0",
+	}
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			return nil, errors.New("network error")
+		},
+	}
+	output := &bytes.Buffer{}
+	synthesizer := New(WithProvider(OpenAI{APIKey: "test-api-key"}), WithHTTPClient(mockClient))
+	_, err := synthesizer.SynthesizeRequest(context.Background(), ttsRequest, output)
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	} else {
+		expectedError := "unable to send request to OpenAI API: network error"
+		if err.Error() != expectedError {
+			t.Errorf("Expected error '%s', got '%s'", expectedError, err.Error())
+		}
+	}
+}
+
+func TestSynthesizeRequest_Retries(t *testing.T) {
+	attempts := 0
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			if attempts < 3 {
+				return &http.Response{
+					StatusCode: http.StatusTooManyRequests,
+					Body:       io.NopCloser(strings.NewReader("slow down")),
+				}, nil
+			}
+			header := http.Header{}
+			header.Set("X-Request-Id", "req_123")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     header,
+				Body:       io.NopCloser(strings.NewReader("Mock audio data")),
+			}, nil
+		},
+	}
+	synthesizer := New(WithHTTPClient(mockClient), WithRetries(2), WithBackoff(time.Millisecond))
+	output := &bytes.Buffer{}
+	result, err := synthesizer.SynthesizeRequest(context.Background(), Request{Input: "hi"}, output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if result.Retries != 2 || attempts != 3 {
+		t.Errorf("Expected 2 retries and 3 attempts, got %d retries and %d attempts", result.Retries, attempts)
+	}
+	if result.RequestID != "req_123" || result.Bytes != int64(len("Mock audio data")) {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestSynthesizeRequest_NoRetryOnClientError(t *testing.T) {
+	attempts := 0
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			attempts++
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       io.NopCloser(strings.NewReader("Bad request")),
+			}, nil
+		},
+	}
+	synthesizer := New(WithHTTPClient(mockClient), WithRetries(3), WithBackoff(time.Millisecond))
+	_, err := synthesizer.SynthesizeRequest(context.Background(), Request{Input: "hi"}, io.Discard)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected APIError with status 400, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+}
+
+func TestSynthesizeChunks(t *testing.T) {
+	var inputs []string
+	mockClient := &MockHTTPClient{
+		DoFunc: func(req *http.Request) (*http.Response, error) {
+			body, _ := io.ReadAll(req.Body)
+			inputs = append(inputs, string(body))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("audio")),
+			}, nil
+		},
+	}
+	synthesizer := New(
+		WithHTTPClient(mockClient),
+		WithChunker(SizeChunker{Size: 10}),
+		WithDefaults(Request{Voice: "onyx"}),
+	)
+
+	var chunks []Chunk
+	for chunk, err := range synthesizer.SynthesizeChunks(context.Background(), "This is a test. ") {
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("Expected 2 chunks, got %d", len(chunks))
+	}
+	if chunks[1].Index != 1 || chunks[1].Text != " test. " || string(chunks[1].Audio) != "audio" {
+		t.Errorf("Unexpected second chunk: %+v", chunks[1])
+	}
+	if !strings.Contains(inputs[0], `"voice":"onyx"`) || !strings.Contains(inputs[0], `"model":"tts-1-hd"`) {
+		t.Errorf("Expected request to use configured defaults, got %s", inputs[0])
+	}
+
+	output := &bytes.Buffer{}
+	if err := synthesizer.Synthesize(context.Background(), "This is a test. ", output); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if output.String() != "audioaudio" {
+		t.Errorf("Expected concatenated audio, got '%s'", output.String())
+	}
+}
+
+func TestPerMinute(t *testing.T) {
+	if limiter := PerMinute(0); limiter != nil {
+		t.Errorf("Expected nil limiter for unlimited rate, got %v", limiter)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := PerMinute(1).Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if delay := parseRetryAfter("3"); delay != 3*time.Second {
+		t.Errorf("Expected 3s, got %v", delay)
+	}
+	if delay := parseRetryAfter(""); delay != 0 {
+		t.Errorf("Expected 0, got %v", delay)
+	}
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// disclosureKey names the tag that marks audio as synthetic speech.
+const disclosureKey = "SYNTHETIC_SPEECH"
+
+var trackNumber = regexp.MustCompile(`^[1-9][0-9]*(/[1-9][0-9]*)?$`)
+
+// audioTags are the metadata written into output files.
+type audioTags struct {
+	Title   string
+	Artist  string
+	Album   string
+	Track   string
+	Comment string
+	// Disclosure records that the audio is synthetic speech and how it was
+	// made. It is empty unless --disclose is set.
+	Disclosure string
+	// Chapters are written as ID3 chapter frames.
+	Chapters []chapterMark
+}
+
+func registerTagFlags(fs *flag.FlagSet, flags *Flags) {
+	fs.StringVar(&flags.Title, "title", "", "Title tag of the output")
+	fs.StringVar(&flags.Artist, "artist", "", "Artist tag of the output")
+	fs.StringVar(&flags.Album, "album", "", "Album tag of the output")
+	fs.Func("track", "Track number tag of the output, such as 3 or 3/12", func(value string) error {
+		if !trackNumber.MatchString(value) {
+			return fmt.Errorf("track must be a number such as 3 or 3/12")
+		}
+		flags.Track = value
+		return nil
+	})
+	fs.StringVar(&flags.Comment, "comment", "", "Comment tag of the output")
+	fs.BoolVar(&flags.Disclose, "disclose", false, "Tag the output as synthetic speech with the model and voices used")
+}
+
+// newAudioTags returns the tags set by the flags for audio synthesized from
+// segments.
+func newAudioTags(flags Flags, segments []segment) audioTags {
+	tags := audioTags{
+		Title:   flags.Title,
+		Artist:  flags.Artist,
+		Album:   flags.Album,
+		Track:   flags.Track,
+		Comment: flags.Comment,
+	}
+	if flags.Disclose {
+		tags.Disclosure = disclosure(flags, segments)
+	}
+	return tags
+}
+
+// disclosure describes the model and the voices the segments are read with.
+func disclosure(flags Flags, segments []segment) string {
+	var voices []string
+	seen := make(map[string]bool)
+	for _, segment := range segments {
+		if segment.Pause > 0 {
+			continue
+		}
+		voice := segment.request(flags).Voice
+		if !seen[voice] {
+			seen[voice] = true
+			voices = append(voices, voice)
+		}
+	}
+	if len(voices) == 0 {
+		voices = append(voices, flags.VoiceOption)
+	}
+	label := "voice"
+	if len(voices) > 1 {
+		label = "voices"
+	}
+	return fmt.Sprintf("Synthetic speech generated with OpenAI %s, %s %s", flags.ModelOption, label, strings.Join(voices, ", "))
+}
+
+// partTags sets the tags of a part from the book. A book with several parts
+// is an album of numbered tracks titled after the parts.
+func partTags(flags Flags, b book, number int) Flags {
+	flags.Title, flags.Artist, flags.Album, flags.Track, flags.Comment = b.Title, b.Author, b.Album, b.Track, b.Comment
+	if len(b.Parts) > 1 {
+		flags.Title = b.Parts[number-1].Title
+		if flags.Album == "" {
+			flags.Album = b.Title
+		}
+		flags.Track = fmt.Sprintf("%d/%d", number, len(b.Parts))
+	}
+	return flags
+}
+
+func (t audioTags) empty() bool {
+	return t.Title == "" && t.Artist == "" && t.Album == "" && t.Track == "" &&
+		t.Comment == "" && t.Disclosure == "" && len(t.Chapters) == 0
+}
+
+// tagAudio writes tags into the audio file in the native format of its
+// container. Formats without a tag format are left untouched. A file that
+// cannot be tagged is kept as it is.
+func tagAudio(path, format string, tags audioTags) {
+	if tags.empty() {
+		return
+	}
+	if err := writeTags(path, format, tags); err != nil {
+		slog.Warn("Unable to tag audio, keeping it untagged", "file", path, "error", err)
+	}
+}
+
+func writeTags(path, format string, tags audioTags) error {
+	var tag func([]byte, audioTags) ([]byte, error)
+	switch format {
+	case "mp3", "aac":
+		tag = tagID3
+	case "flac":
+		tag = tagFLAC
+	case "opus":
+		tag = tagOpus
+	case "wav":
+		tag = tagWAV
+	default:
+		slog.Debug("Format has no tags, leaving audio untagged", "file", path, "format", format)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read audio: %w", err)
+	}
+	tagged, err := tag(data, tags)
+	if err != nil {
+		return err
+	}
+	tmpFile := path + ".tmp"
+	if err := os.WriteFile(tmpFile, tagged, 0o644); err != nil {
+		return fmt.Errorf("unable to write tagged audio: %w", err)
+	}
+	if err := os.Rename(tmpFile, path); err != nil {
+		_ = os.Remove(tmpFile)
+		return fmt.Errorf("unable to replace audio with tagged audio: %w", err)
+	}
+	return nil
+}
+
+// tagID3 replaces a leading ID3v2 tag with an ID3v2.4 tag. MP3 and ADTS AAC
+// players both read it.
+func tagID3(data []byte, tags audioTags) ([]byte, error) {
+	data = data[id3Size(data):]
+	return append(id3Tag(tags.id3Frames()...), data...), nil
+}
+
+// id3Size returns the length of the ID3v2 tag at the start of data, or 0.
+func id3Size(data []byte) int {
+	if len(data) < 10 || string(data[0:3]) != "ID3" {
+		return 0
+	}
+	size := 10 + (int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f))
+	if data[5]&0x10 != 0 {
+		size += 10
+	}
+	return min(size, len(data))
+}
+
+func (t audioTags) id3Frames() [][]byte {
+	var frames [][]byte
+	for _, text := range []struct{ id, value string }{
+		{"TIT2", t.Title},
+		{"TPE1", t.Artist},
+		{"TALB", t.Album},
+		{"TRCK", t.Track},
+	} {
+		if text.value != "" {
+			frames = append(frames, id3Frame(text.id, id3Text(text.value)))
+		}
+	}
+	if t.Comment != "" {
+		frames = append(frames, id3Frame("COMM", append([]byte{3, 'e', 'n', 'g', 0}, t.Comment...)))
+	}
+	if t.Disclosure != "" {
+		frames = append(frames, id3Frame("TXXX", append(id3Text(disclosureKey+"\x00"), t.Disclosure...)))
+	}
+	if len(t.Chapters) > 0 {
+		frames = append(frames, id3Chapters(t.Chapters)...)
+	}
+	return frames
+}
+
+// id3Text encodes a text frame body as UTF-8.
+func id3Text(value string) []byte {
+	return append([]byte{3}, value...)
+}
+
+func id3Frame(id string, body []byte) []byte {
+	frame := make([]byte, 10, 10+len(body))
+	copy(frame, id)
+	putSyncsafe(frame[4:8], len(body))
+	return append(frame, body...)
+}
+
+func id3Tag(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	tag := make([]byte, 10, 10+len(body))
+	copy(tag, []byte{'I', 'D', '3', 4, 0, 0})
+	putSyncsafe(tag[6:10], len(body))
+	return append(tag, body...)
+}
+
+// putSyncsafe stores n in seven bits per byte, as ID3v2.4 sizes are.
+func putSyncsafe(b []byte, n int) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(n & 0x7f)
+		n >>= 7
+	}
+}
+
+// vorbisComments builds a Vorbis comment block, the tag format of FLAC and
+// Opus, keeping the encoder's vendor string.
+func vorbisComments(vendor string, tags audioTags) []byte {
+	var comments []string
+	add := func(key, value string) {
+		if value != "" {
+			comments = append(comments, key+"="+value)
+		}
+	}
+	add("TITLE", tags.Title)
+	add("ARTIST", tags.Artist)
+	add("ALBUM", tags.Album)
+	track, total, _ := strings.Cut(tags.Track, "/")
+	add("TRACKNUMBER", track)
+	add("TRACKTOTAL", total)
+	add("COMMENT", tags.Comment)
+	add(disclosureKey, tags.Disclosure)
+
+	block := binary.LittleEndian.AppendUint32(nil, uint32(len(vendor)))
+	block = append(block, vendor...)
+	block = binary.LittleEndian.AppendUint32(block, uint32(len(comments)))
+	for _, comment := range comments {
+		block = binary.LittleEndian.AppendUint32(block, uint32(len(comment)))
+		block = append(block, comment...)
+	}
+	return block
+}
+
+// vorbisVendor returns the vendor string of a Vorbis comment block.
+func vorbisVendor(block []byte) string {
+	if len(block) < 4 {
+		return ""
+	}
+	size := int(binary.LittleEndian.Uint32(block))
+	if size > len(block)-4 {
+		return ""
+	}
+	return string(block[4 : 4+size])
+}
+
+// tagFLAC replaces the VORBIS_COMMENT metadata block of a FLAC stream.
+func tagFLAC(data []byte, tags audioTags) ([]byte, error) {
+	if len(data) < 4 || string(data[0:4]) != "fLaC" {
+		return nil, fmt.Errorf("not a FLAC stream")
+	}
+	const vorbisCommentBlock = 4
+
+	var blocks [][]byte
+	vendor := "cli-tools tts"
+	offset := 4
+	for last := false; !last; {
+		if offset+4 > len(data) {
+			return nil, fmt.Errorf("truncated FLAC metadata")
+		}
+		last = data[offset]&0x80 != 0
+		blockType := data[offset] & 0x7f
+		size := int(data[offset+1])<<16 | int(data[offset+2])<<8 | int(data[offset+3])
+		if offset+4+size > len(data) {
+			return nil, fmt.Errorf("truncated FLAC metadata")
+		}
+		body := data[offset+4 : offset+4+size]
+		offset += 4 + size
+		if blockType == vorbisCommentBlock {
+			vendor = vorbisVendor(body)
+			continue
+		}
+		blocks = append(blocks, append([]byte{blockType, byte(size >> 16), byte(size >> 8), byte(size)}, body...))
+	}
+	if len(blocks) == 0 {
+		return nil, fmt.Errorf("FLAC stream has no STREAMINFO")
+	}
+
+	comments := vorbisComments(vendor, tags)
+	size := len(comments)
+	comment := append([]byte{vorbisCommentBlock, byte(size >> 16), byte(size >> 8), byte(size)}, comments...)
+	// STREAMINFO must stay the first block.
+	blocks = append(blocks[:1], append([][]byte{comment}, blocks[1:]...)...)
+	blocks[len(blocks)-1][0] |= 0x80
+
+	tagged := []byte("fLaC")
+	for _, block := range blocks {
+		tagged = append(tagged, block...)
+	}
+	return append(tagged, data[offset:]...), nil
+}
+
+// tagOpus replaces the OpusTags packet of an Ogg Opus stream. The packet
+// fills the pages after the OpusHead page, so those pages are rebuilt and the
+// audio pages after them are renumbered.
+func tagOpus(data []byte, tags audioTags) ([]byte, error) {
+	var pages []oggPage
+	for offset := 0; offset < len(data); {
+		page, size, err := readOggPage(data[offset:])
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, page)
+		offset += size
+	}
+	if len(pages) < 2 || !bytes.HasPrefix(pages[0].Data, []byte("OpusHead")) {
+		return nil, fmt.Errorf("not an Ogg Opus stream")
+	}
+
+	var packet []byte
+	end := 1
+	for ; end < len(pages); end++ {
+		packet = append(packet, pages[end].Data...)
+		if pages[end].complete() {
+			end++
+			break
+		}
+	}
+	if !bytes.HasPrefix(packet, []byte("OpusTags")) {
+		return nil, fmt.Errorf("Ogg Opus stream has no OpusTags packet")
+	}
+
+	comments := append([]byte("OpusTags"), vorbisComments(vorbisVendor(packet[8:]), tags)...)
+	tagPages := oggPackets(pages[0].Serial, 1, comments)
+
+	tagged := pages[0].bytes()
+	for _, page := range tagPages {
+		tagged = append(tagged, page.bytes()...)
+	}
+	for i, page := range pages[end:] {
+		page.Sequence = uint32(1 + len(tagPages) + i)
+		tagged = append(tagged, page.bytes()...)
+	}
+	return tagged, nil
+}
+
+type oggPage struct {
+	HeaderType byte
+	Granule    uint64
+	Serial     uint32
+	Sequence   uint32
+	Segments   []byte
+	Data       []byte
+}
+
+func readOggPage(data []byte) (oggPage, int, error) {
+	if len(data) < 27 || string(data[0:4]) != "OggS" {
+		return oggPage{}, 0, fmt.Errorf("invalid Ogg page")
+	}
+	count := int(data[26])
+	if len(data) < 27+count {
+		return oggPage{}, 0, fmt.Errorf("truncated Ogg page")
+	}
+	segments := data[27 : 27+count]
+	size := 27 + count
+	for _, segment := range segments {
+		size += int(segment)
+	}
+	if len(data) < size {
+		return oggPage{}, 0, fmt.Errorf("truncated Ogg page")
+	}
+	return oggPage{
+		HeaderType: data[5],
+		Granule:    binary.LittleEndian.Uint64(data[6:14]),
+		Serial:     binary.LittleEndian.Uint32(data[14:18]),
+		Sequence:   binary.LittleEndian.Uint32(data[18:22]),
+		Segments:   segments,
+		Data:       data[27+count : size],
+	}, size, nil
+}
+
+// complete reports whether the last packet on the page ends on it.
+func (p oggPage) complete() bool {
+	return len(p.Segments) > 0 && p.Segments[len(p.Segments)-1] < 255
+}
+
+func (p oggPage) bytes() []byte {
+	page := make([]byte, 27, 27+len(p.Segments)+len(p.Data))
+	copy(page, "OggS")
+	page[5] = p.HeaderType
+	binary.LittleEndian.PutUint64(page[6:14], p.Granule)
+	binary.LittleEndian.PutUint32(page[14:18], p.Serial)
+	binary.LittleEndian.PutUint32(page[18:22], p.Sequence)
+	page[26] = byte(len(p.Segments))
+	page = append(page, p.Segments...)
+	page = append(page, p.Data...)
+	binary.LittleEndian.PutUint32(page[22:26], oggCRC(page))
+	return page
+}
+
+// oggPackets lays a header packet out on pages starting at sequence.
+func oggPackets(serial, sequence uint32, packet []byte) []oggPage {
+	lacing := bytes.Repeat([]byte{255}, len(packet)/255)
+	lacing = append(lacing, byte(len(packet)%255))
+
+	var pages []oggPage
+	for len(lacing) > 0 {
+		count := min(len(lacing), 255)
+		size := 0
+		for _, segment := range lacing[:count] {
+			size += int(segment)
+		}
+		page := oggPage{Serial: serial, Sequence: sequence, Segments: lacing[:count], Data: packet[:size]}
+		if len(pages) > 0 {
+			page.HeaderType = 0x01
+		}
+		if count == len(lacing) {
+			page.Granule = 0
+		} else {
+			page.Granule = ^uint64(0)
+		}
+		pages = append(pages, page)
+		lacing, packet = lacing[count:], packet[size:]
+		sequence++
+	}
+	return pages
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// tagWAV replaces the LIST INFO chunk of a WAV file, writing it in front of
+// the data chunk. A streamed data chunk of unknown size is given its size.
+func tagWAV(data []byte, tags audioTags) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+	info := wavInfo(tags)
+
+	tagged := []byte("RIFF\x00\x00\x00\x00WAVE")
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := data[offset+8:]
+		if id == "data" {
+			size = min(size, len(body))
+			tagged = append(tagged, info...)
+			tagged = append(tagged, "data"...)
+			tagged = binary.LittleEndian.AppendUint32(tagged, uint32(size))
+			tagged = append(tagged, body[:size]...)
+			if size%2 == 1 {
+				tagged = append(tagged, 0)
+			}
+			binary.LittleEndian.PutUint32(tagged[4:8], uint32(len(tagged)-8))
+			return tagged, nil
+		}
+		if size > len(body) {
+			return nil, fmt.Errorf("truncated WAV chunk %q", id)
+		}
+		if id != "LIST" || !bytes.HasPrefix(body[:size], []byte("INFO")) {
+			tagged = append(tagged, data[offset:offset+8+size]...)
+			if size%2 == 1 {
+				tagged = append(tagged, 0)
+			}
+		}
+		offset += 8 + size + size%2
+	}
+	return nil, fmt.Errorf("WAV file has no data chunk")
+}
+
+func wavInfo(tags audioTags) []byte {
+	list := []byte("INFO")
+	for _, field := range []struct{ id, value string }{
+		{"INAM", tags.Title},
+		{"IART", tags.Artist},
+		{"IPRD", tags.Album},
+		{"ITRK", tags.Track},
+		{"ICMT", tags.Comment},
+		{"ISFT", tags.Disclosure},
+	} {
+		if field.value == "" {
+			continue
+		}
+		value := append([]byte(field.value), 0)
+		list = append(list, field.id...)
+		list = binary.LittleEndian.AppendUint32(list, uint32(len(value)))
+		list = append(list, value...)
+		if len(value)%2 == 1 {
+			list = append(list, 0)
+		}
+	}
+	chunk := binary.LittleEndian.AppendUint32([]byte("LIST"), uint32(len(list)))
+	return append(chunk, list...)
+}
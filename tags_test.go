@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTags = audioTags{
+	Title:      "Chapter One",
+	Artist:     "Ann Author",
+	Album:      "The Book",
+	Track:      "1/12",
+	Comment:    "Read aloud",
+	Disclosure: "Synthetic speech generated with OpenAI tts-1, voice nova",
+}
+
+func TestTagID3(t *testing.T) {
+	frame := make([]byte, 192)
+	copy(frame, []byte{0xff, 0xf3, 0x84, 0xc4})
+	audio := bytes.Repeat(frame, 50)
+	old := id3Tag(id3Frame("TIT2", id3Text("Old Title")))
+
+	tagged, err := tagID3(append(old, audio...), testTags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.HasSuffix(tagged, audio) || bytes.Contains(tagged, []byte("Old Title")) {
+		t.Errorf("Expected the old tag to be replaced in front of the audio")
+	}
+	for _, expected := range []string{
+		"TIT2\x00\x00\x00\x0c\x00\x00\x03Chapter One",
+		"TRCK\x00\x00\x00\x05\x00\x00\x031/12",
+		"COMM\x00\x00\x00\x0f\x00\x00\x03eng\x00Read aloud",
+		"TXXX",
+		"\x03SYNTHETIC_SPEECH\x00Synthetic speech",
+	} {
+		if !bytes.Contains(tagged, []byte(expected)) {
+			t.Errorf("Expected tag to contain %q", expected)
+		}
+	}
+	if size := id3Size(tagged); size != len(tagged)-len(audio) {
+		t.Errorf("Expected tag size %d, got %d", len(tagged)-len(audio), size)
+	}
+
+	info, err := parseMP3(bytes.NewReader(tagged))
+	if err != nil || info.Duration != 1200*time.Millisecond {
+		t.Errorf("Expected the tagged audio to last 1.2s, got %v and %v", info.Duration, err)
+	}
+}
+
+func TestTagFLAC(t *testing.T) {
+	streamInfo := append([]byte{0, 0, 0, 34}, make([]byte, 34)...)
+	comment := vorbisComments("Lavf61", audioTags{Title: "Old"})
+	oldComment := append([]byte{0x84, 0, 0, byte(len(comment))}, comment...)
+	frames := []byte("\xff\xf8 audio frames")
+	flac := append(append(append([]byte("fLaC"), streamInfo...), oldComment...), frames...)
+
+	tagged, err := tagFLAC(flac, testTags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !bytes.HasPrefix(tagged, append([]byte("fLaC"), streamInfo...)) || !bytes.HasSuffix(tagged, frames) {
+		t.Fatalf("Expected STREAMINFO first and the frames kept, got %q", tagged)
+	}
+	block := tagged[4+len(streamInfo):]
+	if block[0] != 0x84 {
+		t.Errorf("Expected the comment block to be the last metadata block, got header %#x", block[0])
+	}
+	body := block[4 : len(block)-len(frames)]
+	if vendor := vorbisVendor(body); vendor != "Lavf61" {
+		t.Errorf("Expected the vendor string to be kept, got %q", vendor)
+	}
+	for _, expected := range []string{"TITLE=Chapter One", "TRACKNUMBER=1", "TRACKTOTAL=12", "SYNTHETIC_SPEECH=Synthetic"} {
+		if !bytes.Contains(body, []byte(expected)) {
+			t.Errorf("Expected comments to contain %q", expected)
+		}
+	}
+	if bytes.Contains(tagged, []byte("TITLE=Old")) {
+		t.Errorf("Expected the old comments to be replaced")
+	}
+
+	if _, err := tagFLAC([]byte("not flac"), testTags); err == nil {
+		t.Errorf("Expected error for data that is not FLAC, got nil")
+	}
+}
+
+func TestTagOpus(t *testing.T) {
+	head := oggPage{HeaderType: 0x02, Serial: 7, Segments: []byte{19}, Data: append([]byte("OpusHead"), make([]byte, 11)...)}
+	tagsPacket := append([]byte("OpusTags"), vorbisComments("libopus 1.4", audioTags{})...)
+	var opus []byte
+	opus = append(opus, head.bytes()...)
+	opus = append(opus, oggPackets(7, 1, tagsPacket)[0].bytes()...)
+	for i := range 2 {
+		audio := oggPage{Granule: uint64(960 * (i + 1)), Serial: 7, Sequence: uint32(2 + i), Segments: []byte{3}, Data: []byte{1, 2, byte(i)}}
+		if i == 1 {
+			audio.HeaderType = 0x04
+		}
+		opus = append(opus, audio.bytes()...)
+	}
+
+	long := testTags
+	long.Comment = strings.Repeat("a long comment ", 40)
+	tagged, err := tagOpus(opus, long)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var pages []oggPage
+	for offset := 0; offset < len(tagged); {
+		page, size, err := readOggPage(tagged[offset:])
+		if err != nil {
+			t.Fatalf("Expected valid pages, got %v", err)
+		}
+		stored := binary.LittleEndian.Uint32(tagged[offset+22:])
+		if crc := page.bytes(); binary.LittleEndian.Uint32(crc[22:]) != stored {
+			t.Errorf("Page %d has an invalid checksum", page.Sequence)
+		}
+		if page.Sequence != uint32(len(pages)) {
+			t.Errorf("Expected page sequence %d, got %d", len(pages), page.Sequence)
+		}
+		pages = append(pages, page)
+		offset += size
+	}
+	if len(pages) != 4 {
+		t.Fatalf("Expected 4 pages, got %d", len(pages))
+	}
+	packet := pages[1].Data
+	if !bytes.HasPrefix(packet, []byte("OpusTags")) || vorbisVendor(packet[8:]) != "libopus 1.4" {
+		t.Errorf("Expected OpusTags with the vendor kept, got %q", packet)
+	}
+	if !bytes.Contains(packet, []byte("ARTIST=Ann Author")) || !bytes.Contains(packet, []byte(long.Comment)) {
+		t.Errorf("Expected the new comments in OpusTags")
+	}
+	if pages[3].HeaderType != 0x04 || pages[3].Granule != 1920 || !bytes.Equal(pages[3].Data, []byte{1, 2, 1}) {
+		t.Errorf("Expected the audio pages to be kept, got %+v", pages[3])
+	}
+}
+
+func TestOggCRC(t *testing.T) {
+	if crc := oggCRC([]byte("123456789")); crc != 0x89a1897f {
+		t.Errorf("Expected CRC 0x89a1897f, got %#x", crc)
+	}
+}
+
+func TestOggPackets(t *testing.T) {
+	pages := oggPackets(1, 1, make([]byte, 255*255+10))
+	if len(pages) != 2 {
+		t.Fatalf("Expected 2 pages, got %d", len(pages))
+	}
+	if pages[0].Granule != ^uint64(0) || pages[0].complete() || len(pages[0].Data) != 255*255 {
+		t.Errorf("Expected a full first page that continues, got %d bytes", len(pages[0].Data))
+	}
+	if pages[1].HeaderType != 0x01 || pages[1].Sequence != 2 || !pages[1].complete() || len(pages[1].Data) != 10 {
+		t.Errorf("Expected a continued last page, got %+v", pages[1])
+	}
+}
+
+func TestTagWAV(t *testing.T) {
+	wav := makeWAV(24000, 1, 16, 24000, 0xFFFFFFFF)
+	tagged, err := tagWAV(wav, testTags)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	tagged, err = tagWAV(tagged, audioTags{Title: "Retagged"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if bytes.Contains(tagged, []byte("Chapter One")) || !bytes.Contains(tagged, []byte("INAM\x09\x00\x00\x00Retagged\x00\x00")) {
+		t.Errorf("Expected one INFO list with the new title, got %q", tagged[:80])
+	}
+	if size := binary.LittleEndian.Uint32(tagged[4:8]); int(size) != len(tagged)-8 {
+		t.Errorf("Expected RIFF size %d, got %d", len(tagged)-8, size)
+	}
+	info, err := parseWAV(bytes.NewReader(tagged))
+	if err != nil || info.Duration != time.Second {
+		t.Errorf("Expected the tagged audio to last 1s, got %v and %v", info.Duration, err)
+	}
+}
+
+func TestWriteTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audio.wav")
+	if err := os.WriteFile(path, makeWAV(24000, 1, 16, 100, 200), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tagAudio(path, "wav", testTags)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("IART\x0b\x00\x00\x00Ann Author\x00")) {
+		t.Errorf("Expected the file to be tagged")
+	}
+
+	pcm := filepath.Join(t.TempDir(), "audio.pcm")
+	if err := os.WriteFile(pcm, []byte{1, 2, 3, 4}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTags(pcm, "pcm", testTags); err != nil {
+		t.Errorf("Expected raw PCM to be left untagged, got %v", err)
+	}
+}
+
+func TestPartTags(t *testing.T) {
+	b := book{Title: "The Book", Author: "Ann Author", Track: "4", Parts: []part{{Title: "Opening"}, {Title: "The Return"}}}
+	flags := partTags(Flags{}, b, 2)
+	if flags.Title != "The Return" || flags.Album != "The Book" || flags.Artist != "Ann Author" || flags.Track != "2/2" {
+		t.Errorf("Unexpected tags for a chapter: %+v", newAudioTags(flags, nil))
+	}
+
+	b.Parts = b.Parts[:1]
+	flags = partTags(Flags{}, b, 1)
+	if flags.Title != "The Book" || flags.Album != "" || flags.Track != "4" {
+		t.Errorf("Unexpected tags for a single part: %+v", newAudioTags(flags, nil))
+	}
+}
+
+func TestDisclosure(t *testing.T) {
+	flags := Flags{ModelOption: "tts-1-hd", VoiceOption: "nova", Disclose: true}
+	segments := []segment{{Text: "Hi."}, {Pause: time.Second, Voice: "echo"}, {Text: "Hello.", Voice: "onyx"}, {Text: "Bye."}}
+	tags := newAudioTags(flags, segments)
+	if expected := "Synthetic speech generated with OpenAI tts-1-hd, voices nova, onyx"; tags.Disclosure != expected {
+		t.Errorf("Expected %q, got %q", expected, tags.Disclosure)
+	}
+
+	flags.Disclose = false
+	if tags := newAudioTags(flags, segments); !tags.empty() {
+		t.Errorf("Expected no tags without --disclose, got %+v", tags)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+const (
+	defaultBufferStart = "Begin Text"
+	defaultBufferEnd   = "End Text"
+
+	trimWindow     = 10 * time.Millisecond
+	trimMinSilence = 120 * time.Millisecond
+	trimMinPhrase  = 200 * time.Millisecond
+	trimMaxPhrase  = 3 * time.Second
+	trimPadding    = 40 * time.Millisecond
+	// Windows quieter than this fraction of the loudest window count as silence.
+	trimThreshold = 0.1
+)
+
+// bufferFor returns the buffer phrases selected with --buffer-start and
+// --buffer-end. Each phrase sits on its own line around the chunk.
+func bufferFor(flags Flags) synth.Buffer {
+	if flags.BufferStart == "" && flags.BufferEnd == "" {
+		return synth.Buffer{}
+	}
+	var buffer synth.Buffer
+	if flags.BufferStart != "" {
+		buffer.Start = flags.BufferStart + "\n"
+	}
+	if flags.BufferEnd != "" {
+		buffer.End = "\n" + flags.BufferEnd
+	}
+	return buffer
+}
+
+// spokenText returns a chunk's text as heard in its audio, without the buffer
+// phrases trimBufferWords cuts from it.
+func spokenText(text string, flags Flags) string {
+	if !flags.BufferTextFlag || flags.KeepBuffer {
+		return text
+	}
+	return bufferFor(flags).Strip(text)
+}
+
+// trimBufferWords cuts the spoken buffer phrases from a synthesized chunk. The
+// phrases are found as the first pause after the lead-in and the last pause
+// before the tail-out, so the smoother onset the buffer gives is kept.
+func trimBufferWords(path string, flags Flags) {
+	if !flags.BufferTextFlag || flags.KeepBuffer {
+		return
+	}
+	trimStart, trimEnd := trimSides(flags)
+	if err := trimAudio(path, flags.FormatOption, trimStart, trimEnd); err != nil {
+		slog.Warn("Unable to trim buffer words, leaving the chunk untrimmed", "file", path, "error", err)
+	}
+}
+
+func trimAudio(path, format string, trimStart, trimEnd bool) error {
+	pcm, err := decodePCM(path, format)
+	if err != nil {
+		return err
+	}
+	samples := make([]int16, len(pcm)/pcmBytes)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[i*pcmBytes:]))
+	}
+
+	start, end, err := bufferBounds(samples, pcmSampleRate, trimStart, trimEnd)
+	if err != nil {
+		return err
+	}
+	slog.Debug("Trimming buffer words", "file", path, "start", samplesToDuration(start), "end", samplesToDuration(end))
+
+	switch format {
+	case "pcm":
+		return writeFileAtomic(path, pcm[start*pcmBytes:end*pcmBytes])
+	case "wav":
+		return writeFileAtomic(path, encodeWAV(pcm[start*pcmBytes:end*pcmBytes]))
+	}
+
+	tmp := path + ".trim"
+	args := []string{"-y", "-i", path,
+		"-ss", formatSeconds(samplesToDuration(start)),
+		"-to", formatSeconds(samplesToDuration(end)),
+	}
+	args = append(args, ffmpegOutputArgs(format)...)
+	cmd := exec.Command("ffmpeg", append(args, tmp)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("unable to cut audio: %w, stdErr: %s", err, stderr.String())
+	}
+	return os.Rename(tmp, path)
+}
+
+// bufferBounds returns the samples to keep: from shortly before speech resumes
+// after the lead-in phrase to shortly after speech stops before the tail-out
+// phrase. Without a clear pause where a phrase should end it returns an error,
+// and the audio is left as it is.
+func bufferBounds(samples []int16, sampleRate int, trimStart, trimEnd bool) (int, int, error) {
+	window := sampleRate * int(trimWindow) / int(time.Second)
+	levels := make([]float64, len(samples)/window)
+	loudest := 0.0
+	for i := range levels {
+		var sum float64
+		for _, sample := range samples[i*window : (i+1)*window] {
+			sum += float64(sample) * float64(sample)
+		}
+		levels[i] = math.Sqrt(sum / float64(window))
+		loudest = max(loudest, levels[i])
+	}
+	if loudest == 0 {
+		return 0, 0, fmt.Errorf("audio is silent")
+	}
+	silent := make([]bool, len(levels))
+	for i, level := range levels {
+		silent[i] = level < loudest*trimThreshold
+	}
+
+	minRun := int(trimMinSilence / trimWindow)
+	minPhrase := int(trimMinPhrase / trimWindow)
+	maxPhrase := int(trimMaxPhrase / trimWindow)
+	padding := int(trimPadding / trimWindow)
+
+	start, end := 0, len(levels)
+	if trimStart {
+		run, ok := findPause(silent, minRun, minPhrase, maxPhrase, false)
+		if !ok {
+			return 0, 0, fmt.Errorf("no clear pause within %s after the lead-in phrase", trimMaxPhrase)
+		}
+		start = max(run-padding, 0)
+	}
+	if trimEnd {
+		run, ok := findPause(silent, minRun, minPhrase, maxPhrase, true)
+		if !ok {
+			return 0, 0, fmt.Errorf("no clear pause within %s before the tail-out phrase", trimMaxPhrase)
+		}
+		end = min(run+padding, len(levels))
+	}
+	if start >= end {
+		return 0, 0, fmt.Errorf("no speech left between the buffer phrases")
+	}
+	return start * window, min(end*window, len(samples)), nil
+}
+
+// findPause looks for the first run of at least minRun silent windows that
+// starts between minPhrase and maxPhrase windows into the speech, so a click
+// or breath is not taken for the phrase. It returns the window where speech
+// resumes. With reverse set it searches from the end and returns the window
+// where the preceding speech stops.
+func findPause(silent []bool, minRun, minPhrase, maxPhrase int, reverse bool) (int, bool) {
+	n := len(silent)
+	at := func(i int) bool {
+		if reverse {
+			return silent[n-1-i]
+		}
+		return silent[i]
+	}
+
+	speech := 0
+	for speech < n && at(speech) {
+		speech++
+	}
+
+	run := 0
+	for i := speech; i < n && i-speech <= maxPhrase+minRun; i++ {
+		if at(i) {
+			run++
+			continue
+		}
+		if run >= minRun && i-run-speech >= minPhrase {
+			if reverse {
+				return n - i, true
+			}
+			return i, true
+		}
+		run = 0
+	}
+	return 0, false
+}
+
+// decodePCM returns the audio at path as 16-bit mono samples at the API's
+// sample rate. PCM and WAV are read directly and other formats through ffmpeg.
+func decodePCM(path, format string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read audio: %w", err)
+	}
+	switch format {
+	case "pcm":
+		return data, nil
+	case "wav":
+		return wavPCM(data)
+	}
+
+	if !isCommandAvailable("ffmpeg") {
+		return nil, errFFmpegRequired
+	}
+	cmd := exec.Command("ffmpeg", "-i", path, "-f", "s16le", "-ac", "1", "-ar", strconv.Itoa(pcmSampleRate), "-")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("unable to decode audio: %w, stdErr: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// wavPCM returns the samples of a 16-bit mono WAV file at the API's sample rate.
+func wavPCM(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAV file")
+	}
+	formatOK := false
+	for offset := 12; offset+8 <= len(data); {
+		id := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		body := data[offset+8:]
+
+		switch id {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, fmt.Errorf("WAV format chunk too short")
+			}
+			channels := binary.LittleEndian.Uint16(body[2:4])
+			rate := binary.LittleEndian.Uint32(body[4:8])
+			bits := binary.LittleEndian.Uint16(body[14:16])
+			if channels != 1 || rate != pcmSampleRate || bits != pcmBytes*8 {
+				return nil, fmt.Errorf("unsupported WAV layout: %d channels, %d Hz, %d bits", channels, rate, bits)
+			}
+			formatOK = true
+		case "data":
+			if !formatOK {
+				return nil, fmt.Errorf("WAV data chunk found before format chunk")
+			}
+			// Streamed WAV responses carry a placeholder size.
+			if size == 0 || size == 0xFFFFFFFF || size > len(body) {
+				size = len(body)
+			}
+			return body[:size-size%pcmBytes], nil
+		}
+		offset += 8 + size + size%2
+	}
+	return nil, fmt.Errorf("unable to find WAV data chunk")
+}
+
+// encodeWAV wraps 16-bit mono samples at the API's sample rate in a WAV header.
+func encodeWAV(pcm []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(36+len(pcm)))
+	buf.WriteString("WAVEfmt ")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(16))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(1))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(1))
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(pcmSampleRate))
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(pcmSampleRate*pcmBytes))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(pcmBytes))
+	_ = binary.Write(&buf, binary.LittleEndian, uint16(pcmBytes*8))
+	buf.WriteString("data")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(len(pcm)))
+	buf.Write(pcm)
+	return buf.Bytes()
+}
+
+func samplesToDuration(samples int) time.Duration {
+	return bytesToDuration(int64(samples), pcmSampleRate)
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// trimSides reports which ends of a chunk carry buffer words. The default
+// buffer has both, a custom one only the phrases that are set.
+func trimSides(flags Flags) (trimStart, trimEnd bool) {
+	buffer := bufferFor(flags)
+	if buffer == (synth.Buffer{}) {
+		return true, true
+	}
+	return buffer.Start != "", buffer.End != ""
+}
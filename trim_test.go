@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/StevenDStanton/cli-tools/tts/synth"
+)
+
+// speechPCM builds 16-bit mono PCM that alternates tones and silences of the
+// given lengths, starting with a tone.
+func speechPCM(parts ...time.Duration) []byte {
+	var pcm []byte
+	for i, part := range parts {
+		samples := int(part.Seconds() * pcmSampleRate)
+		for n := range samples {
+			var sample int16
+			if i%2 == 0 {
+				sample = int16(8000 * math.Sin(2*math.Pi*220*float64(n)/pcmSampleRate))
+			}
+			pcm = binary.LittleEndian.AppendUint16(pcm, uint16(sample))
+		}
+	}
+	return pcm
+}
+
+func TestTrimAudio(t *testing.T) {
+	ms := time.Millisecond
+	// Lead-in, pause, body with a short gap between words, pause, tail-out.
+	pcm := speechPCM(600*ms, 250*ms, 400*ms, 50*ms, 500*ms, 250*ms, 500*ms)
+
+	for _, format := range []string{"pcm", "wav"} {
+		path := filepath.Join(t.TempDir(), "chunk."+format)
+		data := pcm
+		if format == "wav" {
+			data = encodeWAV(pcm)
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("Failed to write audio: %v", err)
+		}
+
+		if err := trimAudio(path, format, true, true); err != nil {
+			t.Fatalf("Expected no error for %s, got %v", format, err)
+		}
+		duration, err := audioDuration(path, format)
+		if err != nil {
+			t.Fatalf("Failed to measure %s: %v", format, err)
+		}
+		// The 950ms body plus padding on both sides.
+		if duration < 950*ms || duration > 1100*ms {
+			t.Errorf("Expected about 1030ms of %s after trimming, got %v", format, duration)
+		}
+	}
+}
+
+func TestTrimAudioOneSide(t *testing.T) {
+	ms := time.Millisecond
+	path := filepath.Join(t.TempDir(), "chunk.pcm")
+	if err := os.WriteFile(path, speechPCM(600*ms, 250*ms, 1000*ms), 0o644); err != nil {
+		t.Fatalf("Failed to write audio: %v", err)
+	}
+	if err := trimAudio(path, "pcm", true, false); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	duration, _ := audioDuration(path, "pcm")
+	if duration < 1000*ms || duration > 1100*ms {
+		t.Errorf("Expected only the lead-in to be trimmed, got %v", duration)
+	}
+}
+
+func TestTrimBufferWordsKeepsAudioWithoutPauses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chunk.pcm")
+	pcm := speechPCM(2 * time.Second)
+	if err := os.WriteFile(path, pcm, 0o644); err != nil {
+		t.Fatalf("Failed to write audio: %v", err)
+	}
+
+	trimBufferWords(path, Flags{BufferTextFlag: true, FormatOption: "pcm"})
+
+	data, _ := os.ReadFile(path)
+	if len(data) != len(pcm) {
+		t.Errorf("Expected audio without pauses to be left alone, got %d of %d bytes", len(data), len(pcm))
+	}
+}
+
+func TestTrimBufferWordsNeedsAClearPause(t *testing.T) {
+	ms := time.Millisecond
+	path := filepath.Join(t.TempDir(), "chunk.pcm")
+	// A click and a pause before any phrase could have been spoken.
+	pcm := speechPCM(50*ms, 250*ms, 2*time.Second)
+	if err := os.WriteFile(path, pcm, 0o644); err != nil {
+		t.Fatalf("Failed to write audio: %v", err)
+	}
+
+	trimBufferWords(path, Flags{BufferTextFlag: true, FormatOption: "pcm", BufferStart: "Begin Text"})
+
+	data, _ := os.ReadFile(path)
+	if len(data) != len(pcm) {
+		t.Errorf("Expected audio without a clear pause to be left alone, got %d of %d bytes", len(data), len(pcm))
+	}
+}
+
+func TestBufferFor(t *testing.T) {
+	if buffer := bufferFor(Flags{}); buffer != (synth.Buffer{}) {
+		t.Errorf("Expected default buffer, got %+v", buffer)
+	}
+	buffer := bufferFor(Flags{BufferStart: "Okay", BufferEnd: "Thanks"})
+	if buffer.Start != "Okay\n" || buffer.End != "\nThanks" {
+		t.Errorf("Expected custom phrases on their own lines, got %+v", buffer)
+	}
+	chunks := synth.SizeChunker{BufferText: true, Buffer: buffer}.Chunk("Hello")
+	if chunks[0] != "Okay\nHello\nThanks" {
+		t.Errorf("Expected custom buffer phrases, got %q", chunks[0])
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	watchStateFile     = ".tts-watch.json"
+	watchErrorsDir     = "errors"
+	defaultSettle      = 2 * time.Second
+	defaultPoll        = 5 * time.Second
+	defaultWatchSuffix = ".md,.markdown,.txt"
+)
+
+type watchOptions struct {
+	InDir      string
+	OutDir     string
+	Settle     time.Duration
+	Poll       time.Duration
+	Polling    bool
+	Extensions []string
+}
+
+type watchedFile struct {
+	Hash        string    `json:"hash"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mod_time"`
+	Output      string    `json:"output"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	since   time.Time
+}
+
+type watcher struct {
+	flags   Flags
+	config  Config
+	options watchOptions
+	state   map[string]watchedFile
+	pending map[string]pendingFile
+}
+
+func runWatch(args []string) error {
+	flags, options, err := parseWatchFlags(args)
+	if err != nil {
+		return err
+	}
+
+	if err := setupLogger(flags, os.Stderr); err != nil {
+		return err
+	}
+
+	var config Config
+	if err := config.configure(flags.RateLimit); err != nil {
+		return fmt.Errorf("unable to configure: %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w, err := newWatcher(flags, config, options)
+	if err != nil {
+		return err
+	}
+	return w.run(ctx)
+}
+
+func parseWatchFlags(args []string) (Flags, watchOptions, error) {
+	flags := Flags{}
+	options := watchOptions{}
+	var extensions string
+
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	registerSynthesisFlags(fs, &flags)
+	registerServiceFlags(fs, &flags)
+	registerCombineFlags(fs, &flags)
+	registerNormalizeFlags(fs, &flags)
+	fs.DurationVar(&options.Settle, "settle", defaultSettle, "How long a file must stay unchanged before it is converted")
+	fs.DurationVar(&options.Poll, "poll", defaultPoll, "Directory scan interval")
+	fs.BoolVar(&options.Polling, "polling", false, "Only rescan every --poll interval, without inotify on Linux or the 500ms snapshot poll elsewhere")
+	fs.StringVar(&extensions, "ext", defaultWatchSuffix, "Comma separated list of file extensions to convert")
+
+	if err := fs.Parse(args); err != nil {
+		return flags, options, fmt.Errorf("unable to parse watch flags: %w", err)
+	}
+	if fs.NArg() != 2 {
+		return flags, options, fmt.Errorf("input and output directories must be specified. Usage: tts watch [OPTIONS] IN_DIR OUT_DIR")
+	}
+
+	options.InDir = fs.Arg(0)
+	options.OutDir = fs.Arg(1)
+	for _, ext := range strings.Split(extensions, ",") {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext != "" && !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		if ext != "" {
+			options.Extensions = append(options.Extensions, ext)
+		}
+	}
+	flags.CombineFiles = true
+	if err := validateCombineFlags(flags); err != nil {
+		return flags, options, err
+	}
+
+	return flags, options, nil
+}
+
+func newWatcher(flags Flags, config Config, options watchOptions) (*watcher, error) {
+	info, err := os.Stat(options.InDir)
+	if err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("input directory does not exist: %s", options.InDir)
+	}
+	if err := os.MkdirAll(options.OutDir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create output directory: %w", err)
+	}
+
+	w := &watcher{
+		flags:   flags,
+		config:  config,
+		options: options,
+		pending: make(map[string]pendingFile),
+	}
+	if err := w.loadState(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *watcher) run(ctx context.Context) error {
+	// The notifier uses inotify on Linux. Other platforms have no notifications
+	// here and compare directory snapshots every notifyInterval instead.
+	var changes <-chan struct{}
+	if !w.options.Polling {
+		n, err := newNotifier(w.options.InDir)
+		if err != nil {
+			slog.Warn("Change detection unavailable, falling back to polling", "error", err)
+		} else {
+			defer func() {
+				_ = n.Close()
+			}()
+			changes = n.changes
+		}
+	}
+
+	slog.Info("Watching for files", "in", w.options.InDir, "out", w.options.OutDir)
+
+	// Re-scan at least every settle interval so debounced files are picked up
+	// even when no further notifications arrive.
+	interval := min(w.options.Poll, w.options.Settle)
+	if interval <= 0 {
+		interval = defaultPoll
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		w.scan(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			slog.Info("Stopped watching")
+			return nil
+		case <-changes:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *watcher) scan(ctx context.Context, now time.Time) {
+	entries, err := os.ReadDir(w.options.InDir)
+	if err != nil {
+		slog.Error("Unable to read input directory", "error", err)
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || !w.matches(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		seen[name] = true
+
+		if done, ok := w.state[name]; ok && done.Size == info.Size() && done.ModTime.Equal(info.ModTime()) {
+			delete(w.pending, name)
+			continue
+		}
+
+		pending, ok := w.pending[name]
+		if !ok || pending.size != info.Size() || !pending.modTime.Equal(info.ModTime()) {
+			w.pending[name] = pendingFile{size: info.Size(), modTime: info.ModTime(), since: now}
+			continue
+		}
+		if now.Sub(pending.since) < w.options.Settle {
+			continue
+		}
+
+		delete(w.pending, name)
+		if ctx.Err() != nil {
+			return
+		}
+		w.process(ctx, name, info)
+	}
+
+	for name := range w.pending {
+		if !seen[name] {
+			delete(w.pending, name)
+		}
+	}
+}
+
+func (w *watcher) matches(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, allowed := range w.options.Extensions {
+		if ext == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *watcher) process(ctx context.Context, name string, info os.FileInfo) {
+	inputFile := filepath.Join(w.options.InDir, name)
+	hash, err := hashFile(inputFile)
+	if err != nil {
+		slog.Error("Unable to read file", "file", name, "error", err)
+		return
+	}
+
+	// A restart or a touch without edits must not resynthesize the file.
+	if done, ok := w.state[name]; ok && done.Hash == hash {
+		done.Size = info.Size()
+		done.ModTime = info.ModTime()
+		w.state[name] = done
+		w.saveState()
+		return
+	}
+
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	flags := w.flags
+	flags.InputFile = inputFile
+	flags.OutputFile = filepath.Join(w.options.OutDir, base+"."+flags.FormatOption)
+	if owner := w.outputOwner(name, flags.OutputFile); owner != "" {
+		err := fmt.Errorf("%s and %s would both be converted to %s, rename one of them", owner, name, flags.OutputFile)
+		slog.Error("Conversion failed", "file", name, "error", err)
+		w.fail(name, err)
+		return
+	}
+
+	slog.Info("Converting file", "file", name, "output", flags.OutputFile)
+	err = w.convert(ctx, flags)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		slog.Error("Conversion failed", "file", name, "error", err)
+		w.fail(name, err)
+		return
+	}
+
+	w.state[name] = watchedFile{
+		Hash:        hash,
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+		Output:      flags.OutputFile,
+		ProcessedAt: time.Now().UTC(),
+	}
+	w.saveState()
+	slog.Info("Converted file", "file", name, "output", flags.OutputFile)
+}
+
+// outputOwner returns another input file that is already converted to output,
+// as a.md and a.txt both are to a.mp3, or "" when the output is free.
+func (w *watcher) outputOwner(name, output string) string {
+	for other, done := range w.state {
+		if other == name || done.Output != output {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(w.options.InDir, other)); err == nil {
+			return other
+		}
+	}
+	return ""
+}
+
+func (w *watcher) convert(ctx context.Context, flags Flags) error {
+	segments, err := readSegments(flags)
+	if err != nil {
+		return err
+	}
+	if len(segments) > 1 && !isCommandAvailable("ffmpeg") {
+		return errFFmpegRequired
+	}
+	return synthesizeFile(ctx, segments, flags, w.config)
+}
+
+func (w *watcher) fail(name string, failure error) {
+	errorsDir := filepath.Join(w.options.InDir, watchErrorsDir)
+	if err := os.MkdirAll(errorsDir, 0o755); err != nil {
+		slog.Error("Unable to create errors directory", "error", err)
+		return
+	}
+
+	if err := os.Rename(filepath.Join(w.options.InDir, name), filepath.Join(errorsDir, name)); err != nil {
+		slog.Error("Unable to move failed file", "file", name, "error", err)
+	}
+
+	entry := fmt.Sprintf("%s %s: %v\n", time.Now().UTC().Format(time.RFC3339), name, failure)
+	logFile, err := os.OpenFile(filepath.Join(errorsDir, name+".log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		slog.Error("Unable to write error log", "file", name, "error", err)
+		return
+	}
+	defer func() {
+		_ = logFile.Close()
+	}()
+	if _, err := io.WriteString(logFile, entry); err != nil {
+		slog.Error("Unable to write error log", "file", name, "error", err)
+	}
+}
+
+func (w *watcher) statePath() string {
+	return filepath.Join(w.options.OutDir, watchStateFile)
+}
+
+func (w *watcher) loadState() error {
+	w.state = make(map[string]watchedFile)
+	data, err := os.ReadFile(w.statePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read watch state: %w", err)
+	}
+	if err := json.Unmarshal(data, &w.state); err != nil {
+		return fmt.Errorf("unable to parse watch state: %w", err)
+	}
+	return nil
+}
+
+func (w *watcher) saveState() {
+	data, err := json.MarshalIndent(w.state, "", "  ")
+	if err != nil {
+		slog.Error("Unable to encode watch state", "error", err)
+		return
+	}
+	if err := writeFileAtomic(w.statePath(), data); err != nil {
+		slog.Error("Unable to save watch state", "error", err)
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("unable to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
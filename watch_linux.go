@@ -0,0 +1,52 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_TO | syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_DELETE
+
+type notifier struct {
+	file    *os.File
+	changes chan struct{}
+}
+
+func newNotifier(dir string) (*notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialise inotify: %w", err)
+	}
+	if _, err := syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("unable to watch %s: %w", dir, err)
+	}
+
+	n := &notifier{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan struct{}, 1),
+	}
+	go n.read()
+	return n, nil
+}
+
+func (n *notifier) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := n.file.Read(buf); err != nil {
+			return
+		}
+		// Events only trigger a rescan, so coalesce them instead of decoding each one.
+		select {
+		case n.changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (n *notifier) Close() error {
+	return n.file.Close()
+}
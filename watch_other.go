@@ -0,0 +1,87 @@
+//go:build !linux
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// notifyInterval is how often the directory is compared against its last
+// snapshot on platforms without inotify.
+const notifyInterval = 500 * time.Millisecond
+
+type snapshotEntry struct {
+	size    int64
+	modTime time.Time
+}
+
+// notifier polls the directory and signals a change whenever a file is
+// added, removed, resized or modified.
+type notifier struct {
+	dir     string
+	changes chan struct{}
+	done    chan struct{}
+}
+
+func newNotifier(dir string) (*notifier, error) {
+	n := &notifier{
+		dir:     dir,
+		changes: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	previous := n.snapshot()
+	go n.poll(previous)
+	return n, nil
+}
+
+func (n *notifier) poll(previous map[string]snapshotEntry) {
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+		current := n.snapshot()
+		if changed(previous, current) {
+			select {
+			case n.changes <- struct{}{}:
+			default:
+			}
+		}
+		previous = current
+	}
+}
+
+func (n *notifier) snapshot() map[string]snapshotEntry {
+	entries, err := os.ReadDir(n.dir)
+	if err != nil {
+		return nil
+	}
+	snapshot := make(map[string]snapshotEntry, len(entries))
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			snapshot[entry.Name()] = snapshotEntry{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+	return snapshot
+}
+
+func changed(previous, current map[string]snapshotEntry) bool {
+	if len(previous) != len(current) {
+		return true
+	}
+	for name, entry := range current {
+		if old, ok := previous[name]; !ok || old.size != entry.size || !old.modTime.Equal(entry.modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *notifier) Close() error {
+	close(n.done)
+	return nil
+}
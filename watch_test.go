@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestWatcher(t *testing.T, inDir, outDir string, do func(req *http.Request) (*http.Response, error)) *watcher {
+	t.Helper()
+	flags := Flags{FormatOption: "mp3", VoiceOption: "nova", ModelOption: "tts-1", SpeedOption: "1.0", CombineFiles: true}
+	config := Config{OpenAIAPIKey: "test-api-key", httpClient: &MockHTTPClient{DoFunc: do}}
+	options := watchOptions{InDir: inDir, OutDir: outDir, Settle: time.Second, Poll: time.Second, Extensions: []string{".md"}}
+	w, err := newWatcher(flags, config, options)
+	if err != nil {
+		t.Fatalf("Failed to create watcher: %v", err)
+	}
+	return w
+}
+
+func TestWatcherConvertsSettledFiles(t *testing.T) {
+	inDir, outDir := t.TempDir(), t.TempDir()
+	requests := 0
+	do := func(req *http.Request) (*http.Response, error) {
+		requests++
+		return mockAudio(req)
+	}
+	input := filepath.Join(inDir, "chapter.md")
+	if err := os.WriteFile(input, []byte("Hello world"), 0o644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(inDir, "notes.pdf"), []byte("ignored"), 0o644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	w := newTestWatcher(t, inDir, outDir, do)
+	w.scan(ctx, now)
+	if requests != 0 {
+		t.Fatalf("Expected file to be debounced before conversion, got %d requests", requests)
+	}
+	w.scan(ctx, now.Add(2*time.Second))
+	if requests != 1 {
+		t.Fatalf("Expected 1 request after the file settled, got %d", requests)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outDir, "chapter.mp3"))
+	if err != nil || string(data) != "Mock audio data" {
+		t.Fatalf("Expected converted output, got '%s' (%v)", data, err)
+	}
+
+	restarted := newTestWatcher(t, inDir, outDir, do)
+	restarted.scan(ctx, now)
+	restarted.scan(ctx, now.Add(2*time.Second))
+	if requests != 1 {
+		t.Errorf("Expected finished file not to be redone after a restart, got %d requests", requests)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatalf("Failed to touch input: %v", err)
+	}
+	restarted.scan(ctx, now)
+	restarted.scan(ctx, now.Add(2*time.Second))
+	if requests != 1 {
+		t.Errorf("Expected unchanged content not to be resynthesized, got %d requests", requests)
+	}
+
+	if err := os.WriteFile(input, []byte("Hello again"), 0o644); err != nil {
+		t.Fatalf("Failed to update input: %v", err)
+	}
+	restarted.scan(ctx, now)
+	restarted.scan(ctx, now.Add(2*time.Second))
+	if requests != 2 {
+		t.Errorf("Expected edited file to be converted again, got %d requests", requests)
+	}
+}
+
+func TestWatcherMovesFailures(t *testing.T) {
+	inDir, outDir := t.TempDir(), t.TempDir()
+	w := newTestWatcher(t, inDir, outDir, func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusBadRequest,
+			Body:       io.NopCloser(strings.NewReader("Bad request")),
+		}, nil
+	})
+	if err := os.WriteFile(filepath.Join(inDir, "broken.md"), []byte("Hello"), 0o644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	now := time.Now()
+	w.scan(context.Background(), now)
+	w.scan(context.Background(), now.Add(2*time.Second))
+
+	if _, err := os.Stat(filepath.Join(inDir, "errors", "broken.md")); err != nil {
+		t.Errorf("Expected failed file to be moved to errors/, got %v", err)
+	}
+	logData, err := os.ReadFile(filepath.Join(inDir, "errors", "broken.md.log"))
+	if err != nil || !strings.Contains(string(logData), "Bad request") {
+		t.Errorf("Expected error log with the failure, got '%s' (%v)", logData, err)
+	}
+	if _, ok := w.state["broken.md"]; ok {
+		t.Errorf("Expected failed file not to be recorded as finished")
+	}
+}
+
+func TestWatcherRejectsOutputCollisions(t *testing.T) {
+	inDir, outDir := t.TempDir(), t.TempDir()
+	w := newTestWatcher(t, inDir, outDir, mockAudio)
+	w.options.Extensions = []string{".md", ".txt"}
+	for _, name := range []string{"notes.md", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(inDir, name), []byte("Hello "+name), 0o644); err != nil {
+			t.Fatalf("Failed to write input: %v", err)
+		}
+	}
+
+	now := time.Now()
+	w.scan(context.Background(), now)
+	w.scan(context.Background(), now.Add(2*time.Second))
+
+	if done, ok := w.state["notes.md"]; !ok || done.Output != filepath.Join(outDir, "notes.mp3") {
+		t.Errorf("Expected notes.md to be converted, got %+v", w.state)
+	}
+	logData, err := os.ReadFile(filepath.Join(inDir, "errors", "notes.txt.log"))
+	if err != nil || !strings.Contains(string(logData), "would both be converted") {
+		t.Errorf("Expected the collision to be logged, got '%s' (%v)", logData, err)
+	}
+}
+
+func TestParseWatchFlags(t *testing.T) {
+	flags, options, err := parseWatchFlags([]string{"-v", "onyx", "--settle", "5s", "--ext", "md, txt", "in", "out"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if flags.VoiceOption != "onyx" || !flags.CombineFiles {
+		t.Errorf("Unexpected flags: %+v", flags)
+	}
+	if options.InDir != "in" || options.OutDir != "out" || options.Settle != 5*time.Second {
+		t.Errorf("Unexpected options: %+v", options)
+	}
+	if len(options.Extensions) != 2 || options.Extensions[0] != ".md" || options.Extensions[1] != ".txt" {
+		t.Errorf("Expected normalized extensions, got %v", options.Extensions)
+	}
+
+	if _, _, err := parseWatchFlags([]string{"in"}); err == nil {
+		t.Errorf("Expected error without an output directory, got nil")
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	dir := t.TempDir()
+	n, err := newNotifier(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer func() {
+		_ = n.Close()
+	}()
+
+	if err := os.WriteFile(filepath.Join(dir, "new.md"), []byte("Hello"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	select {
+	case <-n.changes:
+	case <-time.After(2 * time.Second):
+		t.Errorf("Expected a change notification")
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlParser reads the subset of YAML used by dialogue scripts and front
+// matter: block mappings and sequences, comments, block scalars, plain scalars
+// folded across more indented lines, and quoted scalars on a single line. Flow
+// collections, anchors, aliases, tags and quoted scalars that continue on the
+// next line are rejected. Mappings decode to map[string]any, sequences to
+// []any and scalars to string.
+type yamlParser struct {
+	lines []string
+	pos   int
+}
+
+func parseYAML(data []byte) (any, error) {
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	p := &yamlParser{lines: strings.Split(text, "\n")}
+
+	indent, _, ok := p.peek()
+	if !ok {
+		return map[string]any{}, nil
+	}
+	node, err := p.parseNode(indent)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, ok := p.peek(); ok {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return node, nil
+}
+
+// peek returns the indentation and content of the next line that is not blank
+// or a comment, without consuming it.
+func (p *yamlParser) peek() (int, string, bool) {
+	for p.pos < len(p.lines) {
+		line := strings.TrimRight(p.lines[p.pos], " \t")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			p.pos++
+			continue
+		}
+		return len(line) - len(content), content, true
+	}
+	return 0, "", false
+}
+
+func (p *yamlParser) errorf(format string, args ...any) error {
+	return p.errorfAt(p.pos, format, args...)
+}
+
+// errorfAt reports an error on the line at index pos.
+func (p *yamlParser) errorfAt(pos int, format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *yamlParser) parseNode(indent int) (any, error) {
+	_, text, _ := p.peek()
+	if strings.HasPrefix(text, "\t") {
+		return nil, p.errorf("tabs are not allowed for indentation")
+	}
+	if isSequenceItem(text) {
+		return p.parseSequence(indent)
+	}
+	if _, _, ok := splitMappingEntry(text); ok {
+		return p.parseMapping(indent)
+	}
+	p.pos++
+	return p.parseScalar(stripComment(text), indent-1)
+}
+
+func (p *yamlParser) parseSequence(indent int) ([]any, error) {
+	var items []any
+	for {
+		lineIndent, text, ok := p.peek()
+		if !ok || lineIndent < indent {
+			break
+		}
+		if lineIndent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if !isSequenceItem(text) {
+			break
+		}
+
+		rest := strings.TrimLeft(text[1:], " ")
+		column := indent + len(text) - len(rest)
+		switch {
+		case rest == "":
+			p.pos++
+			item, err := p.parseChild(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		default:
+			// Parse the rest of the line as if it started on its own line at
+			// the column after the dash, so "- key: value" opens a mapping.
+			p.lines[p.pos] = strings.Repeat(" ", column) + rest
+			item, err := p.parseNode(column)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (map[string]any, error) {
+	mapping := make(map[string]any)
+	for {
+		lineIndent, text, ok := p.peek()
+		if !ok || lineIndent < indent {
+			break
+		}
+		if lineIndent > indent {
+			return nil, p.errorf("unexpected indentation")
+		}
+		if isSequenceItem(text) {
+			break
+		}
+		key, value, ok := splitMappingEntry(text)
+		if !ok {
+			return nil, p.errorf("expected \"key: value\", got %q", text)
+		}
+		if _, exists := mapping[key]; exists {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		p.pos++
+
+		var node any
+		var err error
+		if value == "" {
+			node, err = p.parseChild(indent)
+		} else {
+			node, err = p.parseScalar(value, indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+		mapping[key] = node
+	}
+	return mapping, nil
+}
+
+// parseChild parses the value of an entry whose line ended after the key or
+// dash. Sequences may start at the same indentation as their parent key.
+func (p *yamlParser) parseChild(indent int) (any, error) {
+	lineIndent, text, ok := p.peek()
+	if !ok || lineIndent < indent || (lineIndent == indent && !isSequenceItem(text)) {
+		return "", nil
+	}
+	return p.parseNode(lineIndent)
+}
+
+// parseScalar decodes a scalar that starts on an entry line at indent, which
+// has already been consumed. Plain scalars continue on more indented lines and
+// are folded with spaces.
+func (p *yamlParser) parseScalar(value string, indent int) (any, error) {
+	line := p.pos - 1
+	switch {
+	case strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">"):
+		return p.parseBlockScalar(value[0] == '>', indent), nil
+	case strings.HasPrefix(value, `"`):
+		unquoted, err := unquoteDouble(value)
+		if err != nil {
+			return nil, p.errorfAt(line, "invalid double quoted string %s: %v", value, err)
+		}
+		return unquoted, nil
+	case strings.HasPrefix(value, "'"):
+		if len(value) < 2 || !strings.HasSuffix(value, "'") {
+			return nil, p.errorfAt(line, "single quoted string %s must end on the same line, use | or > for text over several lines", value)
+		}
+		return strings.ReplaceAll(value[1:len(value)-1], "''", "'"), nil
+	case strings.HasPrefix(value, "[") || strings.HasPrefix(value, "{"):
+		return nil, p.errorfAt(line, "flow collections such as %s are not supported, quote the value if it is text", value)
+	case strings.HasPrefix(value, "&") || strings.HasPrefix(value, "*"):
+		return nil, p.errorfAt(line, "anchors and aliases such as %s are not supported", value)
+	case strings.HasPrefix(value, "!"):
+		return nil, p.errorfAt(line, "tags such as %s are not supported", value)
+	}
+
+	parts := []string{value}
+	for {
+		lineIndent, text, ok := p.peek()
+		if !ok || lineIndent <= indent {
+			break
+		}
+		parts = append(parts, stripComment(text))
+		p.pos++
+	}
+	return strings.Join(parts, " "), nil
+}
+
+// yamlEscapes maps the single character escapes of double quoted YAML
+// scalars to the text they stand for.
+var yamlEscapes = map[byte]string{
+	'0': "\x00", 'a': "\a", 'b': "\b", 't': "\t", '\t': "\t", 'n': "\n", 'v': "\v",
+	'f': "\f", 'r': "\r", 'e': "\x1b", ' ': " ", '"': `"`, '/': "/", '\\': `\`,
+	'N': "\u0085", '_': "\u00a0", 'L': "\u2028", 'P': "\u2029",
+}
+
+// unquoteDouble decodes a double quoted scalar with YAML escapes, which
+// differ from Go's: \e, \N and \_ exist, and \' does not.
+func unquoteDouble(value string) (string, error) {
+	var builder strings.Builder
+	for i := 1; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case '"':
+			if i != len(value)-1 {
+				return "", fmt.Errorf("unexpected text after the closing quote")
+			}
+			return builder.String(), nil
+		case '\\':
+			if i+1 >= len(value) {
+				return "", fmt.Errorf("must end on the same line, use | or > for text over several lines")
+			}
+			i++
+			if text, ok := yamlEscapes[value[i]]; ok {
+				builder.WriteString(text)
+				continue
+			}
+			digits := map[byte]int{'x': 2, 'u': 4, 'U': 8}[value[i]]
+			if digits == 0 || i+digits >= len(value) {
+				return "", fmt.Errorf("unknown escape \\%c", value[i])
+			}
+			code, err := strconv.ParseUint(value[i+1:i+1+digits], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid escape \\%s", value[i:i+1+digits])
+			}
+			builder.WriteRune(rune(code))
+			i += digits
+		default:
+			builder.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("must end on the same line, use | or > for text over several lines")
+}
+
+func (p *yamlParser) parseBlockScalar(folded bool, indent int) string {
+	var lines []string
+	blockIndent := -1
+	for p.pos < len(p.lines) {
+		line := strings.TrimRight(p.lines[p.pos], " \t")
+		content := strings.TrimLeft(line, " ")
+		lineIndent := len(line) - len(content)
+		if content != "" {
+			if lineIndent <= indent {
+				break
+			}
+			if blockIndent < 0 {
+				blockIndent = lineIndent
+			}
+			if lineIndent < blockIndent {
+				break
+			}
+			content = line[blockIndent:]
+		}
+		lines = append(lines, content)
+		p.pos++
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	if !folded {
+		return strings.Join(lines, "\n")
+	}
+	var builder strings.Builder
+	for i, line := range lines {
+		switch {
+		case line == "":
+			builder.WriteString("\n")
+		case i > 0 && lines[i-1] != "":
+			builder.WriteString(" " + line)
+		default:
+			builder.WriteString(line)
+		}
+	}
+	return builder.String()
+}
+
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitMappingEntry splits "key: value" on the first colon outside quotes that
+// is followed by a space or the end of the line.
+func splitMappingEntry(text string) (string, string, bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 {
+				quote = c
+			}
+		case c == '#' && i > 0 && text[i-1] == ' ':
+			return "", "", false
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			key := strings.TrimSpace(text[:i])
+			if unquoted, err := unquoteDouble(key); strings.HasPrefix(key, `"`) && err == nil {
+				key = unquoted
+			} else if len(key) >= 2 && key[0] == '\'' && key[len(key)-1] == '\'' {
+				key = strings.ReplaceAll(key[1:len(key)-1], "''", "'")
+			}
+			if key == "" {
+				return "", "", false
+			}
+			return key, stripComment(strings.TrimSpace(text[i+1:])), true
+		}
+	}
+	return "", "", false
+}
+
+// stripComment removes a trailing " # comment" that is outside quotes.
+func stripComment(value string) string {
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || value[i-1] == ' '):
+			quote = c
+		case c == '#' && (i == 0 || value[i-1] == ' '):
+			return strings.TrimSpace(value[:i])
+		}
+	}
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	data := `# comment
+title: "A \"quoted\" title"
+url: http://example.com/a#b
+plain: a line # with a comment
+wrapped: a long line # that wraps
+  onto the next # with comments
+  # and a comment line
+  and one more
+escaped: "tab\there \e[0m \x41\u00e9\N\_\/"
+folded: >
+  one
+  two
+
+  three
+list:
+- first
+- key: value
+  other: 2
+-
+  nested: true
+`
+	document, err := parseYAML([]byte(data))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	expected := map[string]any{
+		"title":   `A "quoted" title`,
+		"url":     "http://example.com/a#b",
+		"plain":   "a line",
+		"wrapped": "a long line onto the next and one more",
+		"escaped": "tab\there \x1b[0m A\u00e9\u0085\u00a0/",
+		"folded":  "one two\nthree",
+		"list": []any{
+			"first",
+			map[string]any{"key": "value", "other": "2"},
+			map[string]any{"nested": "true"},
+		},
+	}
+	if !reflect.DeepEqual(document, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, document)
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	for _, data := range []string{
+		"a:\n  - x\n  b: 1\n",
+		"a: 1\na: 2\n",
+		"a: \"unterminated\n",
+	} {
+		if _, err := parseYAML([]byte(data)); err == nil {
+			t.Errorf("Expected an error for %q", data)
+		}
+	}
+}
+
+func TestParseYAMLRejectsUnsupported(t *testing.T) {
+	cases := map[string]string{
+		"a: {b: 1}\n":                       "flow collections",
+		"a: [1, 2]\n":                       "flow collections",
+		"a: &base x\n":                      "anchors and aliases",
+		"a: *base\n":                        "anchors and aliases",
+		"a: !!str 1\n":                      "tags",
+		"a: \"a long line\n  continued\"\n": "must end on the same line",
+		"a: 'a long line\n  continued'\n":   "must end on the same line",
+		"a: \"it\\'s\"\n":                   "unknown escape",
+		"a: \"\\x4\"\n":                     "invalid escape",
+	}
+	for data, expected := range cases {
+		// The offending entry is on line 2.
+		_, err := parseYAML([]byte("first: 1\n" + data))
+		if err == nil || !strings.Contains(err.Error(), expected) || !strings.HasPrefix(err.Error(), "line 2: ") {
+			t.Errorf("Expected an error about %s on line 2 for %q, got %v", expected, data, err)
+		}
+	}
+}